@@ -0,0 +1,231 @@
+// This file contains handlers meant for operating a running server rather than
+// for serving numbers. They are not registered by default.
+package numbers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// configView is the JSON representation of the effective Config. Fields are
+// listed explicitly so that new secrets added to Config are never exposed by
+// accident. Every field of Config but its funcs, interfaces and pointers has
+// a field of the same name here, which TestConfigViewFields checks.
+type configView struct {
+	ResponseTimeout         string             `json:"response_timeout"`
+	FlushGrace              string             `json:"flush_grace"`
+	GetTimeout              string             `json:"get_timeout"`
+	MaxRetries              int                `json:"max_retries"`
+	RetryBackoff            string             `json:"retry_backoff"`
+	PerURLBudget            string             `json:"per_url_budget"`
+	RetryOnEmpty            bool               `json:"retry_on_empty"`
+	NumGoRoutines           int                `json:"goroutines"`
+	TunedGoRoutines         int                `json:"tuned_goroutines,omitempty"`
+	MaxGoRoutinesCeiling    int                `json:"goroutines_ceiling"`
+	MaxRequestBytes         int64              `json:"max_request_bytes"`
+	MaxHosts                int                `json:"max_hosts"`
+	MaxURLs                 int                `json:"max_urls"`
+	ResultBuffer            int                `json:"result_buffer"`
+	OverflowPolicy          int                `json:"overflow_policy"`
+	AbandonOnCancel         bool               `json:"abandon_on_cancel"`
+	Strategy                int                `json:"strategy"`
+	NormalizeURLs           bool               `json:"normalize_urls"`
+	DuplicateURLPolicy      int                `json:"duplicate_url_policy"`
+	FloatMode               int                `json:"float_mode"`
+	NumberKind              int                `json:"number_kind"`
+	StrictDecode            bool               `json:"strict_decode"`
+	LineDecoding            bool               `json:"line_decoding"`
+	MaxJSONDepth            int                `json:"max_json_depth"`
+	RequireNumbersField     bool               `json:"require_numbers_field"`
+	DisableDecoderRecovery  bool               `json:"disable_decoder_recovery"`
+	MaxConcurrentDecodes    int                `json:"max_concurrent_decodes"`
+	MaxDecodeTime           string             `json:"max_decode_time"`
+	RejectTrailingData      bool               `json:"reject_trailing_data"`
+	MaxValue                int                `json:"max_value"`
+	AssumeDisjoint          bool               `json:"assume_disjoint"`
+	AssumeSorted            bool               `json:"assume_sorted"`
+	KeepDuplicates          bool               `json:"keep_duplicates"`
+	CoalesceRequests        bool               `json:"coalesce_requests"`
+	Order                   int                `json:"order"`
+	EmptyStatus             int                `json:"empty_status"`
+	PerRequestMemoryCeiling int64              `json:"per_request_memory_ceiling"`
+	StreamChunkSize         int                `json:"stream_chunk_size"`
+	InlineTimeoutWarning    bool               `json:"inline_timeout_warning"`
+	RedactURLs              bool               `json:"redact_urls"`
+	PerHostRPS              map[string]float64 `json:"per_host_rps"`
+	DefaultHostRPS          float64            `json:"default_host_rps"`
+	BreakerThreshold        int                `json:"breaker_threshold"`
+	BreakerCooldown         string             `json:"breaker_cooldown"`
+	CacheTTL                string             `json:"cache_ttl"`
+	DNSCacheTTL             string             `json:"dns_cache_ttl"`
+	MaxResponseBytes        int64              `json:"max_response_bytes"`
+	MaxResponseBytesCeiling int64              `json:"max_response_bytes_ceiling"`
+	BodyIdleTimeout         string             `json:"body_idle_timeout"`
+	GetRetry                retryPolicyView    `json:"get_retry"`
+	DisableRedirects        bool               `json:"disable_redirects"`
+	UnixSockets             bool               `json:"unix_sockets"`
+	MinTLSVersion           uint16             `json:"min_tls_version"`
+	IdleConnTimeout         string             `json:"idle_conn_timeout"`
+	IdleCleanupInterval     string             `json:"idle_cleanup_interval"`
+
+	// Headers may carry credentials, so only their names are listed.
+	Headers       []string            `json:"headers"`
+	PerURLHeaders map[string][]string `json:"per_url_headers"`
+}
+
+// retryPolicyView is the JSON representation of a RetryPolicy.
+type retryPolicyView struct {
+	MaxAttempts int     `json:"max_attempts"`
+	BaseDelay   string  `json:"base_delay"`
+	Multiplier  float64 `json:"multiplier"`
+	Jitter      float64 `json:"jitter"`
+}
+
+// newConfigView returns the view of cfg.
+func newConfigView(cfg *Config) *configView {
+	view := &configView{
+		ResponseTimeout:         cfg.ResponseTimeout.String(),
+		FlushGrace:              cfg.FlushGrace.String(),
+		GetTimeout:              cfg.GetTimeout.String(),
+		MaxRetries:              cfg.MaxRetries,
+		RetryBackoff:            cfg.RetryBackoff.String(),
+		PerURLBudget:            cfg.PerURLBudget.String(),
+		RetryOnEmpty:            cfg.RetryOnEmpty,
+		NumGoRoutines:           cfg.NumGoRoutines,
+		MaxGoRoutinesCeiling:    cfg.MaxGoRoutinesCeiling,
+		MaxRequestBytes:         cfg.MaxRequestBytes,
+		MaxHosts:                cfg.MaxHosts,
+		MaxURLs:                 cfg.MaxURLs,
+		ResultBuffer:            cfg.ResultBuffer,
+		OverflowPolicy:          int(cfg.OverflowPolicy),
+		AbandonOnCancel:         cfg.AbandonOnCancel,
+		Strategy:                int(cfg.Strategy),
+		NormalizeURLs:           cfg.NormalizeURLs,
+		DuplicateURLPolicy:      int(cfg.DuplicateURLPolicy),
+		FloatMode:               int(cfg.FloatMode),
+		NumberKind:              int(cfg.NumberKind),
+		StrictDecode:            cfg.StrictDecode,
+		LineDecoding:            cfg.LineDecoding,
+		MaxJSONDepth:            cfg.MaxJSONDepth,
+		RequireNumbersField:     cfg.RequireNumbersField,
+		DisableDecoderRecovery:  cfg.DisableDecoderRecovery,
+		MaxConcurrentDecodes:    cfg.MaxConcurrentDecodes,
+		MaxDecodeTime:           cfg.MaxDecodeTime.String(),
+		RejectTrailingData:      cfg.RejectTrailingData,
+		MaxValue:                cfg.MaxValue,
+		AssumeDisjoint:          cfg.AssumeDisjoint,
+		AssumeSorted:            cfg.AssumeSorted,
+		KeepDuplicates:          cfg.KeepDuplicates,
+		CoalesceRequests:        cfg.CoalesceRequests,
+		Order:                   int(cfg.Order),
+		EmptyStatus:             cfg.EmptyStatus,
+		PerRequestMemoryCeiling: cfg.PerRequestMemoryCeiling,
+		StreamChunkSize:         cfg.StreamChunkSize,
+		InlineTimeoutWarning:    cfg.InlineTimeoutWarning,
+		RedactURLs:              cfg.RedactURLs,
+		PerHostRPS:              cfg.PerHostRPS,
+		DefaultHostRPS:          cfg.DefaultHostRPS,
+		BreakerThreshold:        cfg.BreakerThreshold,
+		BreakerCooldown:         cfg.BreakerCooldown.String(),
+		CacheTTL:                cfg.CacheTTL.String(),
+		DNSCacheTTL:             cfg.DNSCacheTTL.String(),
+		MaxResponseBytes:        cfg.MaxResponseBytes,
+		MaxResponseBytesCeiling: cfg.maxBytesCeiling(),
+		BodyIdleTimeout:         cfg.BodyIdleTimeout.String(),
+		GetRetry: retryPolicyView{
+			MaxAttempts: cfg.GetRetry.MaxAttempts,
+			BaseDelay:   cfg.GetRetry.BaseDelay.String(),
+			Multiplier:  cfg.GetRetry.Multiplier,
+			Jitter:      cfg.GetRetry.Jitter,
+		},
+		DisableRedirects:    cfg.DisableRedirects,
+		UnixSockets:         cfg.UnixSockets,
+		MinTLSVersion:       cfg.MinTLSVersion,
+		IdleConnTimeout:     cfg.IdleConnTimeout.String(),
+		IdleCleanupInterval: cfg.IdleCleanupInterval.String(),
+		Headers:             headerNames(cfg.Headers),
+		PerURLHeaders:       make(map[string][]string),
+	}
+	for url, h := range cfg.PerURLHeaders {
+		view.PerURLHeaders[url] = headerNames(h)
+	}
+	return view
+}
+
+// headerNames returns the sorted names of the headers in h.
+func headerNames(h http.Header) []string {
+	names := []string{}
+	for k := range h {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ConfigHandler returns a handler that responds to GET requests with the
+// effective configuration of ng as JSON, including defaults filled in for
+// unset values.
+func (ng *NumbersGetter) ConfigHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		ng.setup.Do(ng.setDefaults)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		view := newConfigView(&ng.Config)
+		view.TunedGoRoutines = int(ng.workers.Load())
+		json.NewEncoder(w).Encode(view)
+	})
+}
+
+// TuneHandler returns a handler that adjusts the settings of ng at runtime.
+// It responds to POST requests authorized with the bearer token, and accepts
+// the form value goroutines to set the number of goroutines new requests query
+// their URLs with. Requests already being served keep theirs. An empty token
+// authorizes no request.
+func (ng *NumbersGetter) TuneHandler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		auth := []byte(r.Header.Get("Authorization"))
+		if token == "" || subtle.ConstantTimeCompare(auth, []byte("Bearer "+token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid request form", http.StatusBadRequest)
+			return
+		}
+
+		n, err := strconv.Atoi(r.Form.Get("goroutines"))
+		if err != nil || n <= 0 {
+			http.Error(w, "goroutines must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		ng.workers.Store(int64(n))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// ReplayHandler returns a handler that queries the URLs POSTed to it again,
+// such as the failed ones listed under replay in the responses to requests
+// with ?includeErrors=1. The body and query options are those of POST
+// requests to ng.
+func (ng *NumbersGetter) ReplayHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		ng.ServeHTTP(w, r)
+	})
+}
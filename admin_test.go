@@ -0,0 +1,262 @@
+// Tests for the operational handlers.
+package numbers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConfigHandler(t *testing.T) {
+	exp := map[string]interface{}{
+		"response_timeout":           "480ms",
+		"flush_grace":                "0s",
+		"get_timeout":                "450ms",
+		"max_retries":                float64(2),
+		"retry_backoff":              "10ms",
+		"per_url_budget":             "0s",
+		"retry_on_empty":             false,
+		"goroutines":                 float64(numGoRoutines),
+		"goroutines_ceiling":         float64(100),
+		"max_request_bytes":          float64(1 << 16),
+		"max_hosts":                  float64(3),
+		"max_urls":                   float64(50),
+		"result_buffer":              float64(0),
+		"overflow_policy":            float64(0),
+		"abandon_on_cancel":          false,
+		"strategy":                   float64(0),
+		"normalize_urls":             true,
+		"duplicate_url_policy":       float64(0),
+		"float_mode":                 float64(FloatRound),
+		"number_kind":                float64(0),
+		"strict_decode":              false,
+		"line_decoding":              false,
+		"max_json_depth":             float64(32),
+		"require_numbers_field":      false,
+		"disable_decoder_recovery":   false,
+		"max_concurrent_decodes":     float64(0),
+		"max_decode_time":            "0s",
+		"reject_trailing_data":       false,
+		"max_value":                  float64(0),
+		"assume_disjoint":            false,
+		"assume_sorted":              false,
+		"keep_duplicates":            false,
+		"coalesce_requests":          false,
+		"order":                      float64(0),
+		"empty_status":               float64(http.StatusNoContent),
+		"per_request_memory_ceiling": float64(1 << 24),
+		"stream_chunk_size":          float64(0),
+		"inline_timeout_warning":     false,
+		"redact_urls":                false,
+		"per_host_rps":               nil,
+		"default_host_rps":           float64(0),
+		"breaker_threshold":          float64(5),
+		"breaker_cooldown":           "0s",
+		"cache_ttl":                  "1m0s",
+		"dns_cache_ttl":              "0s",
+		"max_response_bytes":         float64(1 << 20),
+		"max_response_bytes_ceiling": float64(1 << 20),
+		"body_idle_timeout":          "0s",
+		"get_retry": map[string]interface{}{
+			"max_attempts": float64(3),
+			"base_delay":   "20ms",
+			"multiplier":   float64(0),
+			"jitter":       float64(0),
+		},
+		"disable_redirects":     false,
+		"unix_sockets":          false,
+		"min_tls_version":       float64(0),
+		"idle_conn_timeout":     "0s",
+		"idle_cleanup_interval": "0s",
+		"headers":               []interface{}{"Authorization", "User-Agent"},
+		"per_url_headers": map[string]interface{}{
+			"http://a": []interface{}{"X-Token"},
+		},
+	}
+
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout:  480 * time.Millisecond,
+		GetTimeout:       450 * time.Millisecond,
+		NormalizeURLs:    true,
+		FloatMode:        FloatRound,
+		EmptyStatus:      http.StatusNoContent,
+		MaxResponseBytes: 1 << 20,
+		MaxRetries:       2,
+		RetryBackoff:     10 * time.Millisecond,
+		MaxHosts:         3,
+		MaxURLs:          50,
+		MaxRequestBytes:  1 << 16,
+		MaxJSONDepth:     32,
+		CacheTTL:         time.Minute,
+		BreakerThreshold: 5,
+		GetRetry:         RetryPolicy{MaxAttempts: 3, BaseDelay: 20 * time.Millisecond},
+
+		MaxGoRoutinesCeiling:    100,
+		PerRequestMemoryCeiling: 1 << 24,
+		Headers: http.Header{
+			"Authorization": {"Bearer secret"},
+			"User-Agent":    {"numbers"},
+		},
+		PerURLHeaders: map[string]http.Header{
+			"http://a": {"X-Token": {"secret"}},
+		},
+		URLGetter: staticGetter{},
+	}}
+
+	w := serve(ng.ConfigHandler(), "/config")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status mismatch: %s", comp(http.StatusOK, w.Code))
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("config mismatch: %s", comp(exp, got))
+	}
+	if strings.Contains(w.Body.String(), "secret") {
+		t.Fatalf("secrets not redacted: %s", comp("no header values", w.Body.String()))
+	}
+}
+
+func TestConfigViewFields(t *testing.T) {
+	// private lists the scalar fields of Config deliberately left out of
+	// configView, such as secrets.
+	private := map[string]bool{}
+
+	view := reflect.TypeOf(configView{})
+	cfg := reflect.TypeOf(Config{})
+	for i := 0; i < cfg.NumField(); i++ {
+		f := cfg.Field(i)
+		if !f.IsExported() || private[f.Name] {
+			continue
+		}
+		switch f.Type.Kind() {
+		case reflect.Func, reflect.Interface, reflect.Pointer, reflect.Chan:
+			continue
+		}
+		if _, ok := view.FieldByName(f.Name); !ok {
+			t.Errorf("Config.%s missing from configView", f.Name)
+		}
+	}
+}
+
+func TestTuneHandler(t *testing.T) {
+	pg := &peakGetter{delay: 20 * time.Millisecond}
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: time.Second,
+		NumGoRoutines:   8,
+		URLGetter:       pg,
+	}}
+	var urls []string
+	for i := 0; i < 16; i++ {
+		urls = append(urls, "u=http://"+strconv.Itoa(i))
+	}
+	target := "/numbers?" + strings.Join(urls, "&")
+
+	serve(ng, target)
+	if peak := pg.reset(); peak != 8 {
+		t.Fatalf("peak workers mismatch before tuning: %s", comp(8, peak))
+	}
+
+	tune := ng.TuneHandler("token")
+	if w := tuneRequest(tune, "wrong", "goroutines=2"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthorized tuning status mismatch: %s", comp(http.StatusUnauthorized, w.Code))
+	}
+	if w := tuneRequest(tune, "token", "goroutines=0"); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid tuning status mismatch: %s", comp(http.StatusBadRequest, w.Code))
+	}
+	if w := tuneRequest(tune, "token", "goroutines=2"); w.Code != http.StatusNoContent {
+		t.Fatalf("tuning status mismatch: %s", comp(http.StatusNoContent, w.Code))
+	}
+
+	serve(ng, target)
+	if peak := pg.reset(); peak != 2 {
+		t.Fatalf("peak workers mismatch after tuning: %s", comp(2, peak))
+	}
+}
+
+func TestReplayHandler(t *testing.T) {
+	exp := []int{2, 3}
+
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		URLGetter:       staticGetter{"http://a": `{"numbers":[1]}`},
+	}}
+	var resp numbersResponse
+	body := serve(ng, "/numbers?includeErrors=1&u=http://a&u=http://b&u=http://c").Body.Bytes()
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("invalid response %q: %v", body, err)
+	}
+	if resp.Replay == nil || len(resp.Replay.URLs) != 2 {
+		t.Fatalf("replay mismatch: %s", comp("the 2 failed URLs", resp.Replay))
+	}
+
+	// The failed URLs respond once replayed.
+	ng.URLGetter = staticGetter{"http://b": `{"numbers":[2]}`, "http://c": `{"numbers":[3]}`}
+	replay, _ := json.Marshal(resp.Replay)
+	w := httptest.NewRecorder()
+	ng.ReplayHandler().ServeHTTP(w, httptest.NewRequest("POST", "/replay", strings.NewReader(string(replay))))
+	if got := decodeResponse(t, w.Body.Bytes()); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("replayed numbers mismatch: %s", comp(exp, got))
+	}
+
+	if w := serve(ng.ReplayHandler(), "/replay"); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("status mismatch for GET: %s", comp(http.StatusMethodNotAllowed, w.Code))
+	}
+}
+
+// tuneRequest POSTs the form to the tune handler with the bearer token.
+func tuneRequest(h http.Handler, token, form string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/admin/tune", strings.NewReader(form))
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// peakGetter responds to every GET with a single number after the delay, and
+// records the peak number of GETs in flight.
+type peakGetter struct {
+	delay time.Duration
+
+	mu       sync.Mutex
+	inFlight int
+	peak     int
+}
+
+func (p *peakGetter) Get(ctx context.Context, url string) ([]byte, error) {
+	p.mu.Lock()
+	if p.inFlight++; p.inFlight > p.peak {
+		p.peak = p.inFlight
+	}
+	p.mu.Unlock()
+
+	time.Sleep(p.delay)
+
+	p.mu.Lock()
+	p.inFlight--
+	p.mu.Unlock()
+	return []byte(`{"numbers":[1]}`), nil
+}
+
+func (p *peakGetter) Client() *http.Client {
+	return nil
+}
+
+// reset returns the peak number of GETs in flight and resets it.
+func (p *peakGetter) reset() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peak := p.peak
+	p.peak = 0
+	return peak
+}
@@ -0,0 +1,149 @@
+// This file contains the per host circuit breakers, which stop querying the
+// hosts that keep failing for a while instead of waiting on each of their
+// URLs in turn.
+package numbers
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultBreakerCooldown is how long a breaker stays open unless configured
+// otherwise.
+const defaultBreakerCooldown = 30 * time.Second
+
+// HostHealth is the state of the circuit breaker of a host. See
+// Config.HostHealth.
+type HostHealth struct {
+	Host string `json:"host"`
+
+	// Failures is the number of consecutive failed GETs to the host.
+	Failures int `json:"failures"`
+
+	// OpenUntil is the time until which the URLs of the host are not
+	// queried. It is zero if the breaker never opened.
+	OpenUntil time.Time `json:"open_until,omitempty"`
+}
+
+// hostBreakers tracks the consecutive failures of every host, opening the
+// breaker of a host for cooldown once they reach threshold. Once the cooldown
+// elapsed, a single GET is let through as a probe: the breaker closes if it
+// succeeds, and opens again at once otherwise. The other GETs to the host are
+// refused until then.
+type hostBreakers struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+// hostState is the breaker of a host.
+type hostState struct {
+	HostHealth
+
+	// probing tells that the probe of the host is in flight.
+	probing bool
+}
+
+func newHostBreakers(threshold int, cooldown time.Duration) *hostBreakers {
+	if cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+	return &hostBreakers{threshold: threshold, cooldown: cooldown, hosts: make(map[string]*hostState)}
+}
+
+// allow reports whether the URLs of host can be queried at now. Once the
+// cooldown of an open breaker elapsed, it only lets the probe through. The
+// outcome of a GET allowed must then be recorded, or the GET released.
+func (b *hostBreakers) allow(host string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h, ok := b.hosts[host]
+	switch {
+	case !ok || h.OpenUntil.IsZero():
+		return true
+	case now.Before(h.OpenUntil) || h.probing:
+		return false
+	}
+	h.probing = true
+	return true
+}
+
+// release gives up on a GET to host that allow let through, without
+// recording its outcome, so that another GET can probe the host.
+func (b *hostBreakers) release(host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if h, ok := b.hosts[host]; ok {
+		h.probing = false
+	}
+}
+
+// record records the outcome of a GET to host made at now.
+func (b *hostBreakers) record(host string, now time.Time, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		delete(b.hosts, host)
+		return
+	}
+	h, ok := b.hosts[host]
+	if !ok {
+		h = &hostState{HostHealth: HostHealth{Host: host}}
+		b.hosts[host] = h
+	}
+	h.Failures++
+	if h.Failures >= b.threshold {
+		h.OpenUntil = now.Add(b.cooldown)
+		h.probing = false
+	}
+}
+
+// health returns the state of every host with failures, sorted by host.
+func (b *hostBreakers) health() []HostHealth {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	health := make([]HostHealth, 0, len(b.hosts))
+	for _, h := range b.hosts {
+		health = append(health, h.HostHealth)
+	}
+	sort.Slice(health, func(i, j int) bool { return health[i].Host < health[j].Host })
+	return health
+}
+
+// HostHealth returns the state of the circuit breakers of the hosts whose
+// last GETs failed, sorted by host. It is empty unless BreakerThreshold is
+// set.
+func (cfg *Config) HostHealth() []HostHealth {
+	if cfg.breakers == nil {
+		return []HostHealth{}
+	}
+	return cfg.breakers.health()
+}
+
+// urlHost returns the lowercased host name of target, without its port, or
+// "" if target cannot be parsed. Breakers and rate limits are keyed by it.
+func urlHost(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// hostFailed reports whether err is a failure of the host itself, rather
+// than one of its response or of the request.
+func hostFailed(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch classify(err) {
+	case reasonTimeout, reasonStatus, reasonNetwork:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+// Tests for the per host circuit breakers.
+package numbers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBreakerSkipsOpenHost(t *testing.T) {
+	fc := newFakeClock()
+	cfg := &Config{
+		NumGoRoutines:    4,
+		BreakerThreshold: 1,
+		BreakerCooldown:  time.Minute,
+		Clock:            fc,
+		URLGetter: &countingGetter{URLGetter: &delayGetter{
+			bodies: map[string]string{"http://up/1": `{"numbers":[1]}`, "http://down/2": `{"numbers":[2]}`},
+			delays: map[string]time.Duration{"http://down/2": time.Hour},
+		}},
+	}
+	cfg.setDefaults()
+
+	if res := fetchResponse(context.Background(), cfg, "http://down/1"); res.err == nil {
+		t.Fatal("expected the first GET to the host to fail")
+	}
+	exp := []HostHealth{{Host: "down", Failures: 1, OpenUntil: fc.Now().Add(time.Minute)}}
+	if got := cfg.HostHealth(); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("host health mismatch: %s", comp(exp, got))
+	}
+
+	start := time.Now()
+	errs := make(map[string]error)
+	for r := range processResults(context.Background(), cfg, []string{"http://up/1", "http://down/2", "http://DOWN/3"}) {
+		errs[r.url] = r.err
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("URLs of the open host not skipped at once: %s", comp("< 1s", elapsed))
+	}
+	if errs["http://up/1"] != nil {
+		t.Fatalf("unexpected error for another host: %v", errs["http://up/1"])
+	}
+	for _, url := range []string{"http://down/2", "http://DOWN/3"} {
+		if !errors.Is(errs[url], ErrCircuitOpen) {
+			t.Fatalf("error mismatch for %s: %s", url, comp(ErrCircuitOpen, errs[url]))
+		}
+		if got := classify(errs[url]); got != reasonOpen {
+			t.Fatalf("reason mismatch for %s: %s", url, comp(reasonOpen, got))
+		}
+		if got := cfg.URLGetter.(*countingGetter).count(url); got != 0 {
+			t.Fatalf("GETs of %s mismatch: %s", url, comp(0, got))
+		}
+	}
+
+	// Once the cooldown elapsed the host is queried again, and a success
+	// closes its breaker.
+	fc.Advance(time.Minute)
+	cfg.URLGetter = staticGetter{"http://down/2": `{"numbers":[2]}`}
+	if res := fetchResponse(context.Background(), cfg, "http://down/2"); res.err != nil {
+		t.Fatalf("unexpected error after the cooldown: %v", res.err)
+	}
+	if got := cfg.HostHealth(); len(got) != 0 {
+		t.Fatalf("host health mismatch: %s", comp("[]", got))
+	}
+}
+
+func TestBreakerThreshold(t *testing.T) {
+	now := time.Now()
+	b := newHostBreakers(3, time.Minute)
+	for i := 0; i < 2; i++ {
+		b.record("h", now, true)
+	}
+	if !b.allow("h", now) {
+		t.Fatal("breaker opened before reaching the threshold")
+	}
+	// A success resets the count of consecutive failures.
+	b.record("h", now, false)
+	b.record("h", now, true)
+	b.record("h", now, true)
+	if !b.allow("h", now) {
+		t.Fatal("failures before a success counted towards the threshold")
+	}
+	b.record("h", now, true)
+	if b.allow("h", now) {
+		t.Fatal("breaker not opened at the threshold")
+	}
+	if !b.allow("other", now) {
+		t.Fatal("breaker of another host opened")
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	now := time.Now()
+	b := newHostBreakers(1, time.Minute)
+	b.record("h", now, true)
+	if b.allow("h", now) {
+		t.Fatal("breaker not opened")
+	}
+
+	// Once the cooldown elapsed, a single probe goes through.
+	now = now.Add(time.Minute)
+	if !b.allow("h", now) {
+		t.Fatal("probe refused after the cooldown")
+	}
+	if b.allow("h", now) {
+		t.Fatal("second GET let through while probing")
+	}
+
+	// A failed probe opens the breaker again for the whole cooldown.
+	b.record("h", now, true)
+	if b.allow("h", now.Add(time.Second)) {
+		t.Fatal("breaker not opened again by a failed probe")
+	}
+
+	// A released probe lets another one through.
+	now = now.Add(time.Minute)
+	if !b.allow("h", now) {
+		t.Fatal("probe refused after the second cooldown")
+	}
+	b.release("h")
+	if !b.allow("h", now) {
+		t.Fatal("probe refused after the previous one was released")
+	}
+
+	// A successful probe closes the breaker.
+	b.record("h", now, false)
+	for i := 0; i < 2; i++ {
+		if !b.allow("h", now) {
+			t.Fatal("GET refused once the breaker closed")
+		}
+	}
+}
+
+func TestBreakerIgnoresCallerDeadline(t *testing.T) {
+	getter := &delayGetter{
+		bodies: map[string]string{"http://slow/1": `{"numbers":[1]}`},
+		delays: map[string]time.Duration{"http://slow/1": 200 * time.Millisecond},
+	}
+	cfg := &Config{BreakerThreshold: 1, URLGetter: getter}
+	cfg.setDefaults()
+
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		for range processResults(ctx, cfg, []string{"http://slow/1"}) {
+		}
+		cancel()
+	}
+	if got := cfg.HostHealth(); len(got) != 0 {
+		t.Fatalf("host health mismatch: %s", comp("[]", got))
+	}
+	if res := fetchResponse(context.Background(), cfg, "http://slow/1"); res.err != nil {
+		t.Fatalf("unexpected error once the caller has time: %v", res.err)
+	}
+}
+
+func TestSummaryCountsOpenCircuitSkipped(t *testing.T) {
+	exp := &urlSummary{Succeeded: 1, Skipped: 2}
+
+	c := &collection{results: []urlResult{
+		{url: "http://a", numbers: []int{1}},
+		{url: "http://b", err: ErrCircuitOpen},
+		{url: "http://c", err: ErrSkipped},
+	}}
+	if got := c.summary(); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("summary mismatch: %s", comp(exp, got))
+	}
+}
@@ -0,0 +1,222 @@
+// This file contains a URLGetter that caches the responses of another one, so
+// that URLs queried again within a TTL are not fetched again.
+package numbers
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CacheStore stores the responses cached by CachingGetter, keyed by URL, or
+// by request key and URL for the requests of a NumbersGetter with a
+// ContextEnricher. It must be safe for concurrent use. Implementations backed
+// by a shared store, such as Redis or memcached, let several instances share
+// their cache.
+type CacheStore interface {
+	// Get returns the value stored for key, if it has not expired.
+	Get(key string) ([]byte, bool)
+
+	// Set stores val for key, to expire after ttl.
+	Set(key string, val []byte, ttl time.Duration)
+}
+
+// CachingGetter is a URLGetter returning the cached response of URLs queried
+// within its TTL, and querying the URLGetter it wraps otherwise. Only
+// successful responses are cached, along with their status code and headers
+// so that they are decoded the same way as when they were received.
+type CachingGetter struct {
+	getter URLGetter
+	store  CacheStore
+	ttl    time.Duration
+}
+
+// NewCachingGetter returns a getter caching the responses of g for ttl in
+// store. A nil store caches them in memory.
+func NewCachingGetter(g URLGetter, ttl time.Duration, store CacheStore) *CachingGetter {
+	if store == nil {
+		store = NewMemoryCacheStore()
+	}
+	return &CachingGetter{getter: g, store: store, ttl: ttl}
+}
+
+// Get returns the body of the cached response of url, or GETs it with the
+// wrapped getter and caches the response.
+func (c *CachingGetter) Get(ctx context.Context, url string) ([]byte, error) {
+	resp, err := c.GetResponse(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// GetResponse works like Get, keeping the status and headers of the response
+// if the wrapped getter reports them.
+func (c *CachingGetter) GetResponse(ctx context.Context, url string) (*Response, error) {
+	scope, cached := ctx.Value(cacheScopeKey{}).(cacheScope)
+	if cached && scope.off {
+		return get(ctx, c.getter, url)
+	}
+	key := url
+	if scope.key != "" {
+		// The length keeps keys unambiguous whatever the scope holds.
+		key = strconv.Itoa(len(scope.key)) + ":" + scope.key + "\n" + url
+	}
+	if val, ok := c.store.Get(key); ok {
+		// Values that cannot be decoded, such as those stored by an older
+		// version sharing the store, are queried again.
+		var resp Response
+		if err := gob.NewDecoder(bytes.NewReader(val)).Decode(&resp); err == nil {
+			// The body may have been cached for a request allowing larger
+			// ones than this, which are refused like the default getter
+			// does.
+			if max := maxResponseBytes(ctx, 0); max > 0 && int64(len(resp.Body)) > max {
+				return &Response{StatusCode: resp.StatusCode, Header: resp.Header}, ErrTooLarge
+			}
+			return &resp, nil
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	resp, err := get(ctx, c.getter, url)
+	if err != nil {
+		return resp, err
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(resp); err == nil {
+		c.store.Set(key, buf.Bytes(), c.ttl)
+	}
+	return resp, nil
+}
+
+// cacheScopeKey is the context key of the cacheScope of a GET.
+type cacheScopeKey struct{}
+
+// cacheScope is the part of the cache a GET of CachingGetter may use: either
+// the responses stored under key, or none if off.
+type cacheScope struct {
+	key string
+	off bool
+}
+
+// withCacheScope returns a copy of ctx in which CachingGetter keeps the
+// responses apart from those of other keys.
+func withCacheScope(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, cacheScopeKey{}, cacheScope{key: key})
+}
+
+// withoutCache returns a copy of ctx in which CachingGetter neither returns
+// nor stores cached responses.
+func withoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheScopeKey{}, cacheScope{off: true})
+}
+
+// Clear removes every cached response, so that the URLs are queried again.
+// It reports false, leaving the cache as it is, if the store has no Clear
+// method to remove its values with.
+func (c *CachingGetter) Clear() bool {
+	clearer, ok := c.store.(interface{ Clear() })
+	if !ok {
+		return false
+	}
+	clearer.Clear()
+	return true
+}
+
+// ClearCache clears the cache of the URLGetter set up from CacheTTL, or of
+// the CachingGetter set as URLGetter. It reports false if there is none, or
+// if its store cannot be cleared. See CachingGetter.Clear.
+func (cfg *Config) ClearCache() bool {
+	c, ok := cfg.URLGetter.(*CachingGetter)
+	return ok && c.Clear()
+}
+
+// Client returns the http.Client of the wrapped getter.
+func (c *CachingGetter) Client() *http.Client {
+	return c.getter.Client()
+}
+
+// memoryEntry is a value of a memoryCacheStore.
+type memoryEntry struct {
+	val     []byte
+	expires time.Time
+}
+
+// memoryCacheSweepInterval is how often a memoryCacheStore removes every
+// expired value, including those never looked up again.
+const memoryCacheSweepInterval = time.Minute
+
+// memoryCacheStore is a CacheStore keeping its values in memory. Expired
+// values are removed once they are looked up, and all at once by the first
+// Set every memoryCacheSweepInterval. It can be cleared with
+// CachingGetter.Clear.
+type memoryCacheStore struct {
+	clock Clock
+
+	mu        sync.RWMutex
+	entries   map[string]memoryEntry
+	nextSweep time.Time
+}
+
+// NewMemoryCacheStore returns a CacheStore keeping its values in the memory
+// of the process.
+func NewMemoryCacheStore() CacheStore {
+	return newMemoryCacheStore(realClock{})
+}
+
+func newMemoryCacheStore(clock Clock) *memoryCacheStore {
+	return &memoryCacheStore{clock: clock, entries: make(map[string]memoryEntry)}
+}
+
+func (s *memoryCacheStore) Get(key string) ([]byte, bool) {
+	s.mu.RLock()
+	entry, ok := s.entries[key]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	if !s.clock.Now().Before(entry.expires) {
+		s.mu.Lock()
+		// The entry may have been set again in the meantime.
+		if cur, ok := s.entries[key]; ok && cur.expires.Equal(entry.expires) {
+			delete(s.entries, key)
+		}
+		s.mu.Unlock()
+		return nil, false
+	}
+	return entry.val, true
+}
+
+func (s *memoryCacheStore) Set(key string, val []byte, ttl time.Duration) {
+	now := s.clock.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = memoryEntry{val: val, expires: now.Add(ttl)}
+	if !now.Before(s.nextSweep) {
+		s.sweep(now)
+		s.nextSweep = now.Add(memoryCacheSweepInterval)
+	}
+}
+
+// sweep removes the values expired at now. The live ones are moved to a new
+// map, since maps do not shrink as their keys are deleted. s.mu must be held.
+func (s *memoryCacheStore) sweep(now time.Time) {
+	live := make(map[string]memoryEntry, len(s.entries))
+	for key, entry := range s.entries {
+		if now.Before(entry.expires) {
+			live[key] = entry
+		}
+	}
+	s.entries = live
+}
+
+func (s *memoryCacheStore) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = make(map[string]memoryEntry)
+}
@@ -0,0 +1,224 @@
+// Tests for the caching getter and its stores.
+package numbers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCachingGetterStore(t *testing.T) {
+	exp := `{"numbers":[1]}`
+
+	cg := &countingGetter{URLGetter: staticGetter{"http://a": exp}}
+	store := &fakeCacheStore{entries: make(map[string][]byte)}
+	g := NewCachingGetter(cg, time.Minute, store)
+
+	for i := 0; i < 3; i++ {
+		body, err := g.Get(context.Background(), "http://a")
+		if err != nil || string(body) != exp {
+			t.Fatalf("response mismatch: %s", comp(exp, string(body)))
+		}
+	}
+	if got := cg.count("http://a"); got != 1 {
+		t.Fatalf("GETs mismatch: %s", comp(1, got))
+	}
+	if expTTLs := map[string]time.Duration{"http://a": time.Minute}; !reflect.DeepEqual(expTTLs, store.ttls) {
+		t.Fatalf("stored TTLs mismatch: %s", comp(expTTLs, store.ttls))
+	}
+
+	// Once the store expires the entry, the URL is queried again.
+	store.expire("http://a")
+	if _, err := g.Get(context.Background(), "http://a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := cg.count("http://a"); got != 2 {
+		t.Fatalf("GETs mismatch after expiry: %s", comp(2, got))
+	}
+
+	// Failures are not cached.
+	for i := 0; i < 2; i++ {
+		if _, err := g.Get(context.Background(), "http://down"); err == nil {
+			t.Fatal("expected an error for a failing URL")
+		}
+	}
+	if got := cg.count("http://down"); got != 2 {
+		t.Fatalf("GETs of a failing URL mismatch: %s", comp(2, got))
+	}
+	if _, ok := store.entries["http://down"]; ok {
+		t.Fatal("failed response cached")
+	}
+}
+
+func TestConfigCacheTTL(t *testing.T) {
+	cg := &countingGetter{URLGetter: staticGetter{"http://a": `{"numbers":[1]}`}}
+	cfg := &Config{ResponseTimeout: 500 * time.Millisecond, CacheTTL: time.Minute, URLGetter: cg}
+
+	for i := 0; i < 3; i++ {
+		if _, err := MergeNumbers(context.Background(), cfg, []string{"http://a"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if got := cg.count("http://a"); got != 1 {
+		t.Fatalf("GETs mismatch within the TTL: %s", comp(1, got))
+	}
+
+	if !cfg.ClearCache() {
+		t.Fatal("cache not cleared")
+	}
+	if _, err := MergeNumbers(context.Background(), cfg, []string{"http://a"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := cg.count("http://a"); got != 2 {
+		t.Fatalf("GETs mismatch once cleared: %s", comp(2, got))
+	}
+
+	// Stores without a Clear method are left alone.
+	g := NewCachingGetter(cg, time.Minute, &fakeCacheStore{entries: make(map[string][]byte)})
+	if g.Clear() {
+		t.Fatal("store without Clear reported cleared")
+	}
+}
+
+func TestServeHTTPCacheTTLKeepsHeaders(t *testing.T) {
+	exp := []int{1, 2}
+
+	getter := typedGetter{"http://a": {
+		Body:       []byte(`{"2":true,"1":true}`),
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {ObjectKeysContentType}},
+	}}
+	ng := &NumbersGetter{Config: Config{ResponseTimeout: 500 * time.Millisecond, CacheTTL: time.Minute, URLGetter: getter}}
+
+	for i := 0; i < 2; i++ {
+		var resp numbersResponse
+		body := serve(ng, "/numbers?debug=1&u=http://a").Body.Bytes()
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatalf("invalid response %q: %v", body, err)
+		}
+		if !reflect.DeepEqual(exp, resp.Numbers) {
+			t.Fatalf("numbers mismatch for request %d: %s", i, comp(exp, resp.Numbers))
+		}
+		if len(resp.Debug) != 1 || resp.Debug[0].Status != http.StatusOK {
+			t.Fatalf("debug status mismatch for request %d: %s", i, comp(http.StatusOK, resp.Debug))
+		}
+		// The second request is served from the cache.
+		delete(getter, "http://a")
+	}
+}
+
+func TestCachingGetterCancelledMiss(t *testing.T) {
+	cg := &countingGetter{URLGetter: staticGetter{"http://a": `{"numbers":[1]}`}}
+	g := NewCachingGetter(cg, time.Minute, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := g.Get(ctx, "http://a"); err != context.Canceled {
+		t.Fatalf("error mismatch: %s", comp(context.Canceled, err))
+	}
+	if got := cg.count("http://a"); got != 0 {
+		t.Fatalf("GETs mismatch: %s", comp(0, got))
+	}
+}
+
+func TestCachingGetterMaxBytes(t *testing.T) {
+	exp := `{"numbers":[1,2,3,4,5,6,7,8,9,10]}`
+
+	cg := &countingGetter{URLGetter: staticGetter{"http://a": exp}}
+	g := NewCachingGetter(cg, time.Minute, nil)
+	if body, err := g.Get(context.Background(), "http://a"); err != nil || string(body) != exp {
+		t.Fatalf("response mismatch: %s", comp(exp, string(body)))
+	}
+
+	// The cached body is refused to the requests limited below its size.
+	if _, err := g.Get(withMaxResponseBytes(context.Background(), 5), "http://a"); err != ErrTooLarge {
+		t.Fatalf("error mismatch above the limit: %s", comp(ErrTooLarge, err))
+	}
+	if body, err := g.Get(withMaxResponseBytes(context.Background(), int64(len(exp))), "http://a"); err != nil || string(body) != exp {
+		t.Fatalf("response mismatch at the limit: %s", comp(exp, string(body)))
+	}
+	if got := cg.count("http://a"); got != 1 {
+		t.Fatalf("GETs mismatch: %s", comp(1, got))
+	}
+}
+
+func TestMemoryCacheStoreTTL(t *testing.T) {
+	fc := newFakeClock()
+	s := newMemoryCacheStore(fc)
+	s.Set("k", []byte("v"), time.Minute)
+
+	fc.Advance(59 * time.Second)
+	if val, ok := s.Get("k"); !ok || string(val) != "v" {
+		t.Fatalf("value mismatch before expiry: %s", comp("v", string(val)))
+	}
+	fc.Advance(time.Second)
+	if val, ok := s.Get("k"); ok {
+		t.Fatalf("expired value returned: %s", comp(nil, string(val)))
+	}
+	if _, ok := s.entries["k"]; ok {
+		t.Fatal("expired value not removed")
+	}
+}
+
+func TestMemoryCacheStoreSweep(t *testing.T) {
+	fc := newFakeClock()
+	s := newMemoryCacheStore(fc)
+	s.Set("a", []byte("v"), time.Second)
+	s.Set("b", []byte("v"), time.Hour)
+
+	// Expired values are kept until the next sweep is due.
+	fc.Advance(2 * time.Second)
+	s.Set("c", []byte("v"), time.Second)
+	if _, ok := s.entries["a"]; !ok {
+		t.Fatal("expired value removed before the sweep")
+	}
+
+	// Once it is, those never looked up again are removed as well.
+	fc.Advance(memoryCacheSweepInterval)
+	s.Set("d", []byte("v"), time.Hour)
+	exp := []string{"b", "d"}
+	var got []string
+	for key := range s.entries {
+		got = append(got, key)
+	}
+	sort.Strings(got)
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("values mismatch after the sweep: %s", comp(exp, got))
+	}
+}
+
+// fakeCacheStore is a CacheStore whose values only expire when told to. It
+// records the TTL every key was last set with.
+type fakeCacheStore struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+	ttls    map[string]time.Duration
+}
+
+func (s *fakeCacheStore) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	val, ok := s.entries[key]
+	return val, ok
+}
+
+func (s *fakeCacheStore) Set(key string, val []byte, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ttls == nil {
+		s.ttls = make(map[string]time.Duration)
+	}
+	s.entries[key] = val
+	s.ttls[key] = ttl
+}
+
+func (s *fakeCacheStore) expire(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+}
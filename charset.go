@@ -0,0 +1,58 @@
+// This file contains the normalization of response bodies to UTF-8 without a
+// byte order mark, before they are decoded.
+package numbers
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// utf8BOM is the byte order mark some servers prefix UTF-8 bodies with.
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// normalizeText returns body without a leading UTF-8 byte order mark, and
+// converted to UTF-8 if contentType declares it as Latin-1. Bodies in other
+// charsets are returned as they are, as the digits most numbers are written
+// with are the same in all the common ones. Only text and JSON bodies, or
+// bodies without a content type, are normalized, and binary ones are left
+// alone.
+func normalizeText(body []byte, contentType string) []byte {
+	var params map[string]string
+	if contentType != "" {
+		mediaType, p, err := mime.ParseMediaType(contentType)
+		if err != nil || !strings.HasPrefix(mediaType, "text/") && !strings.HasSuffix(mediaType, "json") {
+			return body
+		}
+		params = p
+	}
+
+	body = bytes.TrimPrefix(body, utf8BOM)
+	switch strings.ToLower(params["charset"]) {
+	case "iso-8859-1", "latin1", "l1":
+		return latin1ToUTF8(body)
+	}
+	return body
+}
+
+// latin1ToUTF8 returns the UTF-8 encoding of the Latin-1 text in b, in which
+// every byte is the code point of its character.
+func latin1ToUTF8(b []byte) []byte {
+	ascii := true
+	for _, c := range b {
+		if c >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return b
+	}
+
+	out := make([]byte, 0, len(b)+len(b)/2)
+	for _, c := range b {
+		out = utf8.AppendRune(out, rune(c))
+	}
+	return out
+}
@@ -0,0 +1,53 @@
+// Tests for the normalization of response bodies to UTF-8.
+package numbers
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestFetchResponseBOM(t *testing.T) {
+	exp := []int{3, 1, 2}
+
+	RegisterDecoder("text/x-test-numbers", lineDecoder{})
+	cfg := &Config{URLGetter: typedGetter{
+		"http://text": {Body: []byte("\xef\xbb\xbf3\n1\n2"), StatusCode: 200, Header: http.Header{
+			"Content-Type": {"text/x-test-numbers; charset=utf-8"},
+		}},
+		"http://json": {Body: []byte("\xef\xbb\xbf{\"numbers\":[3,1,2]}"), StatusCode: 200},
+	}}
+
+	for _, url := range []string{"http://text", "http://json"} {
+		res := fetchResponse(context.Background(), cfg, url)
+		if res.err != nil {
+			t.Fatalf("unexpected error for %s: %v", url, res.err)
+		}
+		if !reflect.DeepEqual(exp, res.numbers) {
+			t.Fatalf("numbers mismatch for %s: %s", url, comp(exp, res.numbers))
+		}
+	}
+}
+
+func TestNormalizeTextLatin1(t *testing.T) {
+	for contentType, exp := range map[string]string{
+		"text/plain; charset=ISO-8859-1": "café 1\n½ 2",
+		"text/plain; charset=latin1":     "café 1\n½ 2",
+		// Undeclared charsets are left alone.
+		"text/plain": "caf\xe9 1\n\xbd 2",
+	} {
+		if got := string(normalizeText([]byte("caf\xe9 1\n\xbd 2"), contentType)); got != exp {
+			t.Fatalf("normalized body mismatch for %s: %s", contentType, comp(exp, got))
+		}
+	}
+
+	// A BOM in front of a Latin-1 body is stripped before converting it.
+	if got := string(normalizeText([]byte("\xef\xbb\xbf7"), "text/plain; charset=latin1")); got != "7" {
+		t.Fatalf("normalized body mismatch: %s", comp("7", got))
+	}
+	// Binary bodies are left alone.
+	if got := string(normalizeText([]byte("\xef\xbb\xbf7"), "application/x-protobuf")); got != "\xef\xbb\xbf7" {
+		t.Fatalf("binary body modified: %s", comp("\xef\xbb\xbf7", got))
+	}
+}
@@ -0,0 +1,66 @@
+// Package client implements a client for the HTTP API served by
+// numbers.NumbersGetter, for Go programs querying a running numbers server.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client queries the /numbers endpoint of a numbers server. The zero value is
+// ready to use.
+type Client struct {
+	// HTTPClient is used to make the requests. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+}
+
+// response is the JSON body returned by the /numbers endpoint.
+type response struct {
+	Numbers []int
+}
+
+// Fetch asks the server at baseURL to query urls and returns the merged,
+// sorted numbers. Non-200 responses, other than an empty 204 result, are
+// returned as errors.
+func (c *Client) Fetch(ctx context.Context, baseURL string, urls []string) ([]int, error) {
+	query := url.Values{"u": urls}
+	req, err := http.NewRequest("GET", strings.TrimSuffix(baseURL, "/")+"/numbers?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return []int{}, nil
+	default:
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("numbers server responded %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	res := response{}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("error reading numbers server response: %v", err)
+	}
+	return res.Numbers, nil
+}
+
+func (c *Client) client() *http.Client {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return c.HTTPClient
+}
@@ -0,0 +1,49 @@
+// Tests for the numbers API client.
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"numbers"
+	"numbers/internal/testutil"
+)
+
+func TestClientFetch(t *testing.T) {
+	exp := []int{1, 2, 3, 5, 8}
+
+	ng := &numbers.NumbersGetter{Config: numbers.Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		URLGetter: testutil.StaticGetter{
+			"http://fibo":   `{"numbers":[1,1,2,3,5,8]}`,
+			"http://primes": `{"numbers":[2,3,5]}`,
+		},
+	}}
+	ts := httptest.NewServer(ng)
+	defer ts.Close()
+
+	c := &Client{}
+	got, err := c.Fetch(context.Background(), ts.URL, []string{"http://fibo", "http://primes"})
+	if err != nil {
+		t.Fatalf("unexpected fetch error: %v", err)
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("numbers mismatch: %s", testutil.Comp(exp, got))
+	}
+}
+
+func TestClientFetchStatusError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	c := &Client{}
+	if _, err := c.Fetch(context.Background(), ts.URL, []string{"http://fibo"}); err == nil {
+		t.Fatalf("non-200 response not reported: %s", testutil.Comp("error", nil))
+	}
+}
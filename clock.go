@@ -0,0 +1,97 @@
+// This file contains the clock the timeouts of the package are measured with,
+// which tests can replace to trigger timeouts without waiting for them.
+package numbers
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock tells the time for the timeouts, deadlines and backoffs of the
+// package. See Config.Clock.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	Since(t time.Time) time.Duration
+}
+
+// realClock is the Clock of the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+
+// clock returns cfg.Clock, or the real clock if it is unset.
+func (cfg *Config) clock() Clock {
+	if cfg.Clock != nil {
+		return cfg.Clock
+	}
+	return realClock{}
+}
+
+// withTimeout works like context.WithTimeout, but measures the timeout with
+// clock.
+func withTimeout(ctx context.Context, clock Clock, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := clock.(realClock); ok {
+		return context.WithTimeout(ctx, d)
+	}
+
+	deadline := clock.Now().Add(d)
+	if parent, ok := ctx.Deadline(); ok && parent.Before(deadline) {
+		// The parent expires first, so ctx keeps its own deadline.
+		return context.WithCancel(ctx)
+	}
+
+	inner, cancel := context.WithCancel(ctx)
+	c := &clockContext{Context: inner, deadline: deadline, done: make(chan struct{})}
+	timer := clock.After(d)
+	go func() {
+		select {
+		case <-timer:
+			c.mu.Lock()
+			c.expired = true
+			c.mu.Unlock()
+			cancel()
+		case <-inner.Done():
+		}
+		close(c.done)
+	}()
+	return c, cancel
+}
+
+// clockContext is a context cancelled when the deadline measured by a Clock
+// is reached, with the context.DeadlineExceeded error context.WithTimeout
+// would give. It has its own done channel so that the contexts derived from
+// it take their error from Err rather than from the context it wraps.
+type clockContext struct {
+	context.Context
+	deadline time.Time
+	done     chan struct{}
+
+	mu      sync.Mutex
+	expired bool
+}
+
+func (c *clockContext) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+func (c *clockContext) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *clockContext) Err() error {
+	select {
+	case <-c.done:
+	default:
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.expired {
+		return context.DeadlineExceeded
+	}
+	return c.Context.Err()
+}
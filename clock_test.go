@@ -0,0 +1,263 @@
+// Tests for the clock timeouts are measured with.
+package numbers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWithTimeoutFakeClock(t *testing.T) {
+	fc := newFakeClock()
+	ctx, cancel := withTimeout(context.Background(), fc, time.Minute)
+	defer cancel()
+	child, cancelChild := context.WithCancel(ctx)
+	defer cancelChild()
+
+	if deadline, ok := ctx.Deadline(); !ok || !deadline.Equal(fc.Now().Add(time.Minute)) {
+		t.Fatalf("deadline mismatch: %s", comp(fc.Now().Add(time.Minute), deadline))
+	}
+
+	fc.Advance(59 * time.Second)
+	if err := ctx.Err(); err != nil {
+		t.Fatalf("context done before its deadline: %s", comp(nil, err))
+	}
+
+	fc.Advance(time.Second)
+	select {
+	case <-child.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context not done once the clock reached its deadline")
+	}
+	for _, c := range []context.Context{ctx, child} {
+		if err := c.Err(); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("error mismatch: %s", comp(context.DeadlineExceeded, err))
+		}
+	}
+}
+
+func TestFetchResponseRetryFakeClock(t *testing.T) {
+	fc := newFakeClock()
+	fg := &flakyGetter{failures: 1}
+	cfg := &Config{MaxRetries: 1, RetryBackoff: time.Hour, Clock: fc, URLGetter: fg}
+
+	resCh := make(chan urlResult, 1)
+	go func() {
+		resCh <- fetchResponse(context.Background(), cfg, "http://flaky")
+	}()
+
+	fc.waitForTimers(1)
+	fc.Advance(time.Hour)
+	select {
+	case res := <-resCh:
+		if res.err != nil {
+			t.Fatalf("unexpected error after the retry: %v", res.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("retry not attempted once the backoff elapsed")
+	}
+	if got := fg.attempts.Load(); got != 2 {
+		t.Fatalf("attempts mismatch: %s", comp(2, got))
+	}
+}
+
+func TestServeHTTPFakeClockTimeout(t *testing.T) {
+	exp := []int{1, 2, 3}
+
+	fc := newFakeClock()
+	served := make(chan string, 2)
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout:      time.Hour,
+		InlineTimeoutWarning: true,
+		Clock:                fc,
+		URLGetter: &delayGetter{
+			bodies: map[string]string{"http://fast": `{"numbers":[1,2,3]}`, "http://slow": `{"numbers":[4,5,6]}`},
+			delays: map[string]time.Duration{"http://slow": time.Hour},
+			served: served,
+		},
+	}}
+
+	// The response timeout and the watchdog wait on the clock, which is only
+	// advanced once http://fast responded, so that its numbers are merged
+	// rather than skipped.
+	go func() {
+		for url := range served {
+			if url == "http://fast" {
+				break
+			}
+		}
+		fc.waitForTimers(2)
+		fc.Advance(time.Hour)
+	}()
+
+	start := time.Now()
+	w := serve(ng, "/numbers?u=http://fast&u=http://slow")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("response timeout not measured with the clock: %s", comp("< 1s", elapsed))
+	}
+
+	var got struct {
+		Numbers []int  `json:"numbers"`
+		Warning string `json:"warning"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("unexpected error decoding the response: %v", err)
+	}
+	if !reflect.DeepEqual(exp, got.Numbers) {
+		t.Fatalf("numbers mismatch: %s", comp(exp, got.Numbers))
+	}
+	if got.Warning != timeoutWarning {
+		t.Fatalf("warning mismatch: %s", comp(timeoutWarning, got.Warning))
+	}
+	if w.Code != http.StatusOK {
+		t.Fatalf("status mismatch: %s", comp(http.StatusOK, w.Code))
+	}
+}
+
+func TestGettersFakeClock(t *testing.T) {
+	// /flaky fails once with a 503 before responding, and /stall sends half
+	// of its body before stalling.
+	var flaky sync.Once
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/flaky":
+			failed := false
+			flaky.Do(func() { failed = true })
+			if failed {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			io.WriteString(w, `{"numbers":[1]}`)
+		case "/stall":
+			io.WriteString(w, `{"numbers":[1,`)
+			w.(http.Flusher).Flush()
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	// get GETs url with g while the clock is advanced past the single timer
+	// it waits on, and returns the error of the GET.
+	get := func(g URLGetter, fc *fakeClock, url string) error {
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := g.Get(context.Background(), url)
+			errCh <- err
+		}()
+		fc.waitForTimers(1)
+		fc.Advance(time.Hour)
+		select {
+		case err := <-errCh:
+			return err
+		case <-time.After(time.Second):
+			t.Fatalf("GET of %s not done once the clock advanced", url)
+			return nil
+		}
+	}
+
+	fc := newFakeClock()
+	g := NewDefaultGet(5*time.Second, WithClock(fc), WithRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Hour}))
+	if err := get(g, fc, ts.URL+"/flaky"); err != nil {
+		t.Fatalf("unexpected error after the retry: %v", err)
+	}
+
+	fc = newFakeClock()
+	g = NewDefaultGet(5*time.Second, WithClock(fc), WithBodyIdleTimeout(time.Hour))
+	var netErr net.Error
+	if err := get(g, fc, ts.URL+"/stall"); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("idle timeout not reported: %s", comp("timeout error", err))
+	}
+
+	fc = newFakeClock()
+	limited := NewRateLimitedGetter(staticGetter{"http://a/1": `{"numbers":[1]}`}, 1, nil)
+	limited.clock = fc
+	if _, err := limited.Get(context.Background(), "http://a/1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := get(limited, fc, "http://a/1"); err != nil {
+		t.Fatalf("unexpected error once paced: %v", err)
+	}
+}
+
+// fakeClock is a Clock whose time only moves forward when advanced.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+// fakeTimer is a channel returned by After, sent to once the clock reaches at.
+type fakeTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.timers = append(c.timers, fakeTimer{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward by d, firing the timers it reaches.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.ch <- c.now
+	}
+	c.timers = pending
+}
+
+// waitForTimers waits until at least n timers are pending, for up to a
+// second.
+func (c *fakeClock) waitForTimers(n int) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		pending := len(c.timers)
+		c.mu.Unlock()
+		if pending >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
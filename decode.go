@@ -0,0 +1,280 @@
+// This file contains the logic to decode URL responses into lists of numbers.
+// Decoding is kept apart from fetching so that the tolerance of the decoder can
+// be tuned independently of how the URLs are queried.
+package numbers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"mime"
+	"strconv"
+)
+
+// errTrailingData is returned by decodeNumbers, along with the decoded numbers,
+// when the JSON object in a response is followed by anything but whitespace.
+var errTrailingData = errors.New("trailing data after JSON response")
+
+// errMissingNumbers is returned by decodeNumbers for responses without a
+// numbers field when it is required.
+var errMissingNumbers = errors.New("numbers field missing from response")
+
+// errDecodeTimeout is returned for responses taking longer than
+// Config.MaxDecodeTime to decode.
+var errDecodeTimeout = errors.New("response took too long to decode")
+
+// errNoLines is returned by decodeLines for responses with lines that are
+// all malformed.
+var errNoLines = errors.New("no integer lines in response")
+
+// FloatMode controls how non-integral values in a response are treated.
+type FloatMode int
+
+const (
+	// FloatReject fails the whole response if any value is not an integer.
+	// This is the default mode.
+	FloatReject FloatMode = iota
+
+	// FloatTruncate truncates floats towards zero, so 2.7 becomes 2.
+	FloatTruncate
+
+	// FloatRound rounds floats to the nearest integer, halves away from zero.
+	FloatRound
+
+	// FloatSkip drops non-integral values and keeps the rest of the response.
+	// Floats with an integral value (such as 3.0) are kept.
+	FloatSkip
+)
+
+// NumberKind is the type of the numbers collected from the responses.
+type NumberKind int
+
+const (
+	// IntNumbers collects integers, with non-integral values handled
+	// following FloatMode. This is the default kind.
+	IntNumbers NumberKind = iota
+
+	// FloatNumbers collects float64 numbers, as decoded by encoding/json:
+	// values beyond the precision of a float64 are rounded to the nearest
+	// one. Numbers are de-duplicated by exact equality once decoded, so 1,
+	// 1.0 and 1e0 are the same number, but 0.1+0.2 and 0.3 are not. Registered
+	// decoders still apply, their integers being converted to floats, but
+	// LineDecoding does not.
+	FloatNumbers
+)
+
+// floatResult is used in place of result with FloatNumbers.
+type floatResult struct {
+	Numbers []float64 `json:"numbers"`
+}
+
+// rawResult is used in place of result when the numbers must be decoded one
+// element at a time.
+type rawResult struct {
+	Numbers []json.RawMessage `json:"numbers"`
+}
+
+// ElementError reports the element of a response's numbers array that could
+// not be decoded.
+type ElementError struct {
+	// Index is the position of the element in the array.
+	Index int
+	Err   error
+}
+
+func (e *ElementError) Error() string {
+	return fmt.Sprintf("numbers[%d]: %v", e.Index, e.Err)
+}
+
+// decodeNumbers decodes the response data into a slice of numbers following
+// the float mode set in cfg. Only the first JSON value in data is decoded. If
+// it is followed by more content, the numbers are returned along with
+// errTrailingData so that the caller can decide whether to tolerate it.
+func decodeNumbers(data []byte, cfg *Config) ([]int, error) {
+	if cfg.MaxJSONDepth > 0 {
+		if err := checkDepth(data, cfg.MaxJSONDepth); err != nil {
+			return nil, err
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	var numbers []int
+	if cfg.FloatMode == FloatReject && !cfg.StrictDecode {
+		res := result{}
+		if err := dec.Decode(&res); err != nil {
+			return nil, err
+		}
+		numbers = res.Numbers
+	} else {
+		res := rawResult{}
+		if err := dec.Decode(&res); err != nil {
+			return nil, err
+		}
+		var err error
+		if numbers, err = decodeElements(res.Numbers, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	// Both decoding paths leave numbers nil only if the field is absent or
+	// null, and an empty slice for an empty array.
+	if numbers == nil && cfg.RequireNumbersField {
+		return nil, errMissingNumbers
+	}
+	if len(bytes.TrimSpace(data[dec.InputOffset():])) > 0 {
+		return numbers, errTrailingData
+	}
+	return numbers, nil
+}
+
+// checkDepth walks the tokens of the first JSON value in data and returns
+// ErrTooDeep as soon as its nesting gets deeper than max. Syntax errors are
+// left for the decoder to report.
+func checkDepth(data []byte, max int) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		switch tok {
+		case json.Delim('['), json.Delim('{'):
+			if depth++; depth > max {
+				return ErrTooDeep
+			}
+		case json.Delim(']'), json.Delim('}'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// decodeElements decodes every element of a numbers array. Decoding stops at
+// the first element that is not an integer under the float mode, which fails
+// the response, with an *ElementError if cfg.StrictDecode is set.
+func decodeElements(values []json.RawMessage, cfg *Config) ([]int, error) {
+	if values == nil {
+		return nil, nil
+	}
+
+	numbers := make([]int, 0, len(values))
+	for i, v := range values {
+		n, ok, err := decodeElement(v, cfg.FloatMode)
+		if err != nil {
+			if cfg.StrictDecode {
+				return nil, &ElementError{Index: i, Err: err}
+			}
+			return nil, err
+		}
+		if ok {
+			numbers = append(numbers, n)
+		}
+	}
+	return numbers, nil
+}
+
+// decodeElement converts a single element to an int following the float mode.
+// It returns false if the element must be skipped.
+func decodeElement(raw json.RawMessage, mode FloatMode) (int, bool, error) {
+	var v json.Number
+	if len(raw) == 0 || raw[0] == '"' || json.Unmarshal(raw, &v) != nil {
+		return 0, false, fmt.Errorf("%s is not a number", raw)
+	}
+	if n, err := v.Int64(); err == nil {
+		return int(n), true, nil
+	}
+	f, err := v.Float64()
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch mode {
+	case FloatReject:
+		return 0, false, fmt.Errorf("%s is not an integer", raw)
+	case FloatTruncate:
+		f = math.Trunc(f)
+	case FloatRound:
+		f = math.Round(f)
+	case FloatSkip:
+		if f != math.Trunc(f) {
+			return 0, false, nil
+		}
+	}
+	if f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, false, fmt.Errorf("number %v out of range", v)
+	}
+	return int(f), true, nil
+}
+
+// decodeLines decodes data as one integer per line. Blank lines are ignored
+// and malformed ones skipped with a warning, unless no line is an integer, in
+// which case errNoLines is returned.
+func decodeLines(data []byte) ([]int, error) {
+	numbers := []int{}
+	var malformed int
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		n, err := strconv.Atoi(string(line))
+		if err != nil {
+			log.Printf("warning: skipping malformed line %d: %q", i+1, line)
+			malformed++
+			continue
+		}
+		numbers = append(numbers, n)
+	}
+	if malformed > 0 && len(numbers) == 0 {
+		return nil, errNoLines
+	}
+	return numbers, nil
+}
+
+// isPlainText reports whether contentType is the text/plain media type.
+func isPlainText(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "text/plain"
+}
+
+// decodeFloats works like decodeNumbers for FloatNumbers: the numbers are
+// decoded as float64, and FloatMode and StrictDecode do not apply.
+func decodeFloats(data []byte, cfg *Config) ([]float64, error) {
+	if cfg.MaxJSONDepth > 0 {
+		if err := checkDepth(data, cfg.MaxJSONDepth); err != nil {
+			return nil, err
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	res := floatResult{}
+	if err := dec.Decode(&res); err != nil {
+		return nil, err
+	}
+	if res.Numbers == nil && cfg.RequireNumbersField {
+		return nil, errMissingNumbers
+	}
+	if len(bytes.TrimSpace(data[dec.InputOffset():])) > 0 {
+		return res.Numbers, errTrailingData
+	}
+	return res.Numbers, nil
+}
+
+// intsToFloats returns numbers converted to float64. A nil slice stays nil.
+func intsToFloats(numbers []int) []float64 {
+	if numbers == nil {
+		return nil
+	}
+	floats := make([]float64, len(numbers))
+	for i, n := range numbers {
+		floats[i] = float64(n)
+	}
+	return floats
+}
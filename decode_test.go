@@ -0,0 +1,200 @@
+// Tests for decoding URL responses.
+package numbers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const floatResponse = `{"numbers":[1.0, 2.5, 3, -2.5, 4.2]}`
+
+func TestDecodeNumbersFloatReject(t *testing.T) {
+	if _, err := decodeNumbers([]byte(floatResponse), &Config{}); err == nil {
+		t.Fatalf("floats decoded in reject mode: %s", comp("error", nil))
+	}
+}
+
+func TestDecodeNumbersFloatTruncate(t *testing.T) {
+	exp := []int{1, 2, 3, -2, 4}
+
+	got, err := decodeNumbers([]byte(floatResponse), &Config{FloatMode: FloatTruncate})
+	if err != nil {
+		t.Fatalf("unexpected decode error: %v", err)
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("truncated numbers mismatch: %s", comp(exp, got))
+	}
+}
+
+func TestDecodeNumbersFloatRound(t *testing.T) {
+	exp := []int{1, 3, 3, -3, 4}
+
+	got, err := decodeNumbers([]byte(floatResponse), &Config{FloatMode: FloatRound})
+	if err != nil {
+		t.Fatalf("unexpected decode error: %v", err)
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("rounded numbers mismatch: %s", comp(exp, got))
+	}
+}
+
+func TestDecodeNumbersFloatSkip(t *testing.T) {
+	exp := []int{1, 3}
+
+	got, err := decodeNumbers([]byte(floatResponse), &Config{FloatMode: FloatSkip})
+	if err != nil {
+		t.Fatalf("unexpected decode error: %v", err)
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("skipped numbers mismatch: %s", comp(exp, got))
+	}
+}
+
+func TestDecodeNumbersStrict(t *testing.T) {
+	expIndex := 2
+
+	_, err := decodeNumbers([]byte(`{"numbers":[1,2,"x",4]}`), &Config{StrictDecode: true})
+	var elemErr *ElementError
+	if !errors.As(err, &elemErr) {
+		t.Fatalf("no element error in strict mode: %s", comp("*ElementError", err))
+	}
+	if elemErr.Index != expIndex {
+		t.Fatalf("element error index mismatch: %s", comp(expIndex, elemErr.Index))
+	}
+}
+
+func TestDecodeNumbersTolerantRejectsElements(t *testing.T) {
+	// Only floats are tolerated, and elements that are not numbers still
+	// fail the response.
+	for _, mode := range []FloatMode{FloatTruncate, FloatRound, FloatSkip} {
+		if got, err := decodeNumbers([]byte(`{"numbers":[1,2,"x",4.5]}`), &Config{FloatMode: mode}); err == nil {
+			t.Fatalf("non-numeric element decoded in float mode %d: %s", mode, comp("error", got))
+		}
+	}
+}
+
+func TestDecodeNumbersMaxJSONDepth(t *testing.T) {
+	cfg := &Config{MaxJSONDepth: 32}
+
+	if _, err := decodeNumbers([]byte(`{"numbers":[1,2,3],"meta":{"source":["a"]}}`), cfg); err != nil {
+		t.Fatalf("unexpected decode error: %v", err)
+	}
+
+	nested := `{"numbers":[1],"meta":` + strings.Repeat("[", 10000) + strings.Repeat("]", 10000) + `}`
+	if _, err := decodeNumbers([]byte(nested), cfg); err != ErrTooDeep {
+		t.Fatalf("deeply nested response not rejected: %s", comp(ErrTooDeep, err))
+	}
+}
+
+func TestDecodeNumbersRequireNumbersField(t *testing.T) {
+	for _, cfg := range []*Config{
+		{RequireNumbersField: true},
+		{RequireNumbersField: true, FloatMode: FloatTruncate},
+	} {
+		if _, err := decodeNumbers([]byte(`{"count":0}`), cfg); err != errMissingNumbers {
+			t.Fatalf("missing field not rejected: %s", comp(errMissingNumbers, err))
+		}
+		if _, err := decodeNumbers([]byte(`{"numbers":null}`), cfg); err != errMissingNumbers {
+			t.Fatalf("null field not rejected: %s", comp(errMissingNumbers, err))
+		}
+		got, err := decodeNumbers([]byte(`{"numbers":[]}`), cfg)
+		if err != nil || got == nil || len(got) != 0 {
+			t.Fatalf("explicit empty array not accepted: %s", comp("[] <nil>", fmt.Sprint(got, " ", err)))
+		}
+		got, err = decodeNumbers([]byte(`{"numbers":[1,2]}`), cfg)
+		if err != nil || !reflect.DeepEqual([]int{1, 2}, got) {
+			t.Fatalf("populated array mismatch: %s", comp([]int{1, 2}, got))
+		}
+	}
+
+	if got, err := decodeNumbers([]byte(`{"count":0}`), &Config{}); err != nil || len(got) != 0 {
+		t.Fatalf("missing field not taken as empty by default: %s", comp("[] <nil>", fmt.Sprint(got, " ", err)))
+	}
+}
+
+func TestFetchResponseTrailingData(t *testing.T) {
+	exp := []int{1, 2, 3}
+
+	cfg := &Config{URLGetter: staticGetter{
+		"http://trailing": `{"numbers":[1,2,3]} request served in 3ms`,
+	}}
+
+	got := fetchResponse(context.Background(), cfg, "http://trailing").numbers
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("numbers mismatch with trailing data: %s", comp(exp, got))
+	}
+
+	cfg.RejectTrailingData = true
+	if got := fetchResponse(context.Background(), cfg, "http://trailing").numbers; got != nil {
+		t.Fatalf("trailing data not rejected in strict mode: %s", comp(nil, got))
+	}
+}
+
+func TestDecodeLines(t *testing.T) {
+	exp := []int{1, -2, 3}
+
+	got, err := decodeLines([]byte("1\n\n -2 \nthree\n3\r\n"))
+	if err != nil || !reflect.DeepEqual(exp, got) {
+		t.Fatalf("numbers mismatch: %s", comp(exp, fmt.Sprint(got, " ", err)))
+	}
+	if _, err := decodeLines([]byte("one\ntwo")); err != errNoLines {
+		t.Fatalf("malformed lines not rejected: %s", comp(errNoLines, err))
+	}
+}
+
+func TestFetchResponseLineDecoding(t *testing.T) {
+	exp := []int{1, 2, 3}
+
+	textHeader := http.Header{"Content-Type": {"text/plain; charset=utf-8"}}
+	cfg := &Config{URLGetter: typedGetter{
+		"http://text":     {Body: []byte("1\n2\n3\n"), Header: textHeader},
+		"http://untyped":  {Body: []byte("1\n2\n3\n"), Header: http.Header{}},
+		"http://json":     {Body: []byte(`{"numbers":[1,2,3]}`), Header: http.Header{}},
+		"http://textjson": {Body: []byte(`{"numbers":[1,2,3]}`), Header: textHeader},
+	}}
+
+	for _, url := range []string{"http://text", "http://untyped"} {
+		if got := fetchResponse(context.Background(), cfg, url).numbers; got != nil {
+			t.Fatalf("lines decoded for %s by default: %s", url, comp(nil, got))
+		}
+	}
+
+	cfg.LineDecoding = true
+	for _, url := range []string{"http://text", "http://untyped", "http://json"} {
+		if got := fetchResponse(context.Background(), cfg, url).numbers; !reflect.DeepEqual(exp, got) {
+			t.Fatalf("numbers mismatch for %s: %s", url, comp(exp, got))
+		}
+	}
+	if res := fetchResponse(context.Background(), cfg, "http://textjson"); !errors.Is(res.err, ErrDecode) {
+		t.Fatalf("JSON in text/plain response not rejected: %s", comp(ErrDecode, res.err))
+	}
+}
+
+func TestFetchResponseShouldInclude(t *testing.T) {
+	exp := map[string][]int{"http://kept": {1, 2}, "http://skipped": {}}
+
+	cfg := &Config{
+		URLGetter: typedGetter{
+			"http://kept":    {Body: []byte(`{"numbers":[1,2]}`), Header: http.Header{}},
+			"http://skipped": {Body: []byte(`{"numbers":[3,4]}`), Header: http.Header{"X-Numbers-Skip": {"true"}}},
+		},
+		ShouldInclude: func(url string, header http.Header) bool {
+			return header.Get("X-Numbers-Skip") != "true"
+		},
+	}
+
+	for url, exp := range exp {
+		res := fetchResponse(context.Background(), cfg, url)
+		if res.err != nil {
+			t.Fatalf("unexpected error for %s: %v", url, res.err)
+		}
+		if !reflect.DeepEqual(exp, res.numbers) {
+			t.Fatalf("numbers mismatch for %s: %s", url, comp(exp, res.numbers))
+		}
+	}
+}
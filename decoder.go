@@ -0,0 +1,63 @@
+// This file contains the registry of the decoders URL responses can be read
+// with, in formats other than the default JSON. Decoders are picked through
+// the Content-Type header of the response.
+package numbers
+
+import (
+	"fmt"
+	"mime"
+	"sync"
+)
+
+// Decoder reads the numbers of a URL response in a given format.
+type Decoder interface {
+	// Decode returns the numbers in the response body data.
+	Decode(data []byte) ([]int, error)
+}
+
+var (
+	decodersMu sync.RWMutex
+	decoders   = make(map[string]Decoder)
+)
+
+// RegisterDecoder makes d the decoder of the URL responses sent with
+// contentType. It is meant to be called from the init function of the package
+// implementing the decoder, and replaces any decoder already registered for
+// contentType.
+//
+// Registered decoders bypass the JSON decoding options of Config, such as
+// FloatMode or StrictDecode.
+func RegisterDecoder(contentType string, d Decoder) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	decoders[contentType] = d
+}
+
+// decoderFor returns the decoder registered for the media type of
+// contentType. A nil decoder means the response is to be decoded as JSON.
+func decoderFor(contentType string) Decoder {
+	if contentType == "" {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	return decoders[mediaType]
+}
+
+// decodeWith decodes data with d. If recovery is on, a panic of d is recovered
+// and returned as an error instead.
+func decodeWith(d Decoder, data []byte, recovery bool) (numbers []int, err error) {
+	if recovery {
+		defer func() {
+			if p := recover(); p != nil {
+				numbers, err = nil, fmt.Errorf("decoder panicked: %v", p)
+			}
+		}()
+	}
+	return d.Decode(data)
+}
@@ -0,0 +1,189 @@
+// Tests for the registry of response decoders.
+package numbers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetchResponseRegisteredDecoder(t *testing.T) {
+	exp := []int{3, 1, 2}
+
+	RegisterDecoder("text/x-test-numbers", lineDecoder{})
+	cfg := &Config{URLGetter: typedGetter{
+		"http://typed": {Body: []byte("3\n1\n2"), StatusCode: 200, Header: http.Header{
+			"Content-Type": {"text/x-test-numbers; charset=utf-8"},
+		}},
+		"http://json": {Body: []byte(`{"numbers":[3,1,2]}`), StatusCode: 200},
+	}}
+
+	for _, url := range []string{"http://typed", "http://json"} {
+		res := fetchResponse(context.Background(), cfg, url)
+		if res.err != nil {
+			t.Fatalf("unexpected error for %s: %v", url, res.err)
+		}
+		if !reflect.DeepEqual(exp, res.numbers) {
+			t.Fatalf("numbers mismatch for %s: %s", url, comp(exp, res.numbers))
+		}
+	}
+}
+
+func TestFetchResponseDecoderPanic(t *testing.T) {
+	RegisterDecoder("text/x-test-panic", panicDecoder{})
+	header := http.Header{"Content-Type": {"text/x-test-panic"}}
+	cfg := &Config{URLGetter: typedGetter{
+		"http://ok":        {Body: []byte("1"), StatusCode: 200, Header: header},
+		"http://malformed": {Body: []byte(""), StatusCode: 200, Header: header},
+	}}
+
+	if res := fetchResponse(context.Background(), cfg, "http://malformed"); !errors.Is(res.err, ErrDecode) {
+		t.Fatalf("decoder panic not turned into an error: %s", comp(ErrDecode, res.err))
+	}
+	if res := fetchResponse(context.Background(), cfg, "http://ok"); res.err != nil || len(res.numbers) != 1 {
+		t.Fatalf("well-formed response not decoded: %s", comp([]int{1}, res.numbers))
+	}
+}
+
+func TestFetchResponseMaxDecodeTime(t *testing.T) {
+	slow := slowDecoder{release: make(chan struct{})}
+	defer close(slow.release)
+	RegisterDecoder("text/x-test-slow", slow)
+
+	fc := newFakeClock()
+	cfg := &Config{MaxDecodeTime: time.Second, Clock: fc, URLGetter: typedGetter{
+		"http://slow": {Body: []byte("1"), StatusCode: 200, Header: http.Header{"Content-Type": {"text/x-test-slow"}}},
+		"http://json": {Body: []byte(`{"numbers":[1]}`), StatusCode: 200},
+	}}
+
+	resCh := make(chan urlResult, 1)
+	go func() {
+		resCh <- fetchResponse(context.Background(), cfg, "http://slow")
+	}()
+	fc.waitForTimers(1)
+	fc.Advance(time.Second)
+	select {
+	case res := <-resCh:
+		if !errors.Is(res.err, ErrDecode) || !errors.Is(res.err, errDecodeTimeout) {
+			t.Fatalf("error mismatch: %s", comp(errDecodeTimeout, res.err))
+		}
+		if res.numbers != nil {
+			t.Fatalf("numbers of an abandoned decode returned: %s", comp(nil, res.numbers))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("slow decode not abandoned at MaxDecodeTime")
+	}
+
+	if res := fetchResponse(context.Background(), cfg, "http://json"); res.err != nil || len(res.numbers) != 1 {
+		t.Fatalf("response decoded in time rejected: %s", comp([]int{1}, res.numbers))
+	}
+}
+
+func TestMaxConcurrentDecodes(t *testing.T) {
+	exp := 2
+
+	dec := &peakDecoder{delay: 10 * time.Millisecond}
+	RegisterDecoder("text/x-test-peak", dec)
+	g := typedGetter{}
+	var urls []string
+	for i := 0; i < 20; i++ {
+		url := "http://n/" + strconv.Itoa(i)
+		g[url] = &Response{Body: []byte("1"), StatusCode: 200, Header: http.Header{"Content-Type": {"text/x-test-peak"}}}
+		urls = append(urls, url)
+	}
+	cfg := &Config{NumGoRoutines: 10, MaxConcurrentDecodes: exp, URLGetter: g}
+	cfg.setDefaults()
+
+	for r := range processResults(context.Background(), cfg, urls) {
+		if r.err != nil {
+			t.Fatalf("unexpected error for %s: %v", r.url, r.err)
+		}
+	}
+	if got := int(dec.peak.Load()); got != exp {
+		t.Fatalf("peak concurrent decodes mismatch: %s", comp(exp, got))
+	}
+}
+
+// lineDecoder decodes responses holding a number per line.
+type lineDecoder struct{}
+
+func (lineDecoder) Decode(data []byte) ([]int, error) {
+	var ns []int
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		n, err := strconv.Atoi(string(line))
+		if err != nil {
+			return nil, err
+		}
+		ns = append(ns, n)
+	}
+	return ns, nil
+}
+
+// panicDecoder decodes responses of a single byte into the number 1, and
+// panics on empty ones.
+type panicDecoder struct{}
+
+func (panicDecoder) Decode(data []byte) ([]int, error) {
+	_ = data[0]
+	return []int{1}, nil
+}
+
+// slowDecoder decodes responses into the number 1 once release is closed.
+type slowDecoder struct {
+	release chan struct{}
+}
+
+func (d slowDecoder) Decode(data []byte) ([]int, error) {
+	<-d.release
+	return []int{1}, nil
+}
+
+// peakDecoder decodes responses into the number 1 after the delay, and
+// records the largest number of decodes it ran at once.
+type peakDecoder struct {
+	delay   time.Duration
+	running atomic.Int64
+	peak    atomic.Int64
+}
+
+func (d *peakDecoder) Decode(data []byte) ([]int, error) {
+	n := d.running.Add(1)
+	defer d.running.Add(-1)
+	for {
+		peak := d.peak.Load()
+		if n <= peak || d.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(d.delay)
+	return []int{1}, nil
+}
+
+// typedGetter serves canned responses, headers included, keyed by URL.
+type typedGetter map[string]*Response
+
+func (g typedGetter) Get(ctx context.Context, url string) ([]byte, error) {
+	resp, err := g.GetResponse(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (g typedGetter) GetResponse(ctx context.Context, url string) (*Response, error) {
+	resp, ok := g[url]
+	if !ok {
+		return nil, &StatusError{StatusCode: http.StatusNotFound}
+	}
+	return resp, nil
+}
+
+func (g typedGetter) Client() *http.Client {
+	return nil
+}
@@ -0,0 +1,71 @@
+// This file contains a helper for clients collecting numbers over several runs
+// of ProcessURLs, rather than from a single request to NumbersGetter.
+package numbers
+
+import (
+	"math/bits"
+	"sort"
+	"sync"
+)
+
+// Deduper accumulates numbers across calls to Add and returns them sorted and
+// de-duplicated. The zero value is ready to use. A Deduper is safe for
+// concurrent use, so that several runs of ProcessURLs can be drained into it.
+type Deduper struct {
+	mu sync.Mutex
+
+	// set marks the numbers in [0, len(set)*64), when bounded.
+	set []uint64
+
+	// seen holds the numbers outside of set.
+	seen map[int]bool
+}
+
+// NewBoundedDeduper returns a Deduper for numbers expected in [0, maxValue].
+// Those are marked in a bitset of maxValue/8 bytes instead of a map, which is
+// smaller and faster when they are dense. Numbers out of bounds are still
+// accepted.
+func NewBoundedDeduper(maxValue int) *Deduper {
+	return &Deduper{set: make([]uint64, maxValue/64+1)}
+}
+
+// Add adds the numbers to d.
+func (d *Deduper) Add(numbers []int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, n := range numbers {
+		if n >= 0 && n/64 < len(d.set) {
+			d.set[n/64] |= 1 << (n % 64)
+			continue
+		}
+		if d.seen == nil {
+			d.seen = make(map[int]bool)
+		}
+		d.seen[n] = true
+	}
+}
+
+// Result returns the sorted, de-duplicated numbers added to d so far. d can
+// still be added to afterwards.
+func (d *Deduper) Result() []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	result := make([]int, 0, len(d.seen))
+	for n := range d.seen {
+		result = append(result, n)
+	}
+	sort.Ints(result)
+
+	// The numbers of the bitset are all larger than the negative numbers in
+	// seen, and smaller than the positive ones, and come out of it sorted.
+	split := sort.SearchInts(result, 0)
+	bounded := []int{}
+	for word, w := range d.set {
+		for ; w != 0; w &= w - 1 {
+			bounded = append(bounded, word*64+bits.TrailingZeros64(w))
+		}
+	}
+	return append(result[:split:split], append(bounded, result[split:]...)...)
+}
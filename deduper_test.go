@@ -0,0 +1,58 @@
+// Tests for the Deduper.
+package numbers
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeduper(t *testing.T) {
+	exp := []int{-5, -1, 0, 1, 2, 3, 63, 64, 100, 1000}
+
+	for _, d := range []*Deduper{{}, NewBoundedDeduper(100)} {
+		d.Add([]int{3, 1, 64, -1})
+		d.Add([]int{1, 2, 3, 1000})
+		if got := d.Result(); !reflect.DeepEqual([]int{-1, 1, 2, 3, 64, 1000}, got) {
+			t.Fatalf("intermediate result mismatch: %s", comp([]int{-1, 1, 2, 3, 64, 1000}, got))
+		}
+
+		d.Add([]int{63, 0, -5, 100, 64, 1000})
+		if got := d.Result(); !reflect.DeepEqual(exp, got) {
+			t.Fatalf("result mismatch: %s", comp(exp, got))
+		}
+	}
+}
+
+func TestDeduperProcessURLs(t *testing.T) {
+	exp := []int{1, 2, 3, 4, 5, 6}
+
+	// The defaults are set upfront, as the runs share cfg.
+	cfg := &Config{NumGoRoutines: 2, URLGetter: staticGetter{
+		"http://a": `{"numbers":[3,1,2]}`,
+		"http://b": `{"numbers":[2,4]}`,
+		"http://c": `{"numbers":[6,5,4]}`,
+	}}
+	d := NewBoundedDeduper(10)
+
+	// Batches are drained concurrently into the same Deduper.
+	var wg sync.WaitGroup
+	for _, batch := range [][]string{{"http://a", "http://b"}, {"http://b", "http://c"}} {
+		wg.Add(1)
+		go func(urls []string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+			for ns := range ProcessURLs(ctx, cfg, urls) {
+				d.Add(ns)
+			}
+		}(batch)
+	}
+	wg.Wait()
+
+	if got := d.Result(); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("result mismatch across runs: %s", comp(exp, got))
+	}
+}
@@ -0,0 +1,115 @@
+// This file contains a small DNS cache that can be plugged into the transport
+// of the default getter. When many input URLs share a host, it saves a lookup
+// for every connection made to that host.
+package numbers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Resolver looks up the IP addresses of a host. It is satisfied by *net.Resolver.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// dnsEntry is a cached lookup result.
+type dnsEntry struct {
+	addrs   []net.IPAddr
+	expires time.Time
+}
+
+// dnsCache caches the IPs resolved for a host for a fixed TTL. It is safe for
+// concurrent use. Expired entries are removed once they are looked up, and
+// all at once by the first lookup stored every TTL, so that hosts resolved
+// only once do not stay cached.
+type dnsCache struct {
+	resolver Resolver
+	ttl      time.Duration
+	clock    Clock
+
+	mu        sync.Mutex
+	entries   map[string]dnsEntry
+	nextSweep time.Time
+}
+
+func newDNSCache(ttl time.Duration, r Resolver) *dnsCache {
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	return &dnsCache{
+		resolver: r,
+		ttl:      ttl,
+		clock:    realClock{},
+		entries:  make(map[string]dnsEntry),
+	}
+}
+
+// lookup returns the IPs of host, from the cache if they have not expired.
+// Concurrent misses for the same host may each perform a lookup; the last one
+// to finish is cached.
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	expired := ok && !c.clock.Now().Before(entry.expires)
+	if expired {
+		delete(c.entries, host)
+	}
+	c.mu.Unlock()
+	if ok && !expired {
+		return entry.addrs, nil
+	}
+
+	addrs, err := c.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("no addresses found for " + host)
+	}
+
+	now := c.clock.Now()
+	c.mu.Lock()
+	c.entries[host] = dnsEntry{addrs: addrs, expires: now.Add(c.ttl)}
+	if !now.Before(c.nextSweep) {
+		for h, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, h)
+			}
+		}
+		c.nextSweep = now.Add(c.ttl)
+	}
+	c.mu.Unlock()
+	return addrs, nil
+}
+
+// dialContext wraps dial so that host names are resolved through the cache.
+// Each resolved IP is tried in turn until a connection succeeds.
+func (c *dnsCache) dialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if net.ParseIP(host) != nil {
+			return dial(ctx, network, addr)
+		}
+
+		addrs, err := c.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		var conn net.Conn
+		for _, ip := range addrs {
+			conn, err = dial(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
@@ -0,0 +1,61 @@
+// This file contains the registry of the encoders NumbersGetter can write its
+// responses with, in formats other than the default JSON. Encoders are picked
+// through the Accept header of the request.
+package numbers
+
+import (
+	"io"
+	"mime"
+	"strings"
+	"sync"
+)
+
+// Encoder writes the numbers of a response in a given format.
+type Encoder interface {
+	// Encode writes the sorted numbers to w.
+	Encode(w io.Writer, numbers []int) error
+}
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = make(map[string]Encoder)
+)
+
+// RegisterEncoder makes e the encoder of the responses to requests accepting
+// contentType. It is meant to be called from the init function of the package
+// implementing the encoder, and replaces any encoder already registered for
+// contentType.
+//
+// Registered encoders only apply to plain lists of numbers. Responses carrying
+// more than the numbers, such as with ?debug=1, are always written as JSON.
+func RegisterEncoder(contentType string, e Encoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	encoders[contentType] = e
+}
+
+// encoderFor returns the encoder of the first media type listed in accept
+// that has one registered, along with that media type. A nil encoder means
+// the response is to be written as JSON, or as NDJSON if ndjsonContentType
+// is returned.
+func encoderFor(accept string) (string, Encoder) {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if mediaType == "application/json" {
+			return "", nil
+		}
+		if mediaType == ndjsonContentType {
+			return mediaType, nil
+		}
+		if e, ok := encoders[mediaType]; ok {
+			return mediaType, e
+		}
+	}
+	return "", nil
+}
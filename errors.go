@@ -0,0 +1,126 @@
+// This file contains the errors URL results can fail with, and their
+// classification into the reasons reported to clients.
+package numbers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+var (
+	// ErrBadStatus is matched by the errors of responses with a status other
+	// than 200. See StatusError.
+	ErrBadStatus = errors.New("unexpected response status")
+
+	// ErrDecode is wrapped by the errors of responses that could not be
+	// decoded into numbers.
+	ErrDecode = errors.New("invalid response")
+
+	// ErrTooDeep is returned for responses whose JSON nesting is deeper than
+	// Config.MaxJSONDepth.
+	ErrTooDeep = errors.New("JSON nesting too deep")
+
+	// ErrTooLarge is returned for responses with a body larger than the
+	// configured maximum.
+	ErrTooLarge = errors.New("response too large")
+
+	// ErrBlocked is wrapped by the errors of URLs that were refused before
+	// being queried, such as by Config.URLRewrite.
+	ErrBlocked = errors.New("url blocked")
+
+	// ErrSkipped is the error of URLs that were not queried because the
+	// request ended before their turn came.
+	ErrSkipped = errors.New("url skipped")
+
+	// ErrOverflow is the error of URLs whose result was dropped because the
+	// consumer did not keep up. See Config.OverflowPolicy.
+	ErrOverflow = errors.New("url result dropped, consumer too slow")
+
+	// ErrUnfollowableRedirect is matched by the errors of redirect responses
+	// that could not be followed, because they have no Location or because
+	// redirects are disabled. They also match ErrBadStatus.
+	ErrUnfollowableRedirect = errors.New("redirect cannot be followed")
+
+	// ErrAllFailed is wrapped by the error of MergeNumbers when no URL
+	// succeeded.
+	ErrAllFailed = errors.New("every url failed")
+
+	// ErrCircuitOpen is the error of URLs that were not queried because their
+	// host failed too often recently. See Config.BreakerThreshold.
+	ErrCircuitOpen = errors.New("url skipped, host circuit open")
+
+	// ErrFloatNumbers is returned by MergeNumbers and ProcessURLsTagged, which
+	// only report integers, for a Config with FloatNumbers.
+	ErrFloatNumbers = errors.New("float numbers are only collected by NumbersGetter")
+)
+
+// StatusError is returned by the default getter for responses with a status
+// other than 200.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("service unavailable: status %d", e.StatusCode)
+}
+
+// Is makes a StatusError match ErrBadStatus, and ErrUnfollowableRedirect for
+// redirect statuses.
+func (e *StatusError) Is(target error) bool {
+	return target == ErrBadStatus || target == ErrUnfollowableRedirect && isRedirect(e.StatusCode)
+}
+
+// isRedirect reports whether code is the status of a redirect. 304 Not
+// Modified redirects nowhere and is not one.
+func isRedirect(code int) bool {
+	return code >= 300 && code < 400 && code != http.StatusNotModified
+}
+
+// The reasons a URL can fail for, as reported to clients.
+const (
+	reasonTimeout    = "timeout"
+	reasonStatus     = "bad_status"
+	reasonDecode     = "decode"
+	reasonTooLarge   = "too_large"
+	reasonBlocked    = "blocked"
+	reasonInvalidURL = "invalid_url"
+	reasonNetwork    = "network"
+	reasonSkipped    = "skipped"
+	reasonOverflow   = "overflow"
+	reasonOpen       = "circuit_open"
+	reasonRedirect   = "unfollowable_redirect"
+)
+
+// classify returns the reason a URL failed with err.
+func classify(err error) string {
+	var urlErr *url.Error
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrSkipped):
+		return reasonSkipped
+	case errors.Is(err, ErrOverflow):
+		return reasonOverflow
+	case errors.Is(err, ErrCircuitOpen):
+		return reasonOpen
+	case errors.Is(err, ErrBlocked):
+		return reasonBlocked
+	case errors.Is(err, ErrDecode):
+		return reasonDecode
+	case errors.Is(err, ErrTooLarge):
+		return reasonTooLarge
+	case errors.Is(err, ErrUnfollowableRedirect):
+		return reasonRedirect
+	case errors.Is(err, ErrBadStatus):
+		return reasonStatus
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return reasonTimeout
+	case errors.As(err, &urlErr) && urlErr.Op == "parse":
+		return reasonInvalidURL
+	}
+	return reasonNetwork
+}
@@ -0,0 +1,25 @@
+// This file contains the entity tags of the responses, which let clients
+// polling for the same URLs skip the body while the result is unchanged.
+package numbers
+
+import "strings"
+
+// etagFor returns the weak entity tag of a response holding numbers, encoded
+// as mediaType. Responses indented differently share it, but those encoded
+// differently do not, so that a cache cannot answer a request for one
+// encoding with the validator of another.
+func etagFor(numbers []int, mediaType string) string {
+	return `W/"` + digestNumbers(numbers) + "-" + mediaType + `"`
+}
+
+// etagMatches reports whether ifNoneMatch, the value of an If-None-Match
+// header, lists etag, using the weak comparison of RFC 9110.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
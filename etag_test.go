@@ -0,0 +1,86 @@
+// Tests for the entity tags of the responses.
+package numbers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServeHTTPETag(t *testing.T) {
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		URLGetter:       staticGetter{"http://a": `{"numbers":[3,1,2]}`},
+	}}
+
+	w := serve(ng, "/numbers?u=http://a")
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("ETag missing from the response")
+	}
+
+	r := httptest.NewRequest("GET", "/numbers?u=http://a", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	ng.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("status mismatch for unchanged result: %s", comp(http.StatusNotModified, w.Code))
+	}
+	if w.Body.Len() != 0 {
+		t.Fatalf("body sent with 304: %q", w.Body.String())
+	}
+
+	ng.URLGetter = staticGetter{"http://a": `{"numbers":[4]}`}
+	w = httptest.NewRecorder()
+	ng.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status mismatch for changed result: %s", comp(http.StatusOK, w.Code))
+	}
+}
+
+func TestServeHTTPETagPerEncoding(t *testing.T) {
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		URLGetter:       staticGetter{"http://a": `{"numbers":[3,1,2]}`},
+	}}
+
+	get := func(accept, ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/numbers?u=http://a", nil)
+		r.Header.Set("Accept", accept)
+		r.Header.Set("If-None-Match", ifNoneMatch)
+		w := httptest.NewRecorder()
+		ng.ServeHTTP(w, r)
+		return w
+	}
+
+	jsonTag := get("application/json", "").Header().Get("ETag")
+	w := get(xmlContentType, "")
+	if xmlTag := w.Header().Get("ETag"); xmlTag == "" || xmlTag == jsonTag {
+		t.Fatalf("ETag of the XML response mismatch: %s", comp("a tag other than "+jsonTag, xmlTag))
+	}
+	if got := w.Header().Get("Vary"); got != "Accept" {
+		t.Fatalf("Vary mismatch: %s", comp("Accept", got))
+	}
+
+	// The tag of one encoding does not validate another.
+	if w := get(xmlContentType, jsonTag); w.Code != http.StatusOK {
+		t.Fatalf("status mismatch for the tag of another encoding: %s", comp(http.StatusOK, w.Code))
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	etag := `W/"abc"`
+	for header, exp := range map[string]bool{
+		`W/"abc"`:      true,
+		`"abc"`:        true,
+		`"x", W/"abc"`: true,
+		`*`:            true,
+		`"abd"`:        false,
+		``:             false,
+	} {
+		if got := etagMatches(header, etag); got != exp {
+			t.Fatalf("match mismatch for %q: %s", header, comp(exp, got))
+		}
+	}
+}
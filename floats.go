@@ -0,0 +1,83 @@
+// This file contains the collection and writing of the responses with
+// FloatNumbers, which only support the options that do not depend on the
+// numbers being integers.
+package numbers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// mergeUniqueFloats returns the sorted, de-duplicated floats of the results.
+func mergeUniqueFloats(results []urlResult) []float64 {
+	seen := make(map[float64]bool)
+	response := []float64{}
+	for _, r := range results {
+		for _, f := range r.floats {
+			if !seen[f] {
+				seen[f] = true
+				response = append(response, f)
+			}
+		}
+	}
+	sort.Float64s(response)
+	return response
+}
+
+// mergeAllFloats works like mergeAll for floats.
+func mergeAllFloats(results []urlResult, total int) []float64 {
+	response := make([]float64, 0, total)
+	for _, r := range results {
+		response = append(response, r.floats...)
+	}
+	sort.Float64s(response)
+	return response
+}
+
+// floatsResponse is the JSON body of a response with FloatNumbers. Its
+// Numbers replace those of numbersResponse, leaving the other fields as is.
+type floatsResponse struct {
+	numbersResponse
+	Numbers []float64 `json:"Numbers"`
+}
+
+// writeFloats writes the response holding the floats of c, always as JSON.
+func (ng *NumbersGetter) writeFloats(w http.ResponseWriter, c *collection, opts *requestOptions, urls []string) {
+	ordered := c.floats
+	if opts.order == OrderDesc {
+		ordered = make([]float64, len(c.floats))
+		for i, f := range c.floats {
+			ordered[len(c.floats)-1-i] = f
+		}
+	}
+
+	status := http.StatusOK
+	if len(ordered) == 0 && ng.EmptyStatus != 0 {
+		status = ng.EmptyStatus
+	}
+	if ng.drained() {
+		w.Header().Set("X-Partial", "true")
+	}
+	if c.capped {
+		w.Header().Set("X-Memory-Capped", "true")
+	}
+	if status == http.StatusNoContent {
+		w.WriteHeader(status)
+		return
+	}
+
+	w.Header().Set("Trailer", "X-Final-Count")
+	defer w.Header().Set("X-Final-Count", strconv.Itoa(len(ordered)))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	resp := &floatsResponse{Numbers: ordered}
+	ng.describe(&resp.numbersResponse, c, opts, urls)
+	enc := json.NewEncoder(w)
+	if opts.pretty {
+		enc.SetIndent("", "  ")
+	}
+	enc.Encode(resp)
+}
@@ -0,0 +1,64 @@
+// Tests for the responses with FloatNumbers.
+package numbers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeHTTPFloatNumbers(t *testing.T) {
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		NumberKind:      FloatNumbers,
+		URLGetter:       staticGetter{"http://a": `{"numbers":[1.5,2.25]}`, "http://b": `{"numbers":[2.25,1,-0.5,1.0]}`},
+	}}
+
+	for q, exp := range map[string][]float64{
+		"":           {-0.5, 1, 1.5, 2.25},
+		"order=desc": {2.25, 1.5, 1, -0.5},
+	} {
+		w := serve(ng, "/numbers?u=http://a&u=http://b&"+q)
+		var resp struct{ Numbers []float64 }
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+		}
+		if !reflect.DeepEqual(exp, resp.Numbers) {
+			t.Fatalf("numbers mismatch for %q: %s", q, comp(exp, resp.Numbers))
+		}
+		if !strings.Contains(w.Body.String(), "2.25") {
+			t.Fatalf("floats not written as such: %s", w.Body.String())
+		}
+	}
+
+	// The options left working describe the URLs.
+	w := serve(ng, "/numbers?u=http://a&u=http://fail&includeErrors=1")
+	var resp struct{ Errors []urlError }
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+	if len(resp.Errors) != 1 {
+		t.Fatalf("errors mismatch: %s", comp(1, len(resp.Errors)))
+	}
+
+	for _, q := range []string{"digest=1", "op=symdiff", "min=1"} {
+		if w := serve(ng, "/numbers?u=http://a&u=http://b&"+q); w.Code != http.StatusBadRequest {
+			t.Fatalf("status mismatch for %s: %s", q, comp(http.StatusBadRequest, w.Code))
+		}
+	}
+}
+
+func TestDecodeFloats(t *testing.T) {
+	exp := []float64{1, 0.1, -3e2}
+
+	got, err := decodeFloats([]byte(`{"numbers":[1,0.1,-3e2]} served`), &Config{})
+	if err != errTrailingData || !reflect.DeepEqual(exp, got) {
+		t.Fatalf("numbers mismatch: %s", comp(exp, got))
+	}
+	if _, err := decodeFloats([]byte(`{"numbers":["1"]}`), &Config{}); err == nil {
+		t.Fatalf("string element not rejected")
+	}
+}
@@ -0,0 +1,110 @@
+// This file contains the retries of the GETs that fail in a way that is likely
+// transient, such as a reset connection or a 503, made by the default getter
+// or for Config.GetRetry.
+package numbers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy is how the GETs failing with a transient error are retried,
+// by the default getter given WithRetryPolicy or for the URLs of a Config
+// with GetRetry.
+type RetryPolicy struct {
+	// MaxAttempts is the largest number of times a URL is GET, the first
+	// attempt included. Values below 2 disable retries.
+	MaxAttempts int
+
+	// BaseDelay is the time waited before the first retry.
+	BaseDelay time.Duration
+
+	// Multiplier scales the delay after every retry. Values below 1 mean 2.
+	Multiplier float64
+
+	// Jitter is the fraction of every delay, between 0 and 1, that is
+	// randomized so that retries of many URLs do not happen in lockstep.
+	Jitter float64
+}
+
+// WithRetryPolicy makes the getter retry the GETs that fail with a transient
+// error according to p. The delays before the retries are cut short at the
+// deadline of the context, where the last failure is returned.
+func WithRetryPolicy(p RetryPolicy) GetOption {
+	return func(g *defaultGet, t *http.Transport) {
+		g.retry = p
+	}
+}
+
+// delay returns the time to wait before the retry following attempt, the
+// first attempt being 0.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	d := float64(p.BaseDelay)
+	for i := 0; i < attempt; i++ {
+		d *= multiplier
+	}
+	if p.Jitter > 0 {
+		d -= d * min(p.Jitter, 1) * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// retryTransient calls attempt until it succeeds, fails with an error that is
+// not transient, or the attempts of p run out, and returns its last error.
+// The delays before the retries are measured with clock and cut short at the
+// deadline of ctx, where the last error is returned.
+func retryTransient(ctx context.Context, clock Clock, p RetryPolicy, url string, attempt func() error) error {
+	for i := 0; ; i++ {
+		err := attempt()
+		if err == nil || i+1 >= p.MaxAttempts || ctx.Err() != nil || !transient(err) {
+			return err
+		}
+
+		delay := p.delay(i)
+		deadline, hasDeadline := ctx.Deadline()
+		if hasDeadline {
+			delay = min(delay, deadline.Sub(clock.Now()))
+		}
+		log.Printf("GET of %s failed with %v, retrying in %v", url, err, delay)
+		select {
+		case <-clock.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		// A retry made at the deadline would only fail with it, and the
+		// last failure is more telling.
+		if hasDeadline && !clock.Now().Before(deadline) {
+			return err
+		}
+	}
+}
+
+// transient reports whether err is a GET failure likely to go away when
+// retried: a timeout, a connection reset or closed early, or a 408, 502, 503
+// or 504 status.
+func transient(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout() ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
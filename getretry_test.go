@@ -0,0 +1,117 @@
+// Tests for the retries of the default getter.
+package numbers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDefaultGetRetryPolicy(t *testing.T) {
+	// /flaky fails twice with a 503 before responding.
+	var hits atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		switch {
+		case r.URL.Path == "/bad":
+			w.WriteHeader(http.StatusBadRequest)
+		case r.URL.Path == "/down", n <= 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte(`{"numbers":[1]}`))
+		}
+	}))
+	defer ts.Close()
+
+	g := NewDefaultGet(time.Second, WithRetryPolicy(RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond}))
+	if _, err := g.Get(context.Background(), ts.URL+"/flaky"); err != nil {
+		t.Fatalf("unexpected error after retries: %v", err)
+	}
+	if got := hits.Load(); got != 3 {
+		t.Fatalf("attempts mismatch: %s", comp(3, got))
+	}
+
+	hits.Store(0)
+	var statusErr *StatusError
+	if _, err := g.Get(context.Background(), ts.URL+"/bad"); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("error mismatch: %s", comp(http.StatusBadRequest, err))
+	}
+	if got := hits.Load(); got != 1 {
+		t.Fatalf("attempts mismatch for a 400: %s", comp(1, got))
+	}
+
+	var urlErr *url.Error
+	if _, err := g.Get(context.Background(), "http://bad host/"); !errors.As(err, &urlErr) {
+		t.Fatalf("error mismatch for a malformed URL: %s", comp("*url.Error", err))
+	}
+
+	// Delays reaching the deadline are cut short at it, where the last
+	// failure is returned.
+	g = NewDefaultGet(time.Second, WithRetryPolicy(RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour}))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := g.Get(ctx, ts.URL+"/down"); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("last error not returned: %s", comp(http.StatusServiceUnavailable, err))
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("delay not cut short at the deadline: %s", comp("100ms", elapsed))
+	}
+}
+
+func TestConfigGetRetry(t *testing.T) {
+	var hits atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	// GetRetry takes over from MaxRetries rather than having each of its
+	// attempts retried.
+	cfg := &Config{
+		GetTimeout:   time.Second,
+		GetRetry:     RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+		MaxRetries:   5,
+		RetryBackoff: time.Millisecond,
+	}
+	if _, err := MergeNumbers(context.Background(), cfg, []string{ts.URL}); !errors.Is(err, ErrAllFailed) {
+		t.Fatalf("error mismatch: %s", comp(ErrAllFailed, err))
+	}
+	if got := hits.Load(); got != 3 {
+		t.Fatalf("attempts mismatch: %s", comp(3, got))
+	}
+
+	// It applies to getters other than the default one too.
+	cg := &countingGetter{URLGetter: NewDefaultGet(time.Second)}
+	cfg = &Config{URLGetter: cg, GetRetry: RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}}
+	MergeNumbers(context.Background(), cfg, []string{ts.URL})
+	if got := cg.count(ts.URL); got != 2 {
+		t.Fatalf("attempts mismatch with a custom getter: %s", comp(2, got))
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 10 * time.Millisecond, Multiplier: 3}
+	for attempt, exp := range []time.Duration{10 * time.Millisecond, 30 * time.Millisecond, 90 * time.Millisecond} {
+		if got := p.delay(attempt); got != exp {
+			t.Fatalf("delay mismatch for attempt %d: %s", attempt, comp(exp, got))
+		}
+	}
+
+	if got := (RetryPolicy{BaseDelay: time.Second}).delay(1); got != 2*time.Second {
+		t.Fatalf("default multiplier mismatch: %s", comp(2*time.Second, got))
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.delay(1); got < 15*time.Millisecond || got > 30*time.Millisecond {
+			t.Fatalf("jittered delay out of bounds: %s", comp("15ms to 30ms", got))
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// Package grpc serves NumbersService, declared in numbers.proto, for gRPC
+// clients. Its Fetch call queries URLs with numbers.ProcessURLs and streams
+// the numbers of every URL back as soon as it responds.
+//
+// gRPC runs over HTTP/2, which net/http serves over TLS out of the box. Like the rest of the module, the package sticks to
+// the standard library: the messages of numbers.proto are encoded by hand in
+// place of generated code, and Server handles the gRPC framing itself.
+package grpc
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"numbers"
+)
+
+// FetchPath is the path of the requests calling NumbersService.Fetch.
+const FetchPath = "/numbers.NumbersService/Fetch"
+
+// maxRequestSize is the largest FetchRequest accepted, in bytes. It is the
+// default limit of gRPC servers.
+const maxRequestSize = 4 << 20
+
+// maxChunkNumbers is the largest number of numbers sent in a NumbersChunk,
+// which keeps the chunks of the largest responses within the default 4MB a
+// gRPC client accepts.
+const maxChunkNumbers = 1 << 16
+
+// The gRPC status codes sent by Server.
+const (
+	codeOK                = 0
+	codeCanceled          = 1
+	codeInvalidArgument   = 3
+	codeDeadlineExceeded  = 4
+	codeResourceExhausted = 8
+	codeUnimplemented     = 12
+	codeInternal          = 13
+)
+
+// Server implements NumbersService as an http.Handler, to be served over
+// HTTP/2. The URLs of a FetchRequest are queried under Config, like those of
+// a request to numbers.NumbersGetter.
+type Server struct {
+	numbers.Config
+
+	setup sync.Once
+}
+
+// ServeHTTP handles the calls to NumbersService.Fetch. The deadline of the
+// call, sent in the grpc-timeout header, applies to the URLs queried.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "application/grpc" && !strings.HasPrefix(ct, "application/grpc+") {
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+	// ProcessURLs fills in the defaults of the Config it is given, so it is
+	// run once without URLs before calls share the Config.
+	s.setup.Do(func() {
+		for range numbers.ProcessURLs(context.Background(), &s.Config, nil) {
+		}
+	})
+
+	w.Header().Set("Content-Type", "application/grpc")
+	if r.URL.Path != FetchPath {
+		writeStatus(w, codeUnimplemented, "unknown method "+r.URL.Path)
+		return
+	}
+
+	ctx := r.Context()
+	if v := r.Header.Get("Grpc-Timeout"); v != "" {
+		timeout, ok := parseTimeout(v)
+		if !ok {
+			writeStatus(w, codeInvalidArgument, "malformed grpc-timeout "+v)
+			return
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	msg, code, err := readMessage(r.Body)
+	if err != nil {
+		writeStatus(w, code, err.Error())
+		return
+	}
+	req := FetchRequest{}
+	if err := req.Unmarshal(msg); err != nil {
+		writeStatus(w, codeInvalidArgument, "malformed FetchRequest: "+err.Error())
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	var writeErr error
+	// The channel is drained even once the client is gone, so that the
+	// goroutines querying the URLs can return.
+	for ns := range numbers.ProcessURLs(ctx, &s.Config, req.URLs) {
+		for len(ns) > 0 && writeErr == nil {
+			n := min(len(ns), maxChunkNumbers)
+			writeErr = writeChunk(w, ns[:n])
+			ns = ns[n:]
+		}
+		if writeErr == nil && flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	switch {
+	case writeErr != nil:
+		writeStatus(w, codeInternal, "error writing response: "+writeErr.Error())
+	case ctx.Err() == context.DeadlineExceeded:
+		writeStatus(w, codeDeadlineExceeded, "deadline exceeded")
+	case ctx.Err() != nil:
+		writeStatus(w, codeCanceled, "call cancelled")
+	default:
+		writeStatus(w, codeOK, "")
+	}
+}
+
+// readMessage reads the single, length-prefixed message of a call from body.
+// The gRPC status code to fail the call with is returned along with an error.
+func readMessage(body io.Reader) ([]byte, int, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(body, prefix[:]); err != nil {
+		return nil, codeInvalidArgument, fmt.Errorf("error reading request: %v", err)
+	}
+	if prefix[0] != 0 {
+		return nil, codeUnimplemented, fmt.Errorf("compressed requests are not supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > maxRequestSize {
+		return nil, codeResourceExhausted, fmt.Errorf("request of %d bytes is larger than %d", size, maxRequestSize)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(body, msg); err != nil {
+		return nil, codeInvalidArgument, fmt.Errorf("error reading request: %v", err)
+	}
+	return msg, codeOK, nil
+}
+
+// writeChunk writes ns to w as a length-prefixed NumbersChunk.
+func writeChunk(w io.Writer, ns []int) error {
+	c := NumbersChunk{Numbers: make([]int64, len(ns))}
+	for i, n := range ns {
+		c.Numbers[i] = int64(n)
+	}
+	msg := c.Marshal()
+
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	_, err := w.Write(append(frame, msg...))
+	return err
+}
+
+// writeStatus ends the call with the given gRPC status code and message, sent
+// in the trailers of the response.
+func writeStatus(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if msg == "" {
+		return
+	}
+	// gRPC expects the bytes outside of printable ASCII, and '%', to be
+	// percent-encoded.
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Message", b.String())
+}
+
+// timeoutUnits are the units of grpc-timeout values.
+var timeoutUnits = map[byte]time.Duration{
+	'H': time.Hour,
+	'M': time.Minute,
+	'S': time.Second,
+	'm': time.Millisecond,
+	'u': time.Microsecond,
+	'n': time.Nanosecond,
+}
+
+// parseTimeout parses a grpc-timeout value: up to 8 digits followed by a unit.
+func parseTimeout(v string) (time.Duration, bool) {
+	if len(v) < 2 || len(v) > 9 {
+		return 0, false
+	}
+	unit, ok := timeoutUnits[v[len(v)-1]]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(v[:len(v)-1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
@@ -0,0 +1,196 @@
+// Tests for NumbersService over HTTP/2.
+package grpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"numbers/internal/testutil"
+)
+
+func TestFetch(t *testing.T) {
+	large := make([]int, maxChunkNumbers+10)
+	for i := range large {
+		large[i] = -i
+	}
+	urls := []string{
+		backend(t, `{"Numbers": [1, 2, 3]}`),
+		backend(t, `{"Numbers": [5, 8]}`),
+		backend(t, fmt.Sprintf(`{"Numbers": %s}`, strings.Join(strings.Fields(fmt.Sprint(large)), ","))),
+		"http://127.0.0.1:0/unreachable",
+	}
+
+	url, client := fetchServer(t, &Server{})
+	chunks, status := call(t, client, url, "", encodeRequest(urls))
+	if status != "0" {
+		t.Fatalf("status mismatch: %s", testutil.Comp("0", status))
+	}
+	// The chunks of a URL are sent in a row, those of the large response
+	// first split and then its remainder.
+	sort.Slice(chunks, func(i, j int) bool { return len(chunks[i]) > len(chunks[j]) })
+	exp := [][]int64{make([]int64, maxChunkNumbers), {-65536, -65537, -65538, -65539, -65540, -65541, -65542, -65543, -65544, -65545}, {1, 2, 3}, {5, 8}}
+	for i := range exp[0] {
+		exp[0][i] = int64(-i)
+	}
+	if !reflect.DeepEqual(exp, chunks) {
+		t.Fatalf("chunks mismatch: %s", testutil.Comp(exp[1:], chunks[1:]))
+	}
+}
+
+func TestFetchDeadline(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer slow.Close()
+
+	url, client := fetchServer(t, &Server{})
+	start := time.Now()
+	chunks, status := call(t, client, url, "50m", encodeRequest([]string{slow.URL}))
+	if status != "4" || len(chunks) != 0 {
+		t.Fatalf("deadline status mismatch: %s", testutil.Comp("4 without chunks", fmt.Sprint(status, chunks)))
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("deadline not honoured: call took %v", elapsed)
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	url, client := fetchServer(t, &Server{})
+
+	if _, status := call(t, client, url+"/numbers.NumbersService/Other", "", encodeRequest(nil)); status != "12" {
+		t.Fatalf("unknown method status mismatch: %s", testutil.Comp("12", status))
+	}
+	compressed := encodeRequest([]string{"http://example.com"})
+	compressed[0] = 1
+	if _, status := call(t, client, url, "", compressed); status != "12" {
+		t.Fatalf("compressed request status mismatch: %s", testutil.Comp("12", status))
+	}
+	if _, status := call(t, client, url, "", []byte{0, 0, 0, 0, 2, urlsField<<3 | 2, 5}); status != "3" {
+		t.Fatalf("malformed request status mismatch: %s", testutil.Comp("3", status))
+	}
+	if _, status := call(t, client, url, "1x", encodeRequest(nil)); status != "3" {
+		t.Fatalf("malformed timeout status mismatch: %s", testutil.Comp("3", status))
+	}
+
+	resp, err := client.Post(url, "application/json", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatalf("content type status mismatch: %s", testutil.Comp(http.StatusUnsupportedMediaType, resp.StatusCode))
+	}
+}
+
+func TestMessages(t *testing.T) {
+	req := FetchRequest{URLs: []string{"http://a", "", "http://b"}}
+	got := FetchRequest{}
+	if err := got.Unmarshal(req.Marshal()); err != nil || !reflect.DeepEqual(req, got) {
+		t.Fatalf("FetchRequest mismatch: %s, error %v", testutil.Comp(req, got), err)
+	}
+
+	c := NumbersChunk{Numbers: []int64{-1 << 40, -1, 0, 300}}
+	gotChunk := NumbersChunk{}
+	// An unknown field is skipped.
+	if err := gotChunk.Unmarshal(append([]byte{2<<3 | 0, 7}, c.Marshal()...)); err != nil || !reflect.DeepEqual(c, gotChunk) {
+		t.Fatalf("NumbersChunk mismatch: %s, error %v", testutil.Comp(c, gotChunk), err)
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	for v, exp := range map[string]time.Duration{
+		"1H":         time.Hour,
+		"30S":        30 * time.Second,
+		"100m":       100 * time.Millisecond,
+		"99999999n":  99999999,
+		"":           -1,
+		"m":          -1,
+		"100":        -1,
+		"-1m":        -1,
+		"123456789m": -1,
+	} {
+		got, ok := parseTimeout(v)
+		if !ok {
+			got = -1
+		}
+		if got != exp {
+			t.Fatalf("timeout %q mismatch: %s", v, testutil.Comp(exp, got))
+		}
+	}
+}
+
+// backend starts a server responding with the JSON body and returns its URL.
+func backend(t *testing.T, body string) string {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+// fetchServer starts s over HTTP/2 and returns the URL of Fetch along with
+// a client for it.
+func fetchServer(t *testing.T, s *Server) (string, *http.Client) {
+	ts := httptest.NewUnstartedServer(s)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts.URL + FetchPath, ts.Client()
+}
+
+// encodeRequest returns the framed FetchRequest of urls.
+func encodeRequest(urls []string) []byte {
+	msg := (&FetchRequest{URLs: urls}).Marshal()
+	return append(binary.BigEndian.AppendUint32([]byte{0}, uint32(len(msg))), msg...)
+}
+
+// call sends body to url with client, with the grpc-timeout given if any, and returns the
+// numbers of the chunks streamed back and the gRPC status of the call.
+func call(t *testing.T, client *http.Client, url, timeout string, body []byte) ([][]int64, string) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	if timeout != "" {
+		req.Header.Set("Grpc-Timeout", timeout)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 || resp.Header.Get("Content-Type") != "application/grpc" {
+		t.Fatalf("response mismatch: %s", testutil.Comp("HTTP/2 application/grpc", resp.Proto+" "+resp.Header.Get("Content-Type")))
+	}
+
+	var chunks [][]int64
+	var prefix [5]byte
+	for {
+		if _, err := io.ReadFull(resp.Body, prefix[:]); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("unexpected error reading chunk: %v", err)
+		}
+		msg := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+		if _, err := io.ReadFull(resp.Body, msg); err != nil {
+			t.Fatalf("unexpected error reading chunk: %v", err)
+		}
+		c := NumbersChunk{}
+		if err := c.Unmarshal(msg); err != nil {
+			t.Fatalf("unexpected error decoding chunk: %v", err)
+		}
+		chunks = append(chunks, c.Numbers)
+	}
+	return chunks, resp.Trailer.Get("Grpc-Status")
+}
@@ -0,0 +1,113 @@
+package grpc
+
+import (
+	"encoding/binary"
+
+	"numbers/internal/protowire"
+)
+
+// The field numbers of the messages in numbers.proto.
+const (
+	urlsField    = 1
+	numbersField = 1
+)
+
+// FetchRequest is the request of NumbersService.Fetch.
+type FetchRequest struct {
+	URLs []string
+}
+
+// Marshal returns the wire encoding of req.
+func (req *FetchRequest) Marshal() []byte {
+	var data []byte
+	for _, url := range req.URLs {
+		data = protowire.AppendBytes(data, urlsField, []byte(url))
+	}
+	return data
+}
+
+// Unmarshal sets req to the FetchRequest encoded in data. Unknown fields are
+// skipped.
+func (req *FetchRequest) Unmarshal(data []byte) error {
+	req.URLs = nil
+	for len(data) > 0 {
+		field, wire, rest, err := protowire.ReadTag(data)
+		if err != nil {
+			return err
+		}
+		if field == urlsField && wire == protowire.Bytes {
+			url, rest, err := protowire.ReadBytes(rest)
+			if err != nil {
+				return err
+			}
+			req.URLs = append(req.URLs, string(url))
+			data = rest
+			continue
+		}
+		if data, err = protowire.SkipField(rest, wire); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NumbersChunk is a message of the stream NumbersService.Fetch responds with.
+type NumbersChunk struct {
+	Numbers []int64
+}
+
+// Marshal returns the wire encoding of c, with the numbers packed.
+func (c *NumbersChunk) Marshal() []byte {
+	if len(c.Numbers) == 0 {
+		return nil
+	}
+	var packed []byte
+	for _, n := range c.Numbers {
+		packed = binary.AppendUvarint(packed, uint64(n))
+	}
+	return protowire.AppendBytes(nil, numbersField, packed)
+}
+
+// Unmarshal sets c to the NumbersChunk encoded in data. Both packed and
+// unpacked encodings of the numbers are accepted, and unknown fields are
+// skipped.
+func (c *NumbersChunk) Unmarshal(data []byte) error {
+	c.Numbers = nil
+	for len(data) > 0 {
+		field, wire, rest, err := protowire.ReadTag(data)
+		if err != nil {
+			return err
+		}
+		data = rest
+
+		if field == numbersField && wire == protowire.Varint {
+			v, rest, err := protowire.ReadVarint(data)
+			if err != nil {
+				return err
+			}
+			c.Numbers = append(c.Numbers, int64(v))
+			data = rest
+			continue
+		}
+		if field == numbersField && wire == protowire.Bytes {
+			packed, rest, err := protowire.ReadBytes(data)
+			if err != nil {
+				return err
+			}
+			for len(packed) > 0 {
+				var v uint64
+				if v, packed, err = protowire.ReadVarint(packed); err != nil {
+					return err
+				}
+				c.Numbers = append(c.Numbers, int64(v))
+			}
+			data = rest
+			continue
+		}
+
+		if data, err = protowire.SkipField(data, wire); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+// Package protowire reads and writes the parts of the protocol buffers wire
+// format used by the packages of the module, which keeps them free of third
+// party dependencies.
+package protowire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The wire types of protocol buffers fields.
+const (
+	Varint  = 0
+	Fixed64 = 1
+	Bytes   = 2
+	Fixed32 = 5
+)
+
+// ErrTruncated is returned for messages ending in the middle of a field.
+var ErrTruncated = errors.New("truncated message")
+
+// ReadTag reads the tag of a field at the start of data and returns its field
+// number and wire type, along with what follows it.
+func ReadTag(data []byte) (field, wire uint64, rest []byte, err error) {
+	tag, rest, err := ReadVarint(data)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return tag >> 3, tag & 7, rest, nil
+}
+
+// ReadVarint reads a varint at the start of data and returns it along with
+// what follows it.
+func ReadVarint(data []byte) (uint64, []byte, error) {
+	v, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, nil, ErrTruncated
+	}
+	return v, data[n:], nil
+}
+
+// ReadBytes reads a length-delimited value at the start of data and returns it
+// along with what follows it.
+func ReadBytes(data []byte) ([]byte, []byte, error) {
+	l, n := binary.Uvarint(data)
+	if n <= 0 || l > uint64(len(data)-n) {
+		return nil, nil, ErrTruncated
+	}
+	data = data[n:]
+	return data[:l], data[l:], nil
+}
+
+// SkipField returns what follows the value of the given wire type at the start
+// of data.
+func SkipField(data []byte, wire uint64) ([]byte, error) {
+	switch wire {
+	case Varint:
+		_, rest, err := ReadVarint(data)
+		return rest, err
+	case Fixed64, Fixed32:
+		size := 8
+		if wire == Fixed32 {
+			size = 4
+		}
+		if len(data) < size {
+			return nil, ErrTruncated
+		}
+		return data[size:], nil
+	case Bytes:
+		_, rest, err := ReadBytes(data)
+		return rest, err
+	}
+	return nil, fmt.Errorf("unsupported wire type %d", wire)
+}
+
+// AppendTag appends the tag of a field of the given number and wire type to b.
+func AppendTag(b []byte, field, wire uint64) []byte {
+	return binary.AppendUvarint(b, field<<3|wire)
+}
+
+// AppendBytes appends the field of the given number holding v, a
+// length-delimited value, to b.
+func AppendBytes(b []byte, field uint64, v []byte) []byte {
+	b = AppendTag(b, field, Bytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
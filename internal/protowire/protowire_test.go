@@ -0,0 +1,39 @@
+// Tests for the protocol buffers wire format helpers.
+package protowire
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestSkipField(t *testing.T) {
+	// Every field is followed by the byte 0xff, which skipping must reach.
+	for wire, value := range map[uint64][]byte{
+		Varint:  binary.AppendUvarint(nil, 1<<40),
+		Fixed64: make([]byte, 8),
+		Bytes:   AppendBytes(nil, 1, []byte("hi"))[1:],
+		Fixed32: make([]byte, 4),
+	} {
+		rest, err := SkipField(append(value, 0xff), wire)
+		if err != nil || len(rest) != 1 || rest[0] != 0xff {
+			t.Fatalf("wire type %d not skipped: rest %v, error %v", wire, rest, err)
+		}
+		if _, err := SkipField(value[:len(value)-1], wire); err != ErrTruncated {
+			t.Fatalf("truncated wire type %d error mismatch: expected: %v -- got: %v", wire, ErrTruncated, err)
+		}
+	}
+
+	if _, err := SkipField([]byte{0}, 3); err == nil {
+		t.Fatal("group wire type skipped")
+	}
+}
+
+func TestReadTag(t *testing.T) {
+	field, wire, rest, err := ReadTag(append(AppendTag(nil, 300, Bytes), 0xff))
+	if err != nil || field != 300 || wire != Bytes || len(rest) != 1 {
+		t.Fatalf("tag mismatch: field %d, wire %d, rest %v, error %v", field, wire, rest, err)
+	}
+	if _, _, _, err := ReadTag(nil); err != ErrTruncated {
+		t.Fatalf("empty tag error mismatch: expected: %v -- got: %v", ErrTruncated, err)
+	}
+}
@@ -0,0 +1,32 @@
+// Package testutil holds the fixtures shared by the tests of the packages of
+// the module.
+package testutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Comp describes a mismatch between an expected and an actual value, for the
+// messages of failed tests.
+func Comp(exp, got interface{}) string {
+	return fmt.Sprintf("expected: %v -- got: %v", exp, got)
+}
+
+// StaticGetter is a numbers.URLGetter serving canned response bodies keyed by
+// URL. Unknown URLs fail.
+type StaticGetter map[string]string
+
+func (s StaticGetter) Get(ctx context.Context, url string) ([]byte, error) {
+	body, ok := s[url]
+	if !ok {
+		return nil, errors.New("service unavailable")
+	}
+	return []byte(body), nil
+}
+
+func (s StaticGetter) Client() *http.Client {
+	return nil
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"numbers"
+	_ "numbers/msgpack"
+	_ "numbers/protobuf"
 )
 
 func main() {
@@ -14,6 +20,15 @@ func main() {
 	responseTimeout := flag.Int("timeout.response", 480, "server response timeout (in ms)")
 	getTimeout := flag.Int("timeout.geturl", 450, "timeout for URL get calls (in ms)")
 	numGoRoutines := flag.Int("goroutine.count", 20, "concurrency factor")
+	getAttempts := flag.Int("geturl.attempts", 1, "attempts of URL get calls failing with a transient error, the first included")
+	getRetryDelay := flag.Int("geturl.retry.delay", 20, "delay before the first retry of a URL get call, doubled on each retry (in ms)")
+	cacheTTL := flag.Int("cache.ttl", 0, "time URL responses are reused for (in ms); 0 disables the cache")
+	serveConfig := flag.Bool("http.config", false, "serve the effective configuration at /config")
+	tuneToken := flag.String("http.tune.token", "", "bearer token authorizing POST /admin/tune; the endpoint is not served without one")
+	once := flag.Bool("once", false, "query the URLs given as arguments or in -urls.file once, print their numbers and exit")
+	urlsFile := flag.String("urls.file", "", "file listing the URLs to query in -once mode, one per line; - reads stdin")
+	drainWindow := flag.Int("shutdown.drain", 1000, "time given to requests in flight to return partial results on shutdown (in ms)")
+	selfTest := flag.Bool("selftest", false, "check the pipeline against canned responses before serving, and exit if it fails")
 
 	flag.Parse()
 
@@ -21,7 +36,58 @@ func main() {
 	ng.ResponseTimeout = time.Duration(*responseTimeout) * time.Millisecond
 	ng.GetTimeout = time.Duration(*getTimeout) * time.Millisecond
 	ng.NumGoRoutines = *numGoRoutines
+	ng.CacheTTL = time.Duration(*cacheTTL) * time.Millisecond
+	ng.GetRetry = numbers.RetryPolicy{
+		MaxAttempts: *getAttempts,
+		BaseDelay:   time.Duration(*getRetryDelay) * time.Millisecond,
+		Multiplier:  2,
+		Jitter:      0.2,
+	}
+
+	if *once {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+		defer stop()
+		if err := runOnce(ctx, &ng.Config, *urlsFile, flag.Args(), os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *selfTest {
+		if err := numbers.SelfTest(context.Background()); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	http.Handle("/numbers", ng)
-	log.Fatal(http.ListenAndServe(*listenAddr, nil))
+	http.Handle("/replay", ng.ReplayHandler())
+	if *serveConfig {
+		http.Handle("/config", ng.ConfigHandler())
+	}
+	if *tuneToken != "" {
+		http.Handle("/admin/tune", ng.TuneHandler(*tuneToken))
+	}
+	srv := &http.Server{Addr: *listenAddr}
+
+	// On SIGTERM, requests in flight return what they collected so far, and
+	// are given the drain window to do so before the server closes.
+	stopped := make(chan struct{})
+	go func() {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGTERM, os.Interrupt)
+		<-sigCh
+
+		ng.Drain()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(*drainWindow)*time.Millisecond)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("error shutting down: %v", err)
+		}
+		close(stopped)
+	}()
+
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-stopped
 }
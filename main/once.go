@@ -0,0 +1,85 @@
+// This file contains the one-shot mode of the command, which queries a list of
+// URLs once and prints the result instead of serving requests.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"numbers"
+)
+
+// runOnce queries the URLs listed in urlsFile, one per line, along with urls,
+// and writes their sorted, de-duplicated numbers to w as JSON. A urlsFile of
+// "-" reads the list from stdin until EOF, and an empty one reads no list.
+// Reading the list and querying the URLs both stop when ctx is done.
+func runOnce(ctx context.Context, cfg *numbers.Config, urlsFile string, urls []string, stdin io.Reader, w io.Writer) error {
+	if urlsFile != "" {
+		in := stdin
+		if urlsFile != "-" {
+			f, err := os.Open(urlsFile)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+		listed, err := readURLs(ctx, in)
+		if err != nil {
+			return err
+		}
+		urls = append(urls, listed...)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.ResponseTimeout)
+	defer cancel()
+
+	seen := make(map[int]bool)
+	response := []int{}
+	for ns := range numbers.ProcessURLs(ctx, cfg, urls) {
+		for _, n := range ns {
+			if !seen[n] {
+				seen[n] = true
+				response = append(response, n)
+			}
+		}
+	}
+	sort.Ints(response)
+
+	return json.NewEncoder(w).Encode(struct{ Numbers []int }{response})
+}
+
+// readURLs returns the URLs listed in r, one per line, until EOF or until ctx
+// is done. Blank lines are skipped.
+func readURLs(ctx context.Context, r io.Reader) ([]string, error) {
+	type lines struct {
+		urls []string
+		err  error
+	}
+	// Reading may block, on a terminal for instance, so it is done in its own
+	// goroutine that is abandoned if ctx is done first.
+	linesCh := make(chan lines, 1)
+	go func() {
+		var l lines
+		s := bufio.NewScanner(r)
+		for s.Scan() {
+			if url := strings.TrimSpace(s.Text()); url != "" {
+				l.urls = append(l.urls, url)
+			}
+		}
+		l.err = s.Err()
+		linesCh <- l
+	}()
+
+	select {
+	case l := <-linesCh:
+		return l.urls, l.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
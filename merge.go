@@ -0,0 +1,107 @@
+// This file contains helpers to combine the output of several ProcessURLs calls.
+package numbers
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// MergeChannels fans in every input channel into the returned channel. The
+// returned channel is closed once every input channel has been closed. Like
+// the channel returned by ProcessURLs, it must be drained by the caller.
+func MergeChannels(chans ...<-chan []int) <-chan []int {
+	var wg sync.WaitGroup
+	out := make(chan []int)
+
+	wg.Add(len(chans))
+	for _, ch := range chans {
+		go func(ch <-chan []int) {
+			defer wg.Done()
+			for ns := range ch {
+				out <- ns
+			}
+		}(ch)
+	}
+
+	// Close the output only once every forwarding goroutine has returned, so
+	// there is never a send on a closed channel.
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// kWayMerge merges the sorted slices into a single sorted slice. It runs in
+// O(n log k) time for n numbers spread over k slices, and allocates nothing
+// but the result and a cursor per slice.
+func kWayMerge(slices [][]int) []int {
+	total := 0
+	h := make(cursorHeap, 0, len(slices))
+	for _, s := range slices {
+		total += len(s)
+		if len(s) > 0 {
+			h = append(h, cursor{s: s})
+		}
+	}
+	heap.Init(&h)
+
+	merged := make([]int, 0, total)
+	for len(h) > 0 {
+		c := &h[0]
+		merged = append(merged, c.s[c.i])
+		if c.i++; c.i == len(c.s) {
+			heap.Pop(&h)
+		} else {
+			heap.Fix(&h, 0)
+		}
+	}
+	return merged
+}
+
+// kWayMergeUnique works like kWayMerge, but keeps each number once. The
+// duplicates are dropped as they come out of the heap, so no map is needed.
+func kWayMergeUnique(slices [][]int) []int {
+	h := make(cursorHeap, 0, len(slices))
+	for _, s := range slices {
+		if len(s) > 0 {
+			h = append(h, cursor{s: s})
+		}
+	}
+	heap.Init(&h)
+
+	merged := []int{}
+	for len(h) > 0 {
+		c := &h[0]
+		if n := c.s[c.i]; len(merged) == 0 || n != merged[len(merged)-1] {
+			merged = append(merged, n)
+		}
+		if c.i++; c.i == len(c.s) {
+			heap.Pop(&h)
+		} else {
+			heap.Fix(&h, 0)
+		}
+	}
+	return merged
+}
+
+// cursor is the position reached in a slice being merged.
+type cursor struct {
+	s []int
+	i int
+}
+
+// cursorHeap is a min-heap of cursors ordered by the numbers they point at.
+type cursorHeap []cursor
+
+func (h cursorHeap) Len() int            { return len(h) }
+func (h cursorHeap) Less(i, j int) bool  { return h[i].s[h[i].i] < h[j].s[h[j].i] }
+func (h cursorHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *cursorHeap) Push(x interface{}) { *h = append(*h, x.(cursor)) }
+
+func (h *cursorHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
@@ -0,0 +1,85 @@
+// Tests for merging ProcessURLs output.
+package numbers
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestMergeChannels(t *testing.T) {
+	expNumSlc := 3
+	expNumbers := 120
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	ch := MergeChannels(
+		ProcessURLs(ctx, newConfig(500*time.Millisecond, 500*time.Millisecond), []string{"http://rand10.10"}),
+		ProcessURLs(ctx, newConfig(500*time.Millisecond, 500*time.Millisecond), []string{"http://rand10.10", "http://rand100.10"}),
+	)
+	var slcCount, numCount int
+	for ns := range ch {
+		slcCount++
+		numCount += len(ns)
+	}
+	if slcCount != expNumSlc {
+		t.Fatalf("total slice count mismatch: %s", comp(expNumSlc, slcCount))
+	}
+	if numCount != expNumbers {
+		t.Fatalf("total numbers count mismatch: %s", comp(expNumbers, numCount))
+	}
+}
+
+func TestMergeChannelsNoLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	chans := make([]<-chan []int, 10)
+	for i := range chans {
+		ch := make(chan []int)
+		go func() {
+			ch <- []int{1, 2}
+			close(ch)
+		}()
+		chans[i] = ch
+	}
+	for _ = range MergeChannels(chans...) {
+	}
+
+	if !waitForGoroutines(before) {
+		t.Fatalf("goroutines leaked: %s", comp(before, runtime.NumGoroutine()))
+	}
+}
+
+func TestKWayMerge(t *testing.T) {
+	exp := []int{-1, 0, 1, 2, 3, 4, 5, 7, 9}
+
+	got := kWayMerge([][]int{{1, 4, 7}, {}, {-1, 2, 3}, {0, 5, 9}})
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("merged numbers mismatch: %s", comp(exp, got))
+	}
+}
+
+func TestKWayMergeUnique(t *testing.T) {
+	exp := []int{-1, 0, 1, 2, 4, 7, 9}
+
+	got := kWayMergeUnique([][]int{{1, 1, 4, 7}, {}, {-1, 1, 2}, {0, 4, 9}})
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("merged numbers mismatch: %s", comp(exp, got))
+	}
+}
+
+// waitForGoroutines reports whether the number of goroutines drops back to at
+// most n within a second. Exiting goroutines are not accounted for instantly.
+func waitForGoroutines(n int) bool {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if runtime.NumGoroutine() <= n {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+// Package msgpack registers a MessagePack encoder for the responses of
+// numbers.NumbersGetter. Importing it for its side effect is enough:
+//
+//	import _ "numbers/msgpack"
+//
+// Requests sent with "Accept: application/msgpack" then receive the sorted
+// numbers as a MessagePack array of integers, each in its smallest encoding.
+// The format is simple enough that it is written by hand here, which keeps
+// the package free of third party dependencies.
+package msgpack
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"numbers"
+)
+
+// ContentType is the media type the encoder is registered for.
+const ContentType = "application/msgpack"
+
+func init() {
+	numbers.RegisterEncoder(ContentType, Encoder{})
+}
+
+// Encoder implements numbers.Encoder for MessagePack.
+type Encoder struct{}
+
+// Encode writes numbers to w as a MessagePack array.
+func (Encoder) Encode(w io.Writer, numbers []int) error {
+	bw := bufio.NewWriter(w)
+	writeArrayHeader(bw, len(numbers))
+	for _, n := range numbers {
+		writeInt(bw, int64(n))
+	}
+	return bw.Flush()
+}
+
+// writeArrayHeader writes the header of an array of n elements.
+func writeArrayHeader(w *bufio.Writer, n int) {
+	switch {
+	case n < 16:
+		w.WriteByte(0x90 | byte(n))
+	case n <= 0xffff:
+		w.WriteByte(0xdc)
+		writeUint(w, uint64(n), 2)
+	default:
+		w.WriteByte(0xdd)
+		writeUint(w, uint64(n), 4)
+	}
+}
+
+// writeInt writes n in the smallest MessagePack integer format holding it.
+func writeInt(w *bufio.Writer, n int64) {
+	switch {
+	case n >= 0 && n <= 0x7f:
+		// positive fixint
+		w.WriteByte(byte(n))
+	case n < 0 && n >= -32:
+		// negative fixint
+		w.WriteByte(byte(n))
+	case n >= -1<<7 && n < 1<<7:
+		w.WriteByte(0xd0)
+		w.WriteByte(byte(n))
+	case n >= -1<<15 && n < 1<<15:
+		w.WriteByte(0xd1)
+		writeUint(w, uint64(n), 2)
+	case n >= -1<<31 && n < 1<<31:
+		w.WriteByte(0xd2)
+		writeUint(w, uint64(n), 4)
+	default:
+		w.WriteByte(0xd3)
+		writeUint(w, uint64(n), 8)
+	}
+}
+
+// writeUint writes the size lowest bytes of n in big endian order.
+func writeUint(w *bufio.Writer, n uint64, size int) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], n)
+	w.Write(buf[8-size:])
+}
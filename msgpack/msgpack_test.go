@@ -0,0 +1,109 @@
+// Tests for the MessagePack encoder.
+package msgpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"numbers"
+	"numbers/internal/testutil"
+)
+
+func TestEncode(t *testing.T) {
+	exp := []int{-1 << 40, -70000, -200, -33, -5, 0, 5, 127, 128, 300, 70000, 1 << 40}
+
+	ng := &numbers.NumbersGetter{Config: numbers.Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		URLGetter: testutil.StaticGetter{
+			"http://a": `{"numbers":[-1099511627776,-70000,-200,-33,-5,0]}`,
+			"http://b": `{"numbers":[5,127,128,300,70000,1099511627776]}`,
+		},
+	}}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/numbers?u=http://a&u=http://b", nil)
+	r.Header.Set("Accept", ContentType)
+	ng.ServeHTTP(w, r)
+
+	if ct := w.Header().Get("Content-Type"); ct != ContentType {
+		t.Fatalf("content type mismatch: %s", testutil.Comp(ContentType, ct))
+	}
+	got, err := decode(w.Body.Bytes())
+	if err != nil {
+		t.Fatalf("invalid msgpack body %x: %v", w.Body.Bytes(), err)
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("numbers mismatch: %s", testutil.Comp(exp, got))
+	}
+}
+
+func TestEncodeLargeArray(t *testing.T) {
+	exp := make([]int, 70000)
+	for i := range exp {
+		exp[i] = i
+	}
+
+	var buf bytes.Buffer
+	if err := (Encoder{}).Encode(&buf, exp); err != nil {
+		t.Fatalf("unexpected encode error: %v", err)
+	}
+	got, err := decode(buf.Bytes())
+	if err != nil {
+		t.Fatalf("invalid msgpack body: %v", err)
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("numbers mismatch for %d numbers", len(exp))
+	}
+}
+
+// decode decodes a MessagePack array of integers, as written by Encoder.
+func decode(data []byte) ([]int, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty body")
+	}
+
+	var n int
+	switch b := data[0]; {
+	case b&0xf0 == 0x90:
+		n, data = int(b&0x0f), data[1:]
+	case b == 0xdc && len(data) >= 3:
+		n, data = int(binary.BigEndian.Uint16(data[1:])), data[3:]
+	case b == 0xdd && len(data) >= 5:
+		n, data = int(binary.BigEndian.Uint32(data[1:])), data[5:]
+	default:
+		return nil, fmt.Errorf("not an array: %x", b)
+	}
+
+	numbers := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		if len(data) == 0 {
+			return nil, errors.New("truncated array")
+		}
+		b := data[0]
+		size := map[byte]int{0xd0: 1, 0xd1: 2, 0xd2: 4, 0xd3: 8}[b]
+		if b <= 0x7f || b >= 0xe0 {
+			numbers, data = append(numbers, int(int8(b))), data[1:]
+			continue
+		}
+		if size == 0 || len(data) < 1+size {
+			return nil, fmt.Errorf("not an integer: %x", b)
+		}
+
+		var buf [8]byte
+		copy(buf[8-size:], data[1:1+size])
+		v := int64(binary.BigEndian.Uint64(buf[:]))
+		// Sign extend the value to 64 bits.
+		shift := uint(64 - 8*size)
+		numbers, data = append(numbers, int(v<<shift>>shift)), data[1+size:]
+	}
+	if len(data) != 0 {
+		return nil, errors.New("trailing data")
+	}
+	return numbers, nil
+}
@@ -4,9 +4,14 @@ package numbers
 
 import (
 	"context"
-	"errors"
+	"crypto/tls"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -14,42 +19,439 @@ import (
 // requires an explicit timeout (response timeout) for instantiation.
 type defaultGet struct {
 	client *http.Client
+
+	// headers are set on every request, perURLHeaders only on requests for
+	// their URL. The latter win on conflicts.
+	headers       http.Header
+	perURLHeaders map[string]http.Header
+
+	// headerFunc returns headers to set on the request for a URL, over all
+	// others. It may be nil.
+	headerFunc func(url string) http.Header
+
+	// maxBytes is the largest body read, unless overridden through the
+	// request context. Zero means no limit.
+	maxBytes int64
+
+	// bodyIdleTimeout fails reads of a body that receive no bytes for
+	// longer. Zero means no timeout.
+	bodyIdleTimeout time.Duration
+
+	// retry is how GETs failing with a transient error are retried.
+	retry RetryPolicy
+
+	// clock measures the retry delays, the body idle timeout and the TTL of
+	// the DNS cache.
+	clock Clock
+
+	// unixSockets makes the getter query http+unix URLs, which it rejects
+	// otherwise.
+	unixSockets bool
+
+	// dial, if set, opens the connections of the getter, and the DNS cache
+	// resolves hosts with dnsResolver for dnsCacheTTL if it is positive.
+	// They are chained by NewDefaultGet once every option is applied, so
+	// that the options can be given in any order.
+	dial        func(ctx context.Context, network, addr string) (net.Conn, error)
+	dnsCacheTTL time.Duration
+	dnsResolver Resolver
+
+	// cleanupInterval is how often idle connections are all closed. Zero
+	// disables the cleanup.
+	cleanupInterval time.Duration
+
+	// The cleanup goroutine runs while the getter is in use: it is started
+	// by the first GET and returns once an interval passes with no GET
+	// started or in flight, so that getters dropped without Close do not
+	// leak it.
+	cleanupMu      sync.Mutex
+	cleanupRunning bool
+	getsStarted    bool
+	getsInFlight   int
+
+	// stop is closed by Close to stop the idle connections cleanup.
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// GetOption configures optional behaviour of the getter returned by NewDefaultGet.
+type GetOption func(g *defaultGet, t *http.Transport)
+
+// WithDNSCache makes the getter cache the IPs resolved for each host for the
+// given TTL. Resolution is done with r, or net.DefaultResolver if r is nil.
+// The IPs are dialed with the function set by WithDialContext, if any.
+func WithDNSCache(ttl time.Duration, r Resolver) GetOption {
+	return func(g *defaultGet, t *http.Transport) {
+		g.dnsCacheTTL = ttl
+		g.dnsResolver = r
+	}
 }
 
-func NewDefaultGet(t time.Duration) *defaultGet {
-	return &defaultGet{
+// WithClock makes the getter measure its retry delays, body idle timeout, idle
+// cleanup interval and DNS cache TTL with c, so that tests can trigger them
+// without waiting. The timeout of the getter is still measured by net/http
+// with the real clock.
+func WithClock(c Clock) GetOption {
+	return func(g *defaultGet, t *http.Transport) {
+		g.clock = c
+	}
+}
+
+// WithDialContext makes the getter open its connections with dial, such as
+// to simulate slow or failing networks in tests.
+func WithDialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) GetOption {
+	return func(g *defaultGet, t *http.Transport) {
+		g.dial = dial
+	}
+}
+
+// WithHeaders sets headers on every request made by the getter. Headers in
+// perURL are set only on requests for their URL and replace global headers
+// with the same name.
+func WithHeaders(global http.Header, perURL map[string]http.Header) GetOption {
+	return func(g *defaultGet, t *http.Transport) {
+		g.headers = global
+		g.perURLHeaders = perURL
+	}
+}
+
+// WithHeaderFunc sets the headers returned by f for the URL on every request
+// made by the getter. They replace the headers set with WithHeaders, and the
+// default User-Agent, that have the same name.
+func WithHeaderFunc(f func(url string) http.Header) GetOption {
+	return func(g *defaultGet, t *http.Transport) {
+		g.headerFunc = f
+	}
+}
+
+// DefaultUserAgent, if set, is the User-Agent of the requests made by the
+// default getter, unless their headers set one. It is empty by default, so
+// that the default of net/http is sent.
+var DefaultUserAgent string
+
+// defaultMinTLSVersion is the oldest TLS version the getter accepts unless
+// configured otherwise.
+const defaultMinTLSVersion = tls.VersionTLS12
+
+// WithMinTLSVersion sets the oldest TLS version accepted when connecting to
+// the URLs, such as tls.VersionTLS13.
+func WithMinTLSVersion(v uint16) GetOption {
+	return func(g *defaultGet, t *http.Transport) {
+		t.TLSClientConfig.MinVersion = v
+	}
+}
+
+// WithIdleConnTimeout closes connections that stayed idle for longer than d.
+func WithIdleConnTimeout(d time.Duration) GetOption {
+	return func(g *defaultGet, t *http.Transport) {
+		t.IdleConnTimeout = d
+	}
+}
+
+// WithIdleCleanup closes every idle connection of the getter each interval,
+// regardless of how long they have been idle. This keeps long running servers
+// from holding on to connections to hosts they only queried once. The cleanup
+// only runs while the getter is in use, and stops one interval after its last
+// GET, or once Close is called.
+func WithIdleCleanup(interval time.Duration) GetOption {
+	return func(g *defaultGet, t *http.Transport) {
+		g.cleanupInterval = interval
+	}
+}
+
+// WithMaxResponseBytes fails responses with a body larger than n bytes with
+// ErrTooLarge.
+func WithMaxResponseBytes(n int64) GetOption {
+	return func(g *defaultGet, t *http.Transport) {
+		g.maxBytes = n
+	}
+}
+
+// WithProxyForHost routes the requests of the getter through the proxy
+// returned by proxy for the host of their URL, possibly with a port. A nil
+// URL sends them directly. It replaces the proxy settings of the environment.
+func WithProxyForHost(proxy func(host string) (*url.URL, error)) GetOption {
+	return func(g *defaultGet, t *http.Transport) {
+		t.Proxy = func(req *http.Request) (*url.URL, error) {
+			return proxy(req.URL.Host)
+		}
+	}
+}
+
+// WithoutRedirects makes the getter return redirect responses instead of
+// following them. They fail with an error matching ErrUnfollowableRedirect.
+func WithoutRedirects() GetOption {
+	return func(g *defaultGet, t *http.Transport) {
+		g.client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+}
+
+// WithUnixSockets makes the getter query http+unix URLs over the Unix socket
+// whose escaped path is their host. Without it, they fail like any URL of an
+// unsupported scheme, so that the users of a server cannot reach the local
+// sockets of its host.
+func WithUnixSockets() GetOption {
+	return func(g *defaultGet, t *http.Transport) {
+		g.unixSockets = true
+	}
+}
+
+// WithBodyIdleTimeout fails the GETs whose response body receives no bytes
+// for longer than d, with an error whose Timeout method reports true. Unlike
+// the timeout of the getter, which bounds the whole GET, it catches upstreams
+// that stall while sending the body early on.
+func WithBodyIdleTimeout(d time.Duration) GetOption {
+	return func(g *defaultGet, t *http.Transport) {
+		g.bodyIdleTimeout = d
+	}
+}
+
+// NewDefaultGet returns a getter timing out GETs after t, configured with
+// opts. It queries http and https URLs, and http+unix URLs if given
+// WithUnixSockets.
+func NewDefaultGet(t time.Duration, opts ...GetOption) *defaultGet {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{MinVersion: defaultMinTLSVersion}
+
+	g := &defaultGet{
 		client: &http.Client{
-			Timeout: t,
+			Timeout:   t,
+			Transport: transport,
 		},
+		stop:  make(chan struct{}),
+		clock: realClock{},
 	}
+	for _, opt := range opts {
+		opt(g, transport)
+	}
+	if g.dial != nil {
+		transport.DialContext = g.dial
+	}
+	if g.dnsCacheTTL > 0 {
+		cache := newDNSCache(g.dnsCacheTTL, g.dnsResolver)
+		cache.clock = g.clock
+		dial := transport.DialContext
+		if dial == nil {
+			dial = (&net.Dialer{Timeout: 30 * time.Second}).DialContext
+		}
+		transport.DialContext = cache.dialContext(dial)
+	}
+	if g.unixSockets {
+		transport.RegisterProtocol(unixScheme, newUnixTransport(transport))
+	}
+	return g
 }
 
 // Get performs the network request to GET the URL. The requests are created with
 // the input context so that they may respect global timeouts and cancellations.
 func (g *defaultGet) Get(ctx context.Context, url string) ([]byte, error) {
-	req, err := http.NewRequest("GET", url, nil)
+	resp, err := g.GetResponse(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// GetResponse works like Get but also returns the status code and headers of
+// the response. A response with a status other than 200 is returned along
+// with a *StatusError, without its body. This includes the redirects that
+// could not be followed. Transient failures are retried according to the
+// retry policy of the getter, if any.
+func (g *defaultGet) GetResponse(ctx context.Context, url string) (*Response, error) {
+	if g.cleanupInterval > 0 {
+		g.startGet()
+		defer g.endGet()
+	}
+	if g.retry.MaxAttempts <= 1 {
+		return g.getOnce(ctx, url)
+	}
+	var resp *Response
+	err := retryTransient(ctx, g.clock, g.retry, url, func() error {
+		var err error
+		resp, err = g.getOnce(ctx, url)
+		return err
+	})
+	return resp, err
+}
+
+// getOnce works like GetResponse without retrying.
+func (g *defaultGet) getOnce(ctx context.Context, url string) (*Response, error) {
+	target := url
+	if strings.HasPrefix(url, unixScheme+"://") {
+		if !g.unixSockets {
+			return nil, errUnixSocketsDisabled
+		}
+		var err error
+		if target, err = unixRequestURL(url); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest("GET", target, nil)
 	if err != nil {
 		return nil, err
 	}
 	req = req.WithContext(ctx)
+	if DefaultUserAgent != "" {
+		req.Header.Set("User-Agent", DefaultUserAgent)
+	}
+	for k, v := range g.headers {
+		req.Header[k] = v
+	}
+	for k, v := range g.perURLHeaders[url] {
+		req.Header[k] = v
+	}
+	if g.headerFunc != nil {
+		for k, v := range g.headerFunc(url) {
+			req.Header[k] = v
+		}
+	}
 
 	resp, err := g.Client().Do(req)
 	if err != nil {
 		return nil, err
 	}
+	res := &Response{StatusCode: resp.StatusCode, Header: resp.Header}
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("service unavailable")
+		resp.Body.Close()
+		return res, &StatusError{StatusCode: resp.StatusCode}
 	}
 
-	data, err := ioutil.ReadAll(resp.Body)
+	if g.bodyIdleTimeout > 0 {
+		resp.Body = newIdleTimeoutReader(resp.Body, g.bodyIdleTimeout, g.clock)
+	}
+	data, err := readBody(resp, maxResponseBytes(ctx, g.maxBytes))
 	resp.Body.Close()
 	if err != nil {
-		return nil, err
+		return res, err
+	}
+
+	res.Body = data
+	return res, nil
+}
+
+// readBody reads the body of resp, failing with ErrTooLarge if it is larger
+// than max bytes. Zero means no limit.
+func readBody(resp *http.Response, max int64) ([]byte, error) {
+	if max <= 0 {
+		return ioutil.ReadAll(resp.Body)
+	}
+	if resp.ContentLength > max {
+		return nil, ErrTooLarge
 	}
 
+	// Read one byte past the limit to tell a body of exactly max bytes from
+	// a larger one.
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, ErrTooLarge
+	}
 	return data, nil
 }
 
+// errBodyIdle is returned by the reads of an idleTimeoutReader once it timed
+// out.
+var errBodyIdle error = idleTimeoutError{}
+
+// idleTimeoutError is the type of errBodyIdle. It is a net.Error that
+// reports a timeout.
+type idleTimeoutError struct{}
+
+func (idleTimeoutError) Error() string   { return "response body idle for too long" }
+func (idleTimeoutError) Timeout() bool   { return true }
+func (idleTimeoutError) Temporary() bool { return true }
+
+// idleTimeoutReader wraps a response body, closing it if no bytes are read
+// from it for longer than its timeout, which unblocks the read in progress.
+type idleTimeoutReader struct {
+	body  io.ReadCloser
+	clock Clock
+
+	// done is closed by Close to stop the watch of the idle time.
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	lastRead time.Time
+	timedOut bool
+}
+
+// newIdleTimeoutReader returns body, wrapped to time out after being idle
+// for d as measured by clock.
+func newIdleTimeoutReader(body io.ReadCloser, d time.Duration, clock Clock) *idleTimeoutReader {
+	r := &idleTimeoutReader{body: body, clock: clock, done: make(chan struct{}), lastRead: clock.Now()}
+	go r.watch(d)
+	return r
+}
+
+// watch closes the body once it has been idle for d, unless r is closed
+// first. It waits until d after the last read it knows of, and checks again
+// when reads happened in the meantime.
+func (r *idleTimeoutReader) watch(d time.Duration) {
+	wait := d
+	for {
+		select {
+		case <-r.clock.After(wait):
+		case <-r.done:
+			return
+		}
+
+		r.mu.Lock()
+		idle := r.clock.Since(r.lastRead)
+		if idle >= d {
+			r.timedOut = true
+		}
+		r.mu.Unlock()
+		if idle >= d {
+			r.body.Close()
+			return
+		}
+		wait = d - idle
+	}
+}
+
+// Read reads from the body and restarts the idle time if bytes were read.
+func (r *idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.timedOut {
+		return n, errBodyIdle
+	}
+	if n > 0 {
+		r.lastRead = r.clock.Now()
+	}
+	return n, err
+}
+
+// Close stops the watch of the idle time and closes the body.
+func (r *idleTimeoutReader) Close() error {
+	r.closeOnce.Do(func() { close(r.done) })
+	return r.body.Close()
+}
+
+// maxBytesKey is the context key of the per-request maximum body size.
+type maxBytesKey struct{}
+
+// withMaxResponseBytes returns a copy of ctx in which the default getter
+// reads at most n bytes of every body, in place of its configured maximum.
+func withMaxResponseBytes(ctx context.Context, n int64) context.Context {
+	return context.WithValue(ctx, maxBytesKey{}, n)
+}
+
+// maxResponseBytes returns the maximum body size set in ctx, or def.
+func maxResponseBytes(ctx context.Context, def int64) int64 {
+	if n, ok := ctx.Value(maxBytesKey{}).(int64); ok {
+		return n
+	}
+	return def
+}
+
 // Client returns the http.Client associated with the type.
 func (g *defaultGet) Client() *http.Client {
 	if g.client == nil {
@@ -57,3 +459,59 @@ func (g *defaultGet) Client() *http.Client {
 	}
 	return g.client
 }
+
+// Close stops the background work of the getter and closes its idle
+// connections. The getter can still be used afterwards.
+func (g *defaultGet) Close() {
+	g.closeOnce.Do(func() {
+		close(g.stop)
+	})
+	g.Client().CloseIdleConnections()
+}
+
+// startGet records a GET of the getter, starting the idle connections cleanup
+// if it is not running.
+func (g *defaultGet) startGet() {
+	g.cleanupMu.Lock()
+	defer g.cleanupMu.Unlock()
+	g.getsStarted = true
+	g.getsInFlight++
+	if g.cleanupRunning {
+		return
+	}
+	select {
+	case <-g.stop:
+	default:
+		g.cleanupRunning = true
+		go g.cleanupIdle()
+	}
+}
+
+// endGet records the end of a GET recorded by startGet.
+func (g *defaultGet) endGet() {
+	g.cleanupMu.Lock()
+	defer g.cleanupMu.Unlock()
+	g.getsInFlight--
+}
+
+// cleanupIdle closes the idle connections of the getter each interval, until
+// an interval passes without GETs or the getter is closed.
+func (g *defaultGet) cleanupIdle() {
+	for {
+		select {
+		case <-g.clock.After(g.cleanupInterval):
+		case <-g.stop:
+			return
+		}
+		g.Client().CloseIdleConnections()
+
+		g.cleanupMu.Lock()
+		if !g.getsStarted && g.getsInFlight == 0 {
+			g.cleanupRunning = false
+			g.cleanupMu.Unlock()
+			return
+		}
+		g.getsStarted = false
+		g.cleanupMu.Unlock()
+	}
+}
@@ -0,0 +1,590 @@
+// Tests for the default URLGetter implementation.
+package numbers
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDefaultGetDNSCache(t *testing.T) {
+	expLookups := 1
+
+	ts := httptest.NewServer(numbersHandler(`{"numbers":[1,2,3]}`))
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	r := &countingResolver{}
+	g := NewDefaultGet(time.Second, WithDNSCache(time.Minute, r))
+	// Disable keep-alives so that every Get dials a new connection.
+	g.Client().Transport.(*http.Transport).DisableKeepAlives = true
+
+	for i := 0; i < 3; i++ {
+		if _, err := g.Get(context.Background(), "http://numbers.test:"+port+"/"); err != nil {
+			t.Fatalf("unexpected get error: %v", err)
+		}
+	}
+	if r.count() != expLookups {
+		t.Fatalf("DNS lookup count mismatch: %s", comp(expLookups, r.count()))
+	}
+}
+
+func TestDefaultGetDNSCacheOptionsOrder(t *testing.T) {
+	ts := httptest.NewServer(numbersHandler(`{"numbers":[1,2,3]}`))
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	var dials atomic.Int64
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dials.Add(1)
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+	fc := newFakeClock()
+	r := &countingResolver{}
+	// The DNS cache is given before the dial function and the clock it uses.
+	g := NewDefaultGet(time.Second, WithDNSCache(time.Minute, r), WithDialContext(dial), WithClock(fc))
+	g.Client().Transport.(*http.Transport).DisableKeepAlives = true
+
+	for i, exp := range []struct{ lookups, dials int }{{1, 1}, {1, 2}, {2, 3}} {
+		if i == 2 {
+			fc.Advance(time.Minute)
+		}
+		if _, err := g.Get(context.Background(), "http://numbers.test:"+port+"/"); err != nil {
+			t.Fatalf("unexpected get error: %v", err)
+		}
+		if got := (struct{ lookups, dials int }{r.count(), int(dials.Load())}); got != exp {
+			t.Fatalf("lookups and dials mismatch after GET %d: %s", i, comp(exp, got))
+		}
+	}
+}
+
+func TestDNSCacheExpiry(t *testing.T) {
+	fc := newFakeClock()
+	c := newDNSCache(time.Minute, &countingResolver{})
+	c.clock = fc
+
+	for _, host := range []string{"a.test", "b.test"} {
+		if _, err := c.lookup(context.Background(), host); err != nil {
+			t.Fatalf("unexpected lookup error: %v", err)
+		}
+	}
+
+	// Once expired, the hosts never looked up again are removed by the next
+	// lookup stored.
+	fc.Advance(time.Minute)
+	if _, err := c.lookup(context.Background(), "c.test"); err != nil {
+		t.Fatalf("unexpected lookup error: %v", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries["c.test"]; !ok || len(c.entries) != 1 {
+		t.Fatalf("entries mismatch after expiry: %s", comp(1, len(c.entries)))
+	}
+}
+
+func TestDefaultGetPerURLHeaders(t *testing.T) {
+	expNumbers := 6
+
+	// Each path only responds if the request carries its token, and every
+	// request must carry the global User-Agent.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens := map[string]string{"/a": "token-a", "/b": "token-b"}
+		if r.Header.Get("X-Token") != tokens[r.URL.Path] || r.UserAgent() != "numbers-test" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		numbersHandler(`{"numbers":[1,2,3]}`)(w, r)
+	}))
+	defer ts.Close()
+
+	cfg := &Config{
+		Headers: http.Header{"User-Agent": {"numbers-test"}, "X-Token": {"global"}},
+		PerURLHeaders: map[string]http.Header{
+			ts.URL + "/a": {"X-Token": {"token-a"}},
+			ts.URL + "/b": {"X-Token": {"token-b"}},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var numCount int
+	for ns := range ProcessURLs(ctx, cfg, []string{ts.URL + "/a", ts.URL + "/b"}) {
+		numCount += len(ns)
+	}
+	if numCount != expNumbers {
+		t.Fatalf("total numbers count mismatch: %s", comp(expNumbers, numCount))
+	}
+}
+
+func TestDefaultGetHeaderFunc(t *testing.T) {
+	var mu sync.Mutex
+	got := map[string]http.Header{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		got[r.URL.Path] = r.Header
+		mu.Unlock()
+		numbersHandler(`{"numbers":[1]}`)(w, r)
+	}))
+	defer ts.Close()
+
+	g := NewDefaultGet(time.Second,
+		WithHeaders(http.Header{"Authorization": {"Bearer global"}}, nil),
+		WithHeaderFunc(func(url string) http.Header {
+			if url != ts.URL+"/custom" {
+				return nil
+			}
+			return http.Header{"User-Agent": {"custom-agent"}, "Accept": {"application/json"}}
+		}))
+	for _, path := range []string{"/plain", "/custom"} {
+		if _, err := g.Get(context.Background(), ts.URL+path); err != nil {
+			t.Fatalf("unexpected error for %s: %v", path, err)
+		}
+	}
+
+	for path, exp := range map[string]map[string]string{
+		"/plain":  {"User-Agent": "Go-http-client/1.1", "Authorization": "Bearer global", "Accept": ""},
+		"/custom": {"User-Agent": "custom-agent", "Authorization": "Bearer global", "Accept": "application/json"},
+	} {
+		for name, v := range exp {
+			if h := got[path].Get(name); h != v {
+				t.Fatalf("%s header mismatch for %s: %s", name, path, comp(v, h))
+			}
+		}
+	}
+}
+
+func TestDefaultGetDefaultUserAgent(t *testing.T) {
+	exp := "numbers"
+
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.UserAgent()
+		numbersHandler(`{"numbers":[1]}`)(w, r)
+	}))
+	defer ts.Close()
+
+	defer func(ua string) { DefaultUserAgent = ua }(DefaultUserAgent)
+	DefaultUserAgent = exp
+	if _, err := NewDefaultGet(time.Second).Get(context.Background(), ts.URL); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != exp {
+		t.Fatalf("User-Agent mismatch: %s", comp(exp, got))
+	}
+}
+
+func TestDefaultGetMinTLSVersion(t *testing.T) {
+	g := NewDefaultGet(time.Second)
+	if got := g.Client().Transport.(*http.Transport).TLSClientConfig.MinVersion; got != tls.VersionTLS12 {
+		t.Fatalf("default minimum TLS version mismatch: %s", comp(tls.VersionTLS12, got))
+	}
+
+	ts := httptest.NewUnstartedServer(numbersHandler(`{"numbers":[1,2,3]}`))
+	ts.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	ts.StartTLS()
+	defer ts.Close()
+
+	// A server capped at TLS 1.2 is accepted by default...
+	trustServer(g, ts)
+	if _, err := g.Get(context.Background(), ts.URL); err != nil {
+		t.Fatalf("unexpected get error: %v", err)
+	}
+
+	// ...but refused once TLS 1.3 is required, whether through the option
+	// or the Config.
+	g = NewDefaultGet(time.Second, WithMinTLSVersion(tls.VersionTLS13))
+	trustServer(g, ts)
+	if _, err := g.Get(context.Background(), ts.URL); err == nil {
+		t.Fatalf("TLS 1.2 connection not refused: %s", comp("error", nil))
+	}
+	cfg := &Config{MinTLSVersion: tls.VersionTLS13}
+	cfg.setDefaults()
+	g = cfg.URLGetter.(*defaultGet)
+	trustServer(g, ts)
+	if _, err := g.Get(context.Background(), ts.URL); err == nil {
+		t.Fatalf("TLS 1.2 connection not refused with Config.MinTLSVersion: %s", comp("error", nil))
+	}
+}
+
+func TestDefaultGetIdleConnTimeout(t *testing.T) {
+	ts, conns := newConnCountingServer()
+	defer ts.Close()
+
+	g := NewDefaultGet(time.Second, WithIdleConnTimeout(50*time.Millisecond))
+	defer g.Close()
+	if _, err := g.Get(context.Background(), ts.URL); err != nil {
+		t.Fatalf("unexpected get error: %v", err)
+	}
+	if !conns.waitForOpen(0, time.Second) {
+		t.Fatalf("idle connection not closed: %s", comp(0, conns.count()))
+	}
+}
+
+func TestDefaultGetIdleCleanup(t *testing.T) {
+	ts, conns := newConnCountingServer()
+	defer ts.Close()
+
+	fc := newFakeClock()
+	g := NewDefaultGet(time.Second, WithIdleCleanup(time.Minute), WithClock(fc))
+	defer g.Close()
+	if _, err := g.Get(context.Background(), ts.URL); err != nil {
+		t.Fatalf("unexpected get error: %v", err)
+	}
+	if conns.count() != 1 {
+		t.Fatalf("open connection count mismatch: %s", comp(1, conns.count()))
+	}
+	fc.waitForTimers(1)
+	fc.Advance(time.Minute)
+	if !conns.waitForOpen(0, time.Second) {
+		t.Fatalf("idle connection not cleaned up: %s", comp(0, conns.count()))
+	}
+}
+
+func TestDefaultGetIdleCleanupStops(t *testing.T) {
+	ts, _ := newConnCountingServer()
+	defer ts.Close()
+	before := runtime.NumGoroutine()
+
+	// Every Config sets up a getter of its own, which is dropped without
+	// being closed.
+	for i := 0; i < 10; i++ {
+		cfg := &Config{IdleCleanupInterval: 10 * time.Millisecond, GetTimeout: time.Second}
+		if _, err := MergeNumbers(context.Background(), cfg, []string{ts.URL}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if !waitForGoroutines(before) {
+		t.Fatalf("cleanup goroutines leaked: %s", comp(before, runtime.NumGoroutine()))
+	}
+}
+
+func TestDefaultGetHTTP10(t *testing.T) {
+	exp := []int{4, 5, 6}
+	expConns := 2
+
+	addr, conns := serveHTTP10(t, `{"numbers":[4,5,6]}`)
+
+	// The server closes the connection after each response, so each request
+	// must be sent on a new one.
+	cfg := &Config{}
+	g := NewDefaultGet(time.Second)
+	for i := 0; i < expConns; i++ {
+		data, err := g.Get(context.Background(), "http://"+addr+"/numbers")
+		if err != nil {
+			t.Fatalf("unexpected get error: %v", err)
+		}
+		got, err := decodeNumbers(data, cfg)
+		if err != nil {
+			t.Fatalf("unexpected decode error: %v", err)
+		}
+		if !reflect.DeepEqual(exp, got) {
+			t.Fatalf("numbers mismatch: %s", comp(exp, got))
+		}
+	}
+	if n := conns(); n != expConns {
+		t.Fatalf("connection count mismatch: %s", comp(expConns, n))
+	}
+}
+
+func TestDefaultGetChunkedMaxBytes(t *testing.T) {
+	exp := []int{1, 2, 3, 4, 5}
+	body := `{"numbers":[1,2,3,4,5]}`
+
+	// Flushing after every chunk makes the server send the body chunked,
+	// without a Content-Length.
+	var gotLength []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < len(body); i += 4 {
+			w.Write([]byte(body[i:min(i+4, len(body))]))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		max int64
+		err error
+	}{
+		{int64(len(body)), nil},
+		{int64(len(body)) - 1, ErrTooLarge},
+	} {
+		g := NewDefaultGet(time.Second, WithMaxResponseBytes(tc.max))
+		resp, err := g.GetResponse(context.Background(), ts.URL)
+		if err != tc.err {
+			t.Fatalf("error mismatch for a cap of %d bytes: %s", tc.max, comp(tc.err, err))
+		}
+		if err != nil {
+			continue
+		}
+		gotLength = resp.Header["Content-Length"]
+		got, err := decodeNumbers(resp.Body, &Config{})
+		if err != nil {
+			t.Fatalf("unexpected decode error: %v", err)
+		}
+		if !reflect.DeepEqual(exp, got) {
+			t.Fatalf("numbers mismatch: %s", comp(exp, got))
+		}
+	}
+	if gotLength != nil {
+		t.Fatalf("response not chunked: %s", comp(nil, gotLength))
+	}
+}
+
+func TestDefaultGetProxyForHost(t *testing.T) {
+	// Each proxy responds with its own number, and records the hosts it was
+	// asked for.
+	var mu sync.Mutex
+	hosts := map[string][]string{}
+	proxy := func(name, body string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			hosts[name] = append(hosts[name], r.URL.Host)
+			mu.Unlock()
+			w.Write([]byte(body))
+		}))
+	}
+	pa, pb := proxy("a", `{"numbers":[1]}`), proxy("b", `{"numbers":[2]}`)
+	defer pa.Close()
+	defer pb.Close()
+
+	proxies := map[string]string{"a.test": pa.URL, "b.test:8080": pb.URL}
+	g := NewDefaultGet(time.Second, WithProxyForHost(func(host string) (*url.URL, error) {
+		return url.Parse(proxies[host])
+	}))
+	for target, exp := range map[string]string{"http://a.test/n": `{"numbers":[1]}`, "http://b.test:8080/n": `{"numbers":[2]}`} {
+		body, err := g.Get(context.Background(), target)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", target, err)
+		}
+		if string(body) != exp {
+			t.Fatalf("body mismatch for %s: %s", target, comp(exp, string(body)))
+		}
+	}
+
+	exp := map[string][]string{"a": {"a.test"}, "b": {"b.test:8080"}}
+	if !reflect.DeepEqual(exp, hosts) {
+		t.Fatalf("proxied hosts mismatch: %s", comp(exp, hosts))
+	}
+}
+
+func TestDefaultGetDialContext(t *testing.T) {
+	expReason := reasonTimeout
+
+	// The dialer hangs until the GET gives up on it.
+	var dials atomic.Int32
+	cfg := &Config{
+		GetTimeout: 50 * time.Millisecond,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dials.Add(1)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	cfg.setDefaults()
+
+	start := time.Now()
+	_, err := cfg.URLGetter.Get(context.Background(), "http://slow.test/numbers")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("dial not timed out: %s", comp("< 1s", elapsed))
+	}
+	if got := classify(err); got != expReason {
+		t.Fatalf("reason mismatch for %v: %s", err, comp(expReason, got))
+	}
+	if dials.Load() == 0 {
+		t.Fatalf("custom dialer not used")
+	}
+}
+
+func TestDefaultGetUnfollowableRedirect(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/moved" {
+			http.Redirect(w, r, "/numbers", http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusMovedPermanently)
+	}))
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		path string
+		opts []GetOption
+	}{
+		{"/nolocation", nil},
+		{"/moved", []GetOption{WithoutRedirects()}},
+	} {
+		_, err := NewDefaultGet(time.Second, tc.opts...).GetResponse(context.Background(), ts.URL+tc.path)
+		if !errors.Is(err, ErrUnfollowableRedirect) {
+			t.Fatalf("error mismatch for %s: %s", tc.path, comp(ErrUnfollowableRedirect, err))
+		}
+		if got := classify(err); got != reasonRedirect {
+			t.Fatalf("reason mismatch for %s: %s", tc.path, comp(reasonRedirect, got))
+		}
+	}
+
+	if err := (&StatusError{StatusCode: http.StatusNotModified}); errors.Is(err, ErrUnfollowableRedirect) {
+		t.Fatalf("304 treated as a redirect: %s", comp(ErrBadStatus, err))
+	}
+}
+
+func TestDefaultGetBodyIdleTimeout(t *testing.T) {
+	// The server sends half of the body, then stalls for longer than the
+	// idle timeout but well within the timeout of the getter.
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"numbers":[1,`)
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		io.WriteString(w, `2,3]}`)
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	g := NewDefaultGet(5*time.Second, WithBodyIdleTimeout(100*time.Millisecond))
+	start := time.Now()
+	_, err := g.Get(context.Background(), ts.URL)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("stalled body not cut short: %s", comp("< 1s", elapsed))
+	}
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("idle timeout not reported: %s", comp("timeout error", err))
+	}
+	if reason := classify(err); reason != reasonTimeout {
+		t.Fatalf("idle timeout reason mismatch: %s", comp(reasonTimeout, reason))
+	}
+}
+
+// serveHTTP10 starts a server answering every request with body as an
+// HTTP/1.0 response delimited by the closing of the connection. It returns the
+// address of the server and a function counting the connections accepted.
+func serveHTTP10(t *testing.T, body string) (string, func() int) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected listen error: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	var mu sync.Mutex
+	accepted := 0
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			accepted++
+			mu.Unlock()
+
+			go func() {
+				defer conn.Close()
+				if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+					return
+				}
+				io.WriteString(conn, "HTTP/1.0 200 OK\r\nContent-Type: application/json\r\n\r\n"+body)
+			}()
+		}
+	}()
+
+	return l.Addr().String(), func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return accepted
+	}
+}
+
+// connCounter counts the connections open on a test server.
+type connCounter struct {
+	mu   sync.Mutex
+	open int
+}
+
+// newConnCountingServer starts a test server serving numbers and returns it
+// with the counter of its open connections.
+func newConnCountingServer() (*httptest.Server, *connCounter) {
+	c := &connCounter{}
+	ts := httptest.NewUnstartedServer(numbersHandler(`{"numbers":[1,2,3]}`))
+	ts.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		switch state {
+		case http.StateNew:
+			c.open++
+		case http.StateClosed, http.StateHijacked:
+			c.open--
+		}
+	}
+	ts.Start()
+	return ts, c
+}
+
+func (c *connCounter) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.open
+}
+
+// waitForOpen reports whether the count of open connections drops to n
+// within d.
+func (c *connCounter) waitForOpen(n int, d time.Duration) bool {
+	deadline := time.Now().Add(d)
+	for time.Now().Before(deadline) {
+		if c.count() == n {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+// trustServer makes g trust the certificate of the TLS test server ts.
+func trustServer(g *defaultGet, ts *httptest.Server) {
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+	g.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs = pool
+}
+
+// numbersHandler responds to every request with body.
+func numbersHandler(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}
+}
+
+// countingResolver resolves every host to the loopback address and counts
+// the lookups made.
+type countingResolver struct {
+	mu      sync.Mutex
+	lookups int
+}
+
+func (r *countingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r.mu.Lock()
+	r.lookups++
+	r.mu.Unlock()
+	return []net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}}, nil
+}
+
+func (r *countingResolver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lookups
+}
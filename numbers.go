@@ -6,9 +6,14 @@ package numbers
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
+	"sort"
 	"sync"
 	"time"
 )
@@ -29,6 +34,64 @@ type URLGetter interface {
 	Client() *http.Client
 }
 
+// Response holds the details of a response to a GET request.
+type Response struct {
+	Body       []byte
+	StatusCode int
+	Header     http.Header
+}
+
+// ResponseGetter can be implemented by a URLGetter to report the details of
+// the responses it receives, such as their status code, along with their body.
+// It is used in place of Get when available.
+type ResponseGetter interface {
+	// GetResponse performs the HTTP GET request for the given URL. If a
+	// response was received but is not usable, it is returned along with
+	// the error.
+	GetResponse(ctx context.Context, url string) (*Response, error)
+}
+
+// urlResult is the outcome of querying a single input URL.
+type urlResult struct {
+	url     string
+	numbers []int
+
+	// floats are the numbers of the URL with FloatNumbers, in place of
+	// numbers.
+	floats []float64
+
+	// status is the HTTP status code of the response, or zero if no
+	// response was received or the getter does not report it.
+	status int
+	err    error
+
+	// latency is how long the URL took to query, retries included. It is
+	// zero for the URLs that were never queried.
+	latency time.Duration
+
+	// index is the position of the URL in the list of a POST body, whose
+	// URLs are queried once per position.
+	index int
+
+	// sampled is the number of numbers of the URL dropped once added to the
+	// sample of the request, which then keeps the sample only.
+	sampled int
+}
+
+// count returns the number of numbers in r, of either kind.
+func (r *urlResult) count() int {
+	return len(r.numbers) + len(r.floats) + r.sampled
+}
+
+// truncate keeps the first n numbers of r.
+func (r *urlResult) truncate(n int) {
+	if r.floats != nil {
+		r.floats = r.floats[:n]
+		return
+	}
+	r.numbers = r.numbers[:n]
+}
+
 // Config contains various parameters required to setup various package
 // functionalities and options.
 type Config struct {
@@ -37,41 +100,752 @@ type Config struct {
 	// queries in flight are cancelled and remaining URLs are ignored.
 	ResponseTimeout time.Duration
 
+	// FlushGrace is the time NumbersGetter allows, after ResponseTimeout, for
+	// collecting and sorting the numbers. A response still not ready after
+	// ResponseTimeout + FlushGrace and a small margin is abandoned by a
+	// watchdog, which is a safety net against getters ignoring their context.
+	FlushGrace time.Duration
+
 	// GetTimeout is the individual timeout for each URL required to be queried.
 	GetTimeout time.Duration
 
+	// MaxRetries is the number of times a URL whose GET failed is queried
+	// again. Zero disables retries. It is ignored when GetRetry is set.
+	MaxRetries int
+
+	// RetryBackoff is the time waited for before a retry, jittered down to
+	// half of it. Retries that could not complete before the deadline of the
+	// request are skipped.
+	RetryBackoff time.Duration
+
+	// PerURLBudget bounds the total time spent on a URL across its attempts,
+	// while GetTimeout bounds each attempt. Retries that could not complete
+	// within it are skipped. It never extends past ResponseTimeout. Zero
+	// leaves the URL bound by ResponseTimeout alone.
+	PerURLBudget time.Duration
+
+	// RetryOnEmpty also retries the URLs whose response holds no numbers, up
+	// to MaxRetries times. The last response is kept if they all are empty.
+	RetryOnEmpty bool
+
 	// Te maximum number of goroutines the server process should start up.
 	NumGoRoutines int
 
+	// MaxGoRoutinesCeiling caps NumGoRoutines, whatever it is set or tuned
+	// to. Zero means a ceiling of 10000.
+	MaxGoRoutinesCeiling int
+
+	// MaxRequestBytes fails the POST requests to NumbersGetter whose body is
+	// larger than the given number of bytes. The URLs of the body are queried
+	// as they are read, so some may be queried before the request fails.
+	// Zero means no limit.
+	MaxRequestBytes int64
+
+	// MaxHosts rejects the requests to NumbersGetter whose URLs span more
+	// distinct hosts than the given number, before any is queried. Ports are
+	// ignored. Zero means no limit.
+	MaxHosts int
+
+	// MaxURLs rejects the requests to NumbersGetter with more URLs than the
+	// given number, range directives included. Like MaxHosts, it applies to
+	// the URLs of POST bodies as they are read, so some may be queried before
+	// the request fails. Zero means no limit.
+	MaxURLs int
+
+	// ResultBuffer is the number of URL results held for a slow consumer of
+	// ProcessURLs before OverflowPolicy applies. Zero hands every result over
+	// to the consumer directly, blocking the goroutine that queried its URL.
+	ResultBuffer int
+
+	// OverflowPolicy is what happens to URL results that arrive while the
+	// ResultBuffer is full. The zero value waits for the consumer.
+	OverflowPolicy OverflowPolicy
+
+	// AbandonOnCancel drops the URL results not taken by the consumer of
+	// ProcessURLs once its context is done, so that a consumer that stops
+	// reading early, such as one that panicked, only has to cancel the
+	// context for the goroutines querying the URLs to return. The channel is
+	// still closed once they do. By default every result waits for the
+	// consumer, including those of the URLs skipped after the cancellation.
+	AbandonOnCancel bool
+
+	// Strategy is how URLs are scheduled on goroutines. The zero value is
+	// FixedPool.
+	Strategy Strategy
+
+	// NormalizeURLs sorts and de-duplicates the input URLs before they are
+	// dispatched. This makes the dispatch order deterministic, which keeps
+	// logs comparable and helps response caching, but it also means URLs are
+	// no longer queried in the order they were given. Duplicates are then
+	// handled as with FetchOnce, unless DuplicateURLPolicy is
+	// FetchOnceEmitEach.
+	NormalizeURLs bool
+
+	// DuplicateURLPolicy controls how URLs given more than once are queried.
+	// The zero value queries them as many times as they are given.
+	DuplicateURLPolicy DuplicateURLPolicy
+
+	// URLRewrite, if set, is applied to every input URL before it is queried,
+	// for instance to map logical source names to internal URLs. A URL for
+	// which it returns an error is not queried and is marked as failed.
+	URLRewrite func(url string) (string, error)
+
+	// ShouldInclude, if set, is called with every URL response before it is
+	// decoded, and the numbers of the responses it returns false for are left
+	// out of the result, for instance those sent with X-Numbers-Skip: true.
+	// Such responses count as successful and empty.
+	ShouldInclude func(url string, header http.Header) bool
+
+	// FloatMode controls how non-integral values in URL responses are treated.
+	// The zero value rejects responses that contain anything but integers.
+	FloatMode FloatMode
+
+	// NumberKind is the type of the numbers NumbersGetter collects. With
+	// FloatNumbers, the responses are JSON lists of any number, and only the
+	// options that do not depend on integers apply. See NumberKind.
+	// ProcessURLs, ProcessURLsTagged and MergeNumbers only report integers,
+	// and query nothing with FloatNumbers.
+	NumberKind NumberKind
+
+	// StrictDecode fails the responses whose numbers array has an element
+	// that is not an integer under FloatMode with an *ElementError, telling
+	// the index of the first one. Otherwise these responses fail with the
+	// error of the element alone.
+	StrictDecode bool
+
+	// LineDecoding decodes text/plain responses as one integer per line,
+	// and responses that fail to decode as JSON the same way. Blank lines are
+	// ignored, and malformed lines are skipped with a logged warning. By
+	// default responses are decoded as JSON only.
+	LineDecoding bool
+
+	// MaxJSONDepth fails responses whose JSON nesting is deeper than the
+	// given number of arrays and objects with ErrTooDeep, before they are
+	// decoded. A regular response has a depth of 2. Zero means no limit.
+	MaxJSONDepth int
+
+	// RequireNumbersField fails responses whose JSON object has no numbers
+	// field, or a null one. By default they are taken as empty, like those
+	// with an empty array.
+	RequireNumbersField bool
+
+	// DisableDecoderRecovery lets panics of registered Decoders crash the
+	// process. By default a panicking Decoder fails the URL it was decoding
+	// with ErrDecode, and the other URLs are unaffected. It is the inverse of
+	// a RecoverDecoders option defaulting to true, for the zero Config to
+	// recover.
+	DisableDecoderRecovery bool
+
+	// MaxConcurrentDecodes is the largest number of responses decoded at
+	// once, across the requests sharing the Config, to bound the CPU used
+	// by bursts of large responses. Zero means no limit.
+	MaxConcurrentDecodes int
+
+	// MaxDecodeTime fails the URLs whose response takes longer than the
+	// given duration to decode, once read, with ErrDecode. Zero means no
+	// limit.
+	MaxDecodeTime time.Duration
+
+	// RejectTrailingData fails responses that carry more content after their
+	// JSON object. By default such content is logged and ignored.
+	RejectTrailingData bool
+
+	// MaxValue bounds the numbers the URLs return to [0, MaxValue], which lets
+	// ?median=1 find the median of large results without sorting them, using
+	// MaxValue/8 bytes. The median is found by sorting if it is zero or if a
+	// number is out of bounds.
+	MaxValue int
+
+	// AssumeDisjoint tells NumbersGetter that no number is returned by more
+	// than one URL, nor more than once by the same URL. The de-duplication
+	// map is then skipped and the sorted responses are merged instead, which
+	// is cheaper. Duplicates are kept if the assumption does not hold.
+	AssumeDisjoint bool
+
+	// AssumeSorted tells NumbersGetter that every URL responds with its
+	// numbers in ascending order. The responses are then merged with a heap
+	// and de-duplicated on the fly, without the map and sort of the whole
+	// result. Responses that are not sorted are sorted first, which costs a
+	// check of every response otherwise.
+	AssumeSorted bool
+
+	// KeepDuplicates makes NumbersGetter and MergeNumbers return every
+	// number the URLs responded with, sorted, as many times as they were
+	// received. The de-duplication map is skipped, and AssumeDisjoint has no
+	// effect. It does not apply to the requests to NumbersGetter with
+	// ?sort=freq or ?stream, which return every number once, unless they
+	// set ?dedupe=false. It is the inverse of a Dedup option defaulting to
+	// true, for the zero Config to de-duplicate.
+	KeepDuplicates bool
+
+	// CoalesceRequests makes NumbersGetter share the work of concurrent
+	// requests for the same set of URLs. Only the first of these requests
+	// queries the URLs, with a context that is detached from the request but
+	// bounded by ResponseTimeout, and every request receives its result.
+	CoalesceRequests bool
+
+	// CacheKeyFunc, if set, returns the key identifying a set of URLs, so
+	// that with CoalesceRequests the requests for URL sets it maps to the
+	// same key share their work. It may for instance ignore the order of the
+	// query parameters of each URL. The default key is a SHA-256 hash of the
+	// sorted URLs, ignoring duplicates unless KeepDuplicates is set. The
+	// requests whose responses name their URLs, such as with ?debug or
+	// ?op=symdiff, only share work with those giving the same URLs.
+	CacheKeyFunc func(urls []string) string
+
+	// ContextEnricher, if set, is called by NumbersGetter with every request
+	// and the context the URLs are to be queried with. The context it returns
+	// is used instead, so that request scoped values such as a tenant ID can
+	// reach the URLGetter. As the values may change the responses of the
+	// URLs, requests are only coalesced, and responses only cached, among
+	// the requests RequestKey maps to the same key. Without RequestKey,
+	// ContextEnricher disables both. With CoalesceRequests, the request that
+	// starts the shared work is the one passed.
+	ContextEnricher func(r *http.Request, ctx context.Context) context.Context
+
+	// RequestKey, if set, returns the key of the values ContextEnricher adds
+	// for a request, such as its tenant ID. It must map the requests whose
+	// URLs may respond differently to different keys.
+	RequestKey func(r *http.Request) string
+
+	// Order is the direction NumbersGetter sorts the numbers of its
+	// responses in, for the requests without ?order=asc or ?order=desc. It
+	// does not apply to those with ?sort, ?bucketBy or ?stream, which are
+	// ascending. The zero value is ascending.
+	Order Order
+
+	// EmptyStatus is the HTTP status NumbersGetter responds with when the
+	// merged result is empty, for instance because every URL failed. Zero
+	// means http.StatusOK. No body is written for http.StatusNoContent.
+	EmptyStatus int
+
+	// PerRequestMemoryCeiling bounds the memory NumbersGetter holds the
+	// numbers of a request in, estimated at 8 bytes per number received.
+	// Once reached, further numbers are dropped and the partial result is
+	// returned with an X-Memory-Capped: true header. Zero means no limit.
+	PerRequestMemoryCeiling int64
+
+	// StreamChunkSize is the number of numbers NumbersGetter writes between
+	// flushes of the responses streamed as NDJSON, to requests accepting
+	// application/x-ndjson. Larger chunks trade latency for throughput. Zero
+	// flushes after every number.
+	StreamChunkSize int
+
+	// StatsHook is called by NumbersGetter with the stats of every request
+	// once its URLs are collected, such as to export them as metrics. It is
+	// called from the goroutine serving the request.
+	StatsHook func(RequestStats)
+
+	// InlineTimeoutWarning adds a warning to the JSON responses of
+	// NumbersGetter that miss the numbers of URLs that timed out, for clients
+	// that cannot read headers. The status code is left as it is.
+	InlineTimeoutWarning bool
+
+	// Logger receives the input URLs of every request of NumbersGetter, at
+	// the debug level. Nil means slog.Default(), which drops debug records
+	// unless configured otherwise.
+	Logger *slog.Logger
+
+	// RedactURLs strips the query strings from the URLs logged by
+	// NumbersGetter, as they may carry credentials.
+	RedactURLs bool
+
+	// Clock measures the timeouts, deadlines and retry backoffs of the
+	// package, so that tests can trigger them without waiting. Nil means the
+	// real clock. It also paces the rate limits and expires the CacheTTL
+	// and DNSCacheTTL of the getters set up from the Config, and times the
+	// BodyIdleTimeout and IdleCleanupInterval of the default getter.
+	// GetTimeout is still measured by net/http with the real clock.
+	Clock Clock
+
+	// PerHostRPS limits the requests per second made to the hosts it lists,
+	// by host name, regardless of case. Hosts it does not list are limited to
+	// DefaultHostRPS. Requests wait for their turn, or until their context is
+	// done. See RateLimitedGetter.
+	PerHostRPS map[string]float64
+
+	// DefaultHostRPS limits the requests per second made to each host not
+	// listed in PerHostRPS. Zero means no limit.
+	DefaultHostRPS float64
+
+	// BreakerThreshold opens the circuit breaker of a host after the given
+	// number of consecutive failed GETs to it. While open, the URLs of the
+	// host fail at once with ErrCircuitOpen. Zero disables the breakers.
+	BreakerThreshold int
+
+	// BreakerCooldown is how long a breaker stays open before the host is
+	// queried again. Zero means 30 seconds.
+	BreakerCooldown time.Duration
+
+	// CacheTTL enables caching of the URL responses for the given duration,
+	// by wrapping URLGetter in a CachingGetter keeping them in memory. Zero
+	// disables the cache. See Config.ClearCache.
+	CacheTTL time.Duration
+
+	// DNSCacheTTL enables caching of resolved host IPs in the default getter
+	// for the given duration. Zero disables the cache.
+	DNSCacheTTL time.Duration
+
+	// MaxResponseBytes fails URL responses with a body larger than the given
+	// number of bytes with ErrTooLarge, in the default getter. Zero means no
+	// limit. It can be overridden per request with ?maxBytes.
+	MaxResponseBytes int64
+
+	// MaxResponseBytesCeiling is the largest value ?maxBytes can raise
+	// MaxResponseBytes to; larger values are clamped. Zero means
+	// MaxResponseBytes is the ceiling, or that there is none if it is unset.
+	MaxResponseBytesCeiling int64
+
+	// BodyIdleTimeout fails the GETs of the default getter whose response
+	// body receives no bytes for longer than the given duration, even if
+	// GetTimeout is not reached yet. Zero disables it.
+	BodyIdleTimeout time.Duration
+
+	// GetRetry is how the URLs whose GET failed with a transient error, such
+	// as a reset connection or a 503, are queried again. The zero value does
+	// not retry. Unlike MaxRetries, it leaves other failures alone, and it
+	// takes over from MaxRetries and RetryBackoff when set, so that no URL
+	// is retried by both. See RetryPolicy.
+	GetRetry RetryPolicy
+
+	// DialContext, if set, opens the connections of the default getter in
+	// place of a net.Dialer, such as to inject latency or resets in tests.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// ProxyForHost returns the proxy the default getter sends the requests
+	// for a host, possibly with a port, through. A nil URL sends them
+	// directly. If nil, the proxy settings of the environment are used.
+	ProxyForHost func(host string) (*url.URL, error)
+
+	// DisableRedirects makes the default getter fail redirect responses
+	// with ErrUnfollowableRedirect instead of following them.
+	DisableRedirects bool
+
+	// UnixSockets makes the default getter query http+unix URLs over the
+	// Unix socket whose escaped path is their host. It is off by default, as
+	// it lets the URLs of a request reach the local sockets of the server.
+	UnixSockets bool
+
+	// MinTLSVersion is the oldest TLS version the default getter accepts, such
+	// as tls.VersionTLS13. Zero means TLS 1.2.
+	MinTLSVersion uint16
+
+	// IdleConnTimeout closes connections of the default getter that stayed
+	// idle for longer than the given duration. Zero uses the net/http default.
+	IdleConnTimeout time.Duration
+
+	// IdleCleanupInterval makes the default getter close all its idle
+	// connections at the given interval, for as long as it makes requests.
+	// Zero disables the cleanup.
+	IdleCleanupInterval time.Duration
+
+	// Headers are sent with every request made by the default getter.
+	Headers http.Header
+
+	// PerURLHeaders are sent with the requests made by the default getter for
+	// their URL, in addition to Headers. They replace global headers with
+	// the same name.
+	PerURLHeaders map[string]http.Header
+
+	// HeaderFunc returns headers the default getter sends with the request
+	// for a URL, over Headers and PerURLHeaders. It may be nil.
+	HeaderFunc func(url string) http.Header
+
 	// URLGetter is the type that performs the GET request for input URLs.
 	// If nil, this is set to DefaultGet.
 	URLGetter
+
+	// breakers are shared by the copies of the Config made for every
+	// request, so that they see the failures of each other.
+	breakers *hostBreakers
+
+	// decodes holds a token for every decode in progress when
+	// MaxConcurrentDecodes is set. It is shared like breakers.
+	decodes chan struct{}
+
+	// keep, if set, tells which of the numbers of the responses are kept.
+	// NumbersGetter sets it on the Config of a request from ?min and ?max.
+	keep func(int) bool
+
+	// priorities, if set, are the priorities of the URLs of a request, set by
+	// NumbersGetter from ?priority. URLs with a higher priority are queried
+	// first, and those missing have priority zero.
+	priorities map[string]int
+
+	// sample, if positive, is the number of numbers of a request sampled,
+	// with sampleSeed, as the URLs respond. NumbersGetter sets it from
+	// ?sample when no other option needs every number.
+	sample     int
+	sampleSeed int64
+
+	// abandon, if set, is closed once the consumer of the results of a run
+	// stops reading them, so that those left are dropped. NumbersGetter
+	// sets it on the Config of every request, and closes it even if
+	// collecting the numbers panics.
+	abandon chan struct{}
+
+	// merged, if set, keeps a copy of the results merged so far, for
+	// NumbersGetter to respond with if merging them does not end in time.
+	merged *mergedResults
 }
 
+// setDefaults fills in the fields of cfg that are required but were left unset.
+func (cfg *Config) setDefaults() {
+	if cfg.NumGoRoutines <= 0 {
+		cfg.NumGoRoutines = numGoRoutines
+	}
+	if cfg.URLGetter == nil {
+		cfg.URLGetter = NewDefaultGet(cfg.GetTimeout, cfg.getOptions()...)
+	}
+	// The getter is only wrapped if it is not already, so that setDefaults
+	// does not write to a Config that concurrent runs share. With CacheTTL,
+	// the cache is the outermost wrapper, so that cache hits are not rate
+	// limited.
+	if _, cached := cfg.URLGetter.(*CachingGetter); !cached || cfg.CacheTTL <= 0 {
+		if cfg.DefaultHostRPS > 0 || len(cfg.PerHostRPS) > 0 {
+			if _, ok := cfg.URLGetter.(*RateLimitedGetter); !ok {
+				limited := NewRateLimitedGetter(cfg.URLGetter, cfg.DefaultHostRPS, cfg.PerHostRPS)
+				limited.clock = cfg.clock()
+				cfg.URLGetter = limited
+			}
+		}
+		if cfg.CacheTTL > 0 {
+			cfg.URLGetter = NewCachingGetter(cfg.URLGetter, cfg.CacheTTL, newMemoryCacheStore(cfg.clock()))
+		}
+	}
+	if cfg.BreakerThreshold > 0 && cfg.breakers == nil {
+		cfg.breakers = newHostBreakers(cfg.BreakerThreshold, cfg.BreakerCooldown)
+	}
+	if cfg.MaxConcurrentDecodes > 0 && cfg.decodes == nil {
+		cfg.decodes = make(chan struct{}, cfg.MaxConcurrentDecodes)
+	}
+}
+
+// maxBytesCeiling returns the largest value MaxResponseBytes can be
+// overridden to in a request, or zero if there is no limit.
+func (cfg *Config) maxBytesCeiling() int64 {
+	if cfg.MaxResponseBytesCeiling > 0 {
+		return cfg.MaxResponseBytesCeiling
+	}
+	return cfg.MaxResponseBytes
+}
+
+// getOptions returns the options to set up the default getter with.
+func (cfg *Config) getOptions() []GetOption {
+	var opts []GetOption
+	if cfg.Clock != nil {
+		opts = append(opts, WithClock(cfg.Clock))
+	}
+	if cfg.DialContext != nil {
+		opts = append(opts, WithDialContext(cfg.DialContext))
+	}
+	if cfg.DNSCacheTTL > 0 {
+		opts = append(opts, WithDNSCache(cfg.DNSCacheTTL, nil))
+	}
+	if cfg.MaxResponseBytes > 0 {
+		opts = append(opts, WithMaxResponseBytes(cfg.MaxResponseBytes))
+	}
+	if cfg.BodyIdleTimeout > 0 {
+		opts = append(opts, WithBodyIdleTimeout(cfg.BodyIdleTimeout))
+	}
+	if cfg.MinTLSVersion != 0 {
+		opts = append(opts, WithMinTLSVersion(cfg.MinTLSVersion))
+	}
+	if cfg.DisableRedirects {
+		opts = append(opts, WithoutRedirects())
+	}
+	if cfg.UnixSockets {
+		opts = append(opts, WithUnixSockets())
+	}
+	if cfg.ProxyForHost != nil {
+		opts = append(opts, WithProxyForHost(cfg.ProxyForHost))
+	}
+	if cfg.IdleConnTimeout > 0 {
+		opts = append(opts, WithIdleConnTimeout(cfg.IdleConnTimeout))
+	}
+	if cfg.IdleCleanupInterval > 0 {
+		opts = append(opts, WithIdleCleanup(cfg.IdleCleanupInterval))
+	}
+	if cfg.Headers != nil || cfg.PerURLHeaders != nil {
+		opts = append(opts, WithHeaders(cfg.Headers, cfg.PerURLHeaders))
+	}
+	if cfg.HeaderFunc != nil {
+		opts = append(opts, WithHeaderFunc(cfg.HeaderFunc))
+	}
+	return opts
+}
+
+// Strategy is a way of scheduling the queries of URLs on goroutines. Both
+// strategies run at most Config.NumGoRoutines queries at a time.
+type Strategy int
+
+const (
+	// FixedPool starts NumGoRoutines goroutines upfront, each querying one
+	// URL at a time. This is the default strategy.
+	FixedPool Strategy = iota
+
+	// OnDemand starts a goroutine for every URL as soon as fewer than
+	// NumGoRoutines are running. It starts no more goroutines than there are
+	// URLs, which suits large NumGoRoutines with requests for few URLs.
+	OnDemand
+)
+
+// DuplicateURLPolicy controls how URLs given more than once to ProcessURLs or
+// in a request are queried and reported.
+type DuplicateURLPolicy int
+
+const (
+	// FetchEach queries a URL every time it is given, and reports the
+	// result of every query. This is the default policy.
+	FetchEach DuplicateURLPolicy = iota
+
+	// FetchOnce queries a URL once however many times it is given, and
+	// reports its result once.
+	FetchOnce
+
+	// FetchOnceEmitEach queries a URL once however many times it is given,
+	// and reports its result as many times as it is given.
+	FetchOnceEmitEach
+)
+
 // numGoRoutines is the maximum number of goroutines allowed to run at a time.
 // This value can be configured using Config.
 var numGoRoutines = 20
 
+// defaultGoRoutinesCeiling is the ceiling of NumGoRoutines unless configured
+// otherwise.
+const defaultGoRoutinesCeiling = 10000
+
+// goRoutines returns the number of goroutines to query n URLs with: no more
+// than NumGoRoutines or its ceiling, and no more than there are URLs.
+func (cfg *Config) goRoutines(n int) int {
+	ceiling := cfg.MaxGoRoutinesCeiling
+	if ceiling <= 0 {
+		ceiling = defaultGoRoutinesCeiling
+	}
+	return min(cfg.NumGoRoutines, ceiling, n)
+}
+
 // This function returns a channel of []int instead of int's. This helps in case
 // a URL returns a very large list of numbers. Sending out the slice header prevent
 // allows the functions querying the URL to return in time.
+// Once ctx is done, the URLs in flight are cancelled and the channel is closed
+// shortly after. It must be drained until then, even past the deadline, so
+// that the goroutines querying the URLs can return.
+// ProcessURLs has no error to report FloatNumbers with, so with FloatNumbers
+// no URL is queried and the channel is closed right away.
 func ProcessURLs(ctx context.Context, cfg *Config, urls []string) <-chan []int {
-	if cfg.NumGoRoutines <= 0 {
-		cfg.NumGoRoutines = numGoRoutines
-	}
-	if cfg.URLGetter == nil {
-		cfg.URLGetter = NewDefaultGet(cfg.GetTimeout)
-	}
+	cfg.setDefaults()
 
 	// numbersCh is the channel returned to the caller. Caller can range over this
 	// channel to read the number list responses recieved by GETing the input URLS.
 	numbersCh := make(chan []int)
+	if cfg.NumberKind == FloatNumbers {
+		close(numbersCh)
+		return numbersCh
+	}
+
+	results := processResults(ctx, cfg, urls)
+	go func() {
+		done := cfg.abandoned(ctx)
+		for r := range results {
+			// URLs that were never queried have no response to report.
+			if r.err == ErrSkipped {
+				continue
+			}
+			select {
+			case numbersCh <- r.numbers:
+			case <-done:
+			}
+		}
+		close(numbersCh)
+	}()
+	return numbersCh
+}
+
+// Result is the outcome of querying a URL with ProcessURLsTagged.
+type Result struct {
+	URL string
+
+	// Numbers are the numbers the URL responded with, or nil if it failed.
+	Numbers []int
+
+	// Err is the error the URL failed with, if any. It is ErrSkipped for the
+	// URLs that were not queried before ctx was done.
+	Err error
+}
+
+// ProcessURLsTagged works like ProcessURLs, but sends a Result for every URL,
+// telling the URL the numbers came from and the error it failed with, if any.
+// The URLs skipped once ctx is done are sent too, so that every URL given is
+// accounted for, once per result of Config.DuplicateURLPolicy. The channel
+// must be drained like that of ProcessURLs. With FloatNumbers, no URL is
+// queried and every one fails with ErrFloatNumbers.
+func ProcessURLsTagged(ctx context.Context, cfg *Config, urls []string) <-chan Result {
+	cfg.setDefaults()
+
+	resultCh := make(chan Result)
+	if cfg.NumberKind == FloatNumbers {
+		go func() {
+			done := cfg.abandoned(ctx)
+			for _, url := range urls {
+				select {
+				case resultCh <- Result{URL: url, Err: ErrFloatNumbers}:
+				case <-done:
+				}
+			}
+			close(resultCh)
+		}()
+		return resultCh
+	}
+	results := processResults(ctx, cfg, urls)
+	go func() {
+		done := cfg.abandoned(ctx)
+		for r := range results {
+			select {
+			case resultCh <- Result{URL: r.url, Numbers: r.numbers, Err: r.err}:
+			case <-done:
+			}
+		}
+		close(resultCh)
+	}()
+	return resultCh
+}
+
+// ProcessURLsCallback works like ProcessURLs, but calls fn with the numbers of
+// every URL as they arrive instead of sending them over a channel. fn is
+// called from a single goroutine, one call at a time, so it needs no locking.
+// ProcessURLsCallback returns once every URL is done with. Once ctx is done,
+// fn is not called anymore, and ProcessURLsCallback returns as soon as the
+// queries in flight are cancelled.
+func ProcessURLsCallback(ctx context.Context, cfg *Config, urls []string, fn func(numbers []int)) {
+	for ns := range ProcessURLs(ctx, cfg, urls) {
+		// The channel is still drained after ctx is done, so that the
+		// goroutines sending on it can return.
+		if ctx.Err() != nil {
+			continue
+		}
+		fn(ns)
+	}
+}
+
+// MergeNumbers queries urls like ProcessURLs and returns the sorted,
+// de-duplicated numbers they responded with, as NumbersGetter does for its
+// responses. The URLs that fail are left out, unless every URL does: the
+// error returned then wraps ErrAllFailed and the error of the first URL to
+// fail. ErrFloatNumbers is returned, without querying anything, for a cfg
+// with FloatNumbers.
+func MergeNumbers(ctx context.Context, cfg *Config, urls []string) ([]int, error) {
+	if cfg.NumberKind == FloatNumbers {
+		return nil, ErrFloatNumbers
+	}
+	cfg.setDefaults()
+
+	c := collect(ctx, cfg, urls)
+	if err := c.failure(); err != nil {
+		return nil, err
+	}
+	return c.numbers, nil
+}
+
+// processResults works like ProcessURLs, except that the returned channel
+// carries the full result of every URL rather than only its numbers. cfg
+// must have its defaults set. Duplicate URLs are handled following
+// cfg.DuplicateURLPolicy.
+func processResults(ctx context.Context, cfg *Config, urls []string) <-chan urlResult {
+	var counts map[string]int
+	if cfg.DuplicateURLPolicy == FetchOnceEmitEach {
+		counts = make(map[string]int)
+		for _, url := range urls {
+			counts[url]++
+		}
+	}
+	if cfg.NormalizeURLs {
+		urls = normalizeURLs(urls)
+	} else if cfg.DuplicateURLPolicy != FetchEach {
+		urls = dedupeURLs(urls)
+	}
+	if cfg.priorities != nil {
+		urls = byPriority(urls, cfg.priorities)
+	}
 
 	// processURL takes the responsibility of performing all the requests and
 	// relaying their response over to caller. This function is also responsible
 	// for closing the outbound channel.
-	go processURLs(ctx, cfg, urls, numbersCh)
-	return numbersCh
+	process := processURLs
+	if cfg.Strategy == OnDemand {
+		process = processURLs2
+	}
+	resultCh := make(chan urlResult)
+	var results <-chan urlResult = resultCh
+	if cfg.ResultBuffer > 0 {
+		// The buffer may have to abort the run, depending on its policy.
+		runCtx, cancel := context.WithCancel(ctx)
+		go process(runCtx, cfg, urls, resultCh)
+		results = bufferResults(ctx, cfg, cancel, results)
+	} else {
+		go process(ctx, cfg, urls, resultCh)
+	}
+	if counts != nil {
+		results = repeatResults(ctx, cfg, results, counts)
+	}
+	return results
+}
+
+// repeatResults relays the results received from in, repeating the result of
+// every URL as many times as counts holds for it.
+func repeatResults(ctx context.Context, cfg *Config, in <-chan urlResult, counts map[string]int) <-chan urlResult {
+	out := make(chan urlResult)
+	go func() {
+		for r := range in {
+			for i := 0; i < counts[r.url]; i++ {
+				deliver(ctx, cfg, out, r)
+			}
+		}
+		close(out)
+	}()
+	return out
+}
+
+// dedupeURLs returns a copy of urls with duplicates removed, keeping the
+// first occurrence of every URL in place.
+func dedupeURLs(urls []string) []string {
+	seen := make(map[string]bool, len(urls))
+	deduped := make([]string, 0, len(urls))
+	for _, url := range urls {
+		if !seen[url] {
+			seen[url] = true
+			deduped = append(deduped, url)
+		}
+	}
+	return deduped
+}
+
+// normalizeURLs returns a sorted copy of urls with duplicates removed.
+func normalizeURLs(urls []string) []string {
+	sorted := make([]string, len(urls))
+	copy(sorted, urls)
+	sort.Strings(sorted)
+
+	normalized := sorted[:0]
+	for _, url := range sorted {
+		if len(normalized) > 0 && url == normalized[len(normalized)-1] {
+			continue
+		}
+		normalized = append(normalized, url)
+	}
+	return normalized
+}
+
+// byPriority returns a copy of urls ordered by descending priority, the URLs
+// with the same priority keeping their order.
+func byPriority(urls []string, priorities map[string]int) []string {
+	ordered := make([]string, len(urls))
+	copy(ordered, urls)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return priorities[ordered[i]] > priorities[ordered[j]]
+	})
+	return ordered
 }
 
 // processURLs GETs the input URL and sends their response (list of numbers)
@@ -80,33 +854,42 @@ func ProcessURLs(ctx context.Context, cfg *Config, urls []string) <-chan []int {
 // responsible of handling exactly one input URL at a time.
 // The function also watches for input context's cancellation and can perform
 // an early return accordingly.
-func processURLs(ctx context.Context, cfg *Config, urls []string, out chan<- []int) {
+func processURLs(ctx context.Context, cfg *Config, urls []string, out chan<- urlResult) {
 	var wg sync.WaitGroup
 
-	wg.Add(cfg.NumGoRoutines)
+	workers := cfg.goRoutines(len(urls))
+	wg.Add(workers)
 
 	// urlCh is used to fan out the input URL over to several goroutines for processing.
 	urlCh := make(chan string)
 
 	// Spin numGoRoutines number fo goroutines. Each goroutine waits on urlCh
 	// for new work.
-	for i := 0; i < cfg.NumGoRoutines; i++ {
+	for i := 0; i < workers; i++ {
 		go func() {
 			defer wg.Done()
 			for url := range urlCh {
 				// out is closed only once ever goroutine returns due to the WaitGroup
 				// defined above hence send on a close channel is not possible.
-				out <- fetchResponse(ctx, cfg, url)
+				deliver(ctx, cfg, out, fetchResponse(ctx, cfg, url))
 			}
 		}()
 	}
 
-	for _, url := range urls {
-		select {
-		case urlCh <- url:
-		case <-ctx.Done():
-			break
+dispatch:
+	for i, url := range urls {
+		if ctx.Err() == nil {
+			select {
+			case urlCh <- url:
+				continue
+			case <-ctx.Done():
+			}
+		}
+		// The URLs left could not be dispatched before the end of the request.
+		for _, url := range urls[i:] {
+			deliver(ctx, cfg, out, urlResult{url: url, err: ErrSkipped})
 		}
+		break dispatch
 	}
 	close(urlCh)
 
@@ -114,28 +897,244 @@ func processURLs(ctx context.Context, cfg *Config, urls []string, out chan<- []i
 	close(out)
 }
 
+// processURLStream works like processResults, with the URLs received over urls
+// as they come rather than known upfront. It sends the results over out, and
+// closes it once urls is closed and every URL received is done with. The URLs
+// received after ctx is done are skipped. Duplicate URLs are queried each
+// time, and the result buffer does not apply.
+func processURLStream(ctx context.Context, cfg *Config, urls <-chan streamedURL, out chan<- urlResult) {
+	var wg sync.WaitGroup
+
+	workers := cfg.goRoutines(cfg.NumGoRoutines)
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for u := range urls {
+				if ctx.Err() != nil {
+					deliver(ctx, cfg, out, urlResult{url: u.url, err: ErrSkipped, index: u.index})
+					continue
+				}
+				res := fetchResponse(ctx, cfg, u.url)
+				res.index = u.index
+				deliver(ctx, cfg, out, res)
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(out)
+}
+
 // fetchResponse calls the functions to query the input URL. This function also
-// decodes the response into appropriate type and returns only the slice of numbers.
-// In case of an error, a nil slice is returned.
-func fetchResponse(ctx context.Context, ug URLGetter, url string) []int {
-	data, err := ug.Get(ctx, url)
+// decodes the response into appropriate type and returns it along with the
+// details of the query. In case of an error, the numbers are nil.
+func fetchResponse(ctx context.Context, cfg *Config, url string) urlResult {
+	start := cfg.clock().Now()
+	res := queryURL(ctx, cfg, url)
+	res.latency = cfg.clock().Since(start)
+	return res
+}
+
+// queryURL does the work of fetchResponse, which times it.
+func queryURL(ctx context.Context, cfg *Config, url string) urlResult {
+	if isRangeDirective(url) {
+		return expandRange(cfg, url)
+	}
+	res := urlResult{url: url}
+
+	target := url
+	if cfg.URLRewrite != nil {
+		var err error
+		if target, err = cfg.URLRewrite(url); err != nil {
+			log.Printf("error rewriting url %s: %v", url, err)
+			res.err = fmt.Errorf("%w: %w", ErrBlocked, err)
+			return res
+		}
+	}
+
+	if cfg.PerURLBudget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = withTimeout(ctx, cfg.clock(), cfg.PerURLBudget)
+		defer cancel()
+	}
+	if cfg.breakers == nil {
+		return retryFetch(ctx, cfg, url, func() urlResult {
+			return fetchOnce(ctx, cfg, url, target)
+		})
+	}
+
+	host := urlHost(target)
+	if !cfg.breakers.allow(host, cfg.clock().Now()) {
+		res.err = ErrCircuitOpen
+		return res
+	}
+	res = retryFetch(ctx, cfg, url, func() urlResult {
+		return fetchOnce(ctx, cfg, url, target)
+	})
+	// The GETs cut short by the deadline or cancellation of the caller say
+	// nothing about the host, unlike those cut short by GetTimeout.
+	if ctx.Err() != nil {
+		cfg.breakers.release(host)
+		return res
+	}
+	cfg.breakers.record(host, cfg.clock().Now(), hostFailed(res.err))
+	return res
+}
+
+// decoded holds the numbers of a decoded response: numbers, or floats with
+// FloatNumbers.
+type decoded struct {
+	numbers []int
+	floats  []float64
+}
+
+// decodeBody decodes the numbers of resp, with the decoder registered for its
+// content type if any, once normalized to UTF-8. It frees the decode token
+// taken by its caller once done, so that abandoned decodes keep theirs until
+// they end.
+func decodeBody(cfg *Config, resp *Response) (decoded, error) {
+	if cfg.decodes != nil {
+		defer func() { <-cfg.decodes }()
+	}
+	contentType := resp.Header.Get("Content-Type")
+	body := normalizeText(resp.Body, contentType)
+	if dec := decoderFor(contentType); dec != nil {
+		numbers, err := decodeWith(dec, body, !cfg.DisableDecoderRecovery)
+		if cfg.NumberKind == FloatNumbers {
+			return decoded{floats: intsToFloats(numbers)}, err
+		}
+		return decoded{numbers: numbers}, err
+	}
+	if cfg.NumberKind == FloatNumbers {
+		floats, err := decodeFloats(body, cfg)
+		return decoded{floats: floats}, err
+	}
+	numbers, err := decodeInts(body, contentType, cfg)
+	return decoded{numbers: numbers}, err
+}
+
+// decodeInts decodes the integers of a response body sent with contentType,
+// as JSON or, with cfg.LineDecoding, one per line.
+func decodeInts(body []byte, contentType string, cfg *Config) ([]int, error) {
+	if !cfg.LineDecoding {
+		return decodeNumbers(body, cfg)
+	}
+	if isPlainText(contentType) {
+		return decodeLines(body)
+	}
+	numbers, err := decodeNumbers(body, cfg)
+	if err != nil && numbers == nil && !errors.Is(err, ErrTooDeep) {
+		if lines, lineErr := decodeLines(body); lineErr == nil {
+			return lines, nil
+		}
+	}
+	return numbers, err
+}
+
+// decodeWithin works like decodeBody, but gives up on decodes taking longer
+// than d with errDecodeTimeout. An abandoned decode runs to its end in the
+// background. It only reads resp, which no one else uses, and its result is
+// dropped, so it cannot affect the result of the URL.
+func decodeWithin(cfg *Config, resp *Response, d time.Duration) (decoded, error) {
+	type outcome struct {
+		numbers decoded
+		err     error
+	}
+	// doneCh is buffered so that an abandoned decode does not block on it.
+	doneCh := make(chan outcome, 1)
+	go func() {
+		numbers, err := decodeBody(cfg, resp)
+		doneCh <- outcome{numbers, err}
+	}()
+
+	select {
+	case res := <-doneCh:
+		return res.numbers, res.err
+	case <-cfg.clock().After(d):
+		return decoded{}, errDecodeTimeout
+	}
+}
+
+// fetchOnce queries target, the possibly rewritten url, once and decodes the
+// response.
+func fetchOnce(ctx context.Context, cfg *Config, url, target string) urlResult {
+	res := urlResult{url: url}
+
+	resp, err := get(ctx, cfg.URLGetter, target)
+	if resp != nil {
+		res.status = resp.StatusCode
+	}
 	if err != nil {
 		log.Printf("error GETing url %s: %v", url, err)
-		return nil
+		res.err = err
+		return res
+	}
+	if cfg.ShouldInclude != nil && !cfg.ShouldInclude(url, resp.Header) {
+		if cfg.NumberKind == FloatNumbers {
+			res.floats = []float64{}
+		} else {
+			res.numbers = []int{}
+		}
+		return res
+	}
+
+	if cfg.decodes != nil {
+		select {
+		case cfg.decodes <- struct{}{}:
+		case <-ctx.Done():
+			res.err = ctx.Err()
+			return res
+		}
+	}
+	var dec decoded
+	if cfg.MaxDecodeTime > 0 {
+		dec, err = decodeWithin(cfg, resp, cfg.MaxDecodeTime)
+	} else {
+		dec, err = decodeBody(cfg, resp)
+	}
+	if err == errTrailingData && !cfg.RejectTrailingData {
+		log.Printf("warning for %s: %v", url, err)
+		err = nil
+	}
+	if err != nil {
+		log.Printf("error reading response for %s: %v -- %v", url, err, resp.Body)
+		res.err = fmt.Errorf("%w: %w", ErrDecode, err)
+		return res
 	}
+	if cfg.keep != nil {
+		dec.numbers = filterNumbers(dec.numbers, cfg.keep)
+	}
+	res.numbers, res.floats = dec.numbers, dec.floats
+	return res
+}
 
-	result := result{}
+// filterNumbers returns the numbers keep reports true for, reusing the
+// storage of numbers.
+func filterNumbers(numbers []int, keep func(int) bool) []int {
+	kept := numbers[:0]
+	for _, n := range numbers {
+		if keep(n) {
+			kept = append(kept, n)
+		}
+	}
+	return kept
+}
 
-	err = json.Unmarshal(data, &result)
+// get GETs url with ug, through GetResponse if ug implements ResponseGetter.
+func get(ctx context.Context, ug URLGetter, url string) (*Response, error) {
+	if rg, ok := ug.(ResponseGetter); ok {
+		return rg.GetResponse(ctx, url)
+	}
+	data, err := ug.Get(ctx, url)
 	if err != nil {
-		log.Printf("error reading response for %s: %v -- %v", url, err, data)
-		return nil
+		return nil, err
 	}
-	return result.Numbers
+	return &Response{Body: data}, nil
 }
 
 // processURLs2 is an alternative implementation of processURLs that can be
-// used as a drop in replacement.
+// used as a drop in replacement. It implements the OnDemand strategy.
 // This implementation creates goroutines to query the URLs as they are required
 // upto a maximum allowed count. If the number of input URLs is less than
 // numGoRoutines, additional goroutines will not be created. This implementation
@@ -145,17 +1144,25 @@ func fetchResponse(ctx context.Context, ug URLGetter, url string) []int {
 // for illustratiove purposes.
 // The function also watches for input context's cancellation and can perform
 // an early return accordingly.
-func processURLs2(ctx context.Context, cfg *Config, urls []string, out chan []int) {
+func processURLs2(ctx context.Context, cfg *Config, urls []string, out chan<- urlResult) {
 	var wg sync.WaitGroup
 
-	limiter := make(chan struct{}, cfg.NumGoRoutines)
+	limiter := make(chan struct{}, cfg.goRoutines(len(urls)))
 
-	for _, u := range urls {
+	for i, u := range urls {
 		// Below select unblocks only when limiter is not full or ctx is cancelled.
 		select {
 		case limiter <- struct{}{}:
 			wg.Add(1)
 		case <-ctx.Done():
+			// The remaining URLs could not be dispatched before the end of
+			// the request. The queries in flight are still waited for so
+			// that out is only closed once they are done with it.
+			for _, url := range urls[i:] {
+				deliver(ctx, cfg, out, urlResult{url: url, err: ErrSkipped})
+			}
+			wg.Wait()
+			close(out)
 			return
 		}
 
@@ -165,7 +1172,7 @@ func processURLs2(ctx context.Context, cfg *Config, urls []string, out chan []in
 				wg.Done()
 			}()
 			// Similar sync based measures to processURLs avoids send on closed channels.
-			out <- fetchResponse(ctx, cfg, url)
+			deliver(ctx, cfg, out, fetchResponse(ctx, cfg, url))
 		}(u)
 	}
 
@@ -5,14 +5,18 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
+	"reflect"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"numbers/internal/testutil"
 )
 
 func TestProcessURLsAllOK(t *testing.T) {
@@ -177,6 +181,409 @@ func TestProcessURLsTooManyURLs(t *testing.T) {
 	}
 }
 
+func TestProcessURLsNormalizeURLs(t *testing.T) {
+	exp := []string{"http://rand10.10", "http://rand100.10", "http://rand1000.10"}
+
+	rg := &recordingGetter{URLGetter: &testGetter{500 * time.Millisecond}}
+	cfg := &Config{NumGoRoutines: 1, NormalizeURLs: true, URLGetter: rg}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	ch := ProcessURLs(ctx, cfg, []string{
+		"http://rand1000.10",
+		"http://rand10.10",
+		"http://rand100.10",
+		"http://rand10.10",
+		"http://rand1000.10",
+	})
+	for _ = range ch {
+	}
+	if !reflect.DeepEqual(exp, rg.urls) {
+		t.Fatalf("fetched URLs mismatch: %s", comp(exp, rg.urls))
+	}
+}
+
+func TestProcessURLsURLRewrite(t *testing.T) {
+	expURLs := []string{"http://internal-a/numbers", "http://internal-b/numbers"}
+	expNumbers := 5
+
+	targets := map[string]string{
+		"source-a": "http://internal-a/numbers",
+		"source-b": "http://internal-b/numbers",
+	}
+	rg := &recordingGetter{URLGetter: staticGetter{
+		"http://internal-a/numbers": `{"numbers":[1,2,3]}`,
+		"http://internal-b/numbers": `{"numbers":[4,5]}`,
+	}}
+	cfg := &Config{
+		NumGoRoutines: 1,
+		URLRewrite: func(url string) (string, error) {
+			target, ok := targets[url]
+			if !ok {
+				return "", errors.New("unknown source " + url)
+			}
+			return target, nil
+		},
+		URLGetter: rg,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	var nilSlcCount, numCount int
+	for ns := range ProcessURLs(ctx, cfg, []string{"source-a", "source-b", "http://internal-a/numbers"}) {
+		if ns == nil {
+			nilSlcCount++
+		}
+		numCount += len(ns)
+	}
+	if !reflect.DeepEqual(expURLs, rg.urls) {
+		t.Fatalf("fetched URLs mismatch: %s", comp(expURLs, rg.urls))
+	}
+	if nilSlcCount != 1 {
+		t.Fatalf("rejected URL not failed: %s", comp(1, nilSlcCount))
+	}
+	if numCount != expNumbers {
+		t.Fatalf("total numbers count mismatch: %s", comp(expNumbers, numCount))
+	}
+}
+
+func TestProcessURLsDuplicateURLPolicy(t *testing.T) {
+	urls := []string{"http://a", "http://b", "http://a", "http://a"}
+
+	for _, tc := range []struct {
+		policy     DuplicateURLPolicy
+		expFetches int
+		expResults int
+	}{
+		{FetchEach, 3, 3},
+		{FetchOnce, 1, 1},
+		{FetchOnceEmitEach, 1, 3},
+	} {
+		cg := &countingGetter{URLGetter: staticGetter{
+			"http://a": `{"numbers":[1,2]}`,
+			"http://b": `{"numbers":[3]}`,
+		}}
+		cfg := &Config{NumGoRoutines: 2, DuplicateURLPolicy: tc.policy, URLGetter: cg}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		results := 0
+		for r := range processResults(ctx, cfg, urls) {
+			if r.url == "http://a" {
+				results++
+			}
+		}
+		cancel()
+
+		if got := cg.count("http://a"); got != tc.expFetches {
+			t.Fatalf("fetch count mismatch with policy %d: %s", tc.policy, comp(tc.expFetches, got))
+		}
+		if results != tc.expResults {
+			t.Fatalf("result count mismatch with policy %d: %s", tc.policy, comp(tc.expResults, results))
+		}
+	}
+}
+
+func TestProcessURLsOnDemandTimeout(t *testing.T) {
+	urls := []string{}
+	for i := 0; i < 20; i++ {
+		urls = append(urls, "http://rand10.10")
+	}
+
+	// As with the fixed pool, two goroutines cannot query every URL in time,
+	// and the channel must still be closed once the context is done.
+	cfg := newConfig(70*time.Millisecond, 500*time.Millisecond)
+	cfg.NumGoRoutines = 2
+	cfg.Strategy = OnDemand
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ResponseTimeout)
+	defer cancel()
+
+	var nilSlcCount int
+	for ns := range ProcessURLs(ctx, cfg, urls) {
+		if ns == nil {
+			nilSlcCount++
+		}
+	}
+	if nilSlcCount == 0 {
+		t.Fatalf("every slice non-nil, no timeouts: %s", comp("at least 1 nil slice", nilSlcCount))
+	}
+}
+
+func TestProcessURLsCallback(t *testing.T) {
+	expCalls := 3
+	expNumbers := 120
+
+	cfg := newConfig(500*time.Millisecond, 500*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ResponseTimeout)
+	defer cancel()
+
+	// The counters are not locked: the callback is never called concurrently.
+	var calls, numCount int
+	ProcessURLsCallback(ctx, cfg, []string{"http://rand10.10", "http://rand100.20", "http://rand10.30"}, func(ns []int) {
+		calls++
+		numCount += len(ns)
+	})
+	if calls != expCalls {
+		t.Fatalf("callback count mismatch: %s", comp(expCalls, calls))
+	}
+	if numCount != expNumbers {
+		t.Fatalf("total numbers count mismatch: %s", comp(expNumbers, numCount))
+	}
+}
+
+func TestProcessURLsHugeNumGoRoutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	for _, tc := range []struct {
+		urls    int
+		ceiling int
+		maxNew  int
+	}{
+		// Workers are clamped to the two URLs.
+		{2, 0, 2},
+		// Workers are clamped to the ceiling.
+		{50, 10, 10},
+	} {
+		urls := make([]string, tc.urls)
+		for i := range urls {
+			urls[i] = "http://rand10.50"
+		}
+		cfg := newConfig(time.Second, time.Second)
+		cfg.NumGoRoutines = 1000000
+		cfg.MaxGoRoutinesCeiling = tc.ceiling
+
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ResponseTimeout)
+		ch := ProcessURLs(ctx, cfg, urls)
+
+		// While the URLs are in flight, only the workers, the dispatcher
+		// and the relay of ProcessURLs run on top of the test.
+		time.Sleep(20 * time.Millisecond)
+		if n := runtime.NumGoroutine() - before; n > tc.maxNew+2 {
+			t.Fatalf("goroutine count not bounded for %d URLs: %s", tc.urls, comp(tc.maxNew+2, n))
+		}
+		for _ = range ch {
+		}
+		cancel()
+		waitForGoroutines(before)
+	}
+}
+
+func TestProcessURLsCancelledStopsDispatch(t *testing.T) {
+	expFetches := 0
+	numURLs := 100000
+
+	urls := make([]string, numURLs)
+	for i := range urls {
+		urls[i] = "http://rand10.50/" + strconv.Itoa(i)
+	}
+	cg := &countingGetter{URLGetter: staticGetter{}}
+	cfg := &Config{NumGoRoutines: 4, URLGetter: cg}
+	cfg.setDefaults()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	out := make(chan urlResult)
+	start := time.Now()
+	go processURLs(ctx, cfg, urls, out)
+	var skipped int
+	for res := range out {
+		if errors.Is(res.err, ErrSkipped) {
+			skipped++
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("dispatch not stopped promptly: %s", comp("< 1s", elapsed))
+	}
+	if skipped != numURLs {
+		t.Fatalf("skipped count mismatch: %s", comp(numURLs, skipped))
+	}
+	var fetches int
+	for _, url := range urls {
+		fetches += cg.count(url)
+	}
+	if fetches != expFetches {
+		t.Fatalf("fetch count mismatch: %s", comp(expFetches, fetches))
+	}
+}
+
+func TestProcessURLsAbandonOnCancel(t *testing.T) {
+	urls := make([]string, 20)
+	bodies := staticGetter{}
+	for i := range urls {
+		urls[i] = "http://a/" + strconv.Itoa(i%10)
+		bodies[urls[i]] = `{"numbers":[1]}`
+	}
+
+	for name, tune := range map[string]func(cfg *Config){
+		"fixed pool": func(cfg *Config) {},
+		"on demand":  func(cfg *Config) { cfg.Strategy = OnDemand },
+		"buffer":     func(cfg *Config) { cfg.ResultBuffer = 2 },
+		"emit each":  func(cfg *Config) { cfg.DuplicateURLPolicy = FetchOnceEmitEach },
+	} {
+		before := runtime.NumGoroutine()
+		cfg := &Config{NumGoRoutines: 4, URLGetter: bodies, AbandonOnCancel: true}
+		tune(cfg)
+
+		func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer func() {
+				recover()
+			}()
+			defer cancel()
+			for _ = range ProcessURLs(ctx, cfg, urls) {
+				panic("consumer failed")
+			}
+		}()
+
+		if !waitForGoroutines(before) {
+			t.Fatalf("%s: goroutines leaked after the consumer panicked: %s", name, comp(before, runtime.NumGoroutine()))
+		}
+	}
+}
+
+func TestProcessURLsTagged(t *testing.T) {
+	exp := map[string][]int{"http://a": {3, 1}, "http://b": {2}, "http://fail": nil}
+
+	cfg := &Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		URLGetter:       staticGetter{"http://a": `{"numbers":[3,1]}`, "http://b": `{"numbers":[2]}`},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ResponseTimeout)
+	defer cancel()
+
+	got := make(map[string][]int)
+	for r := range ProcessURLsTagged(ctx, cfg, []string{"http://a", "http://b", "http://fail"}) {
+		if (r.Err != nil) != (r.URL == "http://fail") {
+			t.Fatalf("error mismatch for %s: %v", r.URL, r.Err)
+		}
+		got[r.URL] = r.Numbers
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("results mismatch: %s", comp(exp, got))
+	}
+
+	// The URLs not queried in time are reported as skipped.
+	cancel()
+	for r := range ProcessURLsTagged(ctx, cfg, []string{"http://a"}) {
+		if !errors.Is(r.Err, ErrSkipped) {
+			t.Fatalf("error mismatch for %s: %s", r.URL, comp(ErrSkipped, r.Err))
+		}
+	}
+}
+
+func TestMergeNumbers(t *testing.T) {
+	exp := []int{1, 2, 3, 5}
+
+	cfg := &Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		URLGetter:       staticGetter{"http://a": `{"numbers":[3,1,2]}`, "http://b": `{"numbers":[5,2]}`},
+	}
+
+	got, err := MergeNumbers(context.Background(), cfg, []string{"http://a", "http://b", "http://fail"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("numbers mismatch: %s", comp(exp, got))
+	}
+
+	if _, err := MergeNumbers(context.Background(), cfg, []string{"http://fail", "http://down"}); !errors.Is(err, ErrAllFailed) {
+		t.Fatalf("error mismatch when every URL fails: %s", comp(ErrAllFailed, err))
+	}
+}
+
+func TestMergeNumbersKeepDuplicates(t *testing.T) {
+	exp := []int{1, 2, 2, 3, 3, 3}
+
+	cfg := &Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		KeepDuplicates:  true,
+		URLGetter:       staticGetter{"http://a": `{"numbers":[3,1,2,3]}`, "http://b": `{"numbers":[3,2]}`},
+	}
+
+	got, err := MergeNumbers(context.Background(), cfg, []string{"http://a", "http://b"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("numbers mismatch: %s", comp(exp, got))
+	}
+}
+
+func TestMergeNumbersMatchesServeHTTP(t *testing.T) {
+	getter := staticGetter{
+		"http://a": `{"numbers":[1,3,5,3]}`,
+		"http://b": `{"numbers":[2,3,4]}`,
+		"http://c": `{"numbers":[6]}`,
+	}
+	urls := []string{"http://a", "http://b", "http://c", "http://down"}
+	query := "/numbers?u=http://a&u=http://b&u=http://c&u=http://down"
+
+	for name, tune := range map[string]func(cfg *Config){
+		"default":         func(cfg *Config) {},
+		"keep duplicates": func(cfg *Config) { cfg.KeepDuplicates = true },
+		"assume sorted":   func(cfg *Config) { cfg.AssumeSorted = true },
+	} {
+		cfg := Config{ResponseTimeout: 500 * time.Millisecond, URLGetter: getter}
+		tune(&cfg)
+
+		ng := &NumbersGetter{Config: cfg}
+		exp := decodeResponse(t, serve(ng, query).Body.Bytes())
+		got, err := MergeNumbers(context.Background(), &cfg, urls)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if !reflect.DeepEqual(exp, got) {
+			t.Fatalf("%s: numbers mismatch with ServeHTTP: %s", name, comp(exp, got))
+		}
+	}
+}
+
+func TestFloatNumbersRefused(t *testing.T) {
+	urls := []string{"http://a", "http://b"}
+
+	cg := &countingGetter{URLGetter: staticGetter{"http://a": `{"numbers":[1.5]}`, "http://b": `{"numbers":[2]}`}}
+	cfg := &Config{ResponseTimeout: 500 * time.Millisecond, URLGetter: cg, NumberKind: FloatNumbers}
+
+	if _, err := MergeNumbers(context.Background(), cfg, urls); err != ErrFloatNumbers {
+		t.Fatalf("MergeNumbers error mismatch: %s", comp(ErrFloatNumbers, err))
+	}
+
+	var got []string
+	for r := range ProcessURLsTagged(context.Background(), cfg, urls) {
+		if r.Err != ErrFloatNumbers {
+			t.Fatalf("ProcessURLsTagged error mismatch for %s: %s", r.URL, comp(ErrFloatNumbers, r.Err))
+		}
+		got = append(got, r.URL)
+	}
+	if !reflect.DeepEqual(urls, got) {
+		t.Fatalf("ProcessURLsTagged URLs mismatch: %s", comp(urls, got))
+	}
+
+	for ns := range ProcessURLs(context.Background(), cfg, urls) {
+		t.Fatalf("ProcessURLs numbers mismatch: %s", comp(nil, ns))
+	}
+
+	// The results of ProcessURLsTagged are dropped once abandoned.
+	before := runtime.NumGoroutine()
+	abandoning := &Config{URLGetter: cg, NumberKind: FloatNumbers, AbandonOnCancel: true}
+	ctx, cancel := context.WithCancel(context.Background())
+	ProcessURLsTagged(ctx, abandoning, urls)
+	cancel()
+	if !waitForGoroutines(before) {
+		t.Fatalf("goroutines leaked once abandoned: %s", comp(before, runtime.NumGoroutine()))
+	}
+
+	for _, url := range urls {
+		if n := cg.count(url); n != 0 {
+			t.Fatalf("fetch count mismatch for %s: %s", url, comp(0, n))
+		}
+	}
+}
+
 func newConfig(res, req time.Duration) *Config {
 	return &Config{
 		ResponseTimeout: res,
@@ -184,10 +591,14 @@ func newConfig(res, req time.Duration) *Config {
 	}
 }
 
+// comp and staticGetter are the fixtures shared with the tests of the other
+// packages, under the names the tests of this one use.
 func comp(exp, got interface{}) string {
-	return fmt.Sprintf("expected: %v -- got: %v", exp, got)
+	return testutil.Comp(exp, got)
 }
 
+type staticGetter = testutil.StaticGetter
+
 type testGetter struct {
 	getTimeout time.Duration
 }
@@ -234,3 +645,19 @@ func nRandomNumbers(n int) []byte {
 	data, _ := json.Marshal(res)
 	return data
 }
+
+// recordingGetter records every URL it is asked to GET before delegating to
+// the embedded URLGetter.
+type recordingGetter struct {
+	URLGetter
+
+	mu   sync.Mutex
+	urls []string
+}
+
+func (r *recordingGetter) Get(ctx context.Context, url string) ([]byte, error) {
+	r.mu.Lock()
+	r.urls = append(r.urls, url)
+	r.mu.Unlock()
+	return r.URLGetter.Get(ctx, url)
+}
@@ -0,0 +1,49 @@
+// This file contains a decoder for the responses listing their numbers as the
+// keys of a JSON object, such as {"1":true,"2":true}.
+package numbers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// ObjectKeysContentType is the media type ObjectKeysDecoder is registered for
+// by default, failing responses with keys that are not integers.
+const ObjectKeysContentType = "application/x-numbers-keys+json"
+
+func init() {
+	RegisterDecoder(ObjectKeysContentType, ObjectKeysDecoder{})
+}
+
+// ObjectKeysDecoder implements Decoder for responses whose numbers are the
+// keys of a JSON object. The values are ignored. It can be registered for
+// other media types with RegisterDecoder.
+type ObjectKeysDecoder struct {
+	// SkipInvalid drops the keys that are not integers. Otherwise they fail
+	// the response.
+	SkipInvalid bool
+}
+
+// Decode returns the keys of the JSON object in data, in ascending order.
+func (d ObjectKeysDecoder) Decode(data []byte) ([]int, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+
+	numbers := make([]int, 0, len(obj))
+	for key := range obj {
+		n, err := strconv.Atoi(key)
+		if err != nil {
+			if d.SkipInvalid {
+				continue
+			}
+			return nil, fmt.Errorf("key %q is not an integer", key)
+		}
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
@@ -0,0 +1,48 @@
+// Tests for the decoder of the numbers listed as object keys.
+package numbers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestObjectKeysDecoder(t *testing.T) {
+	exp := []int{-3, 1, 2}
+
+	got, err := ObjectKeysDecoder{}.Decode([]byte(`{"2":true,"1":true,"-3":false}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("numbers mismatch: %s", comp(exp, got))
+	}
+
+	data := []byte(`{"2":true,"one":true,"1.5":true,"1":true}`)
+	if _, err := (ObjectKeysDecoder{}).Decode(data); err == nil {
+		t.Fatalf("non-integer keys not rejected")
+	}
+	got, err = ObjectKeysDecoder{SkipInvalid: true}.Decode(data)
+	if err != nil || !reflect.DeepEqual([]int{1, 2}, got) {
+		t.Fatalf("non-integer keys not skipped: %s", comp([]int{1, 2}, got))
+	}
+}
+
+func TestFetchResponseObjectKeys(t *testing.T) {
+	exp := []int{1, 2}
+
+	header := http.Header{"Content-Type": {ObjectKeysContentType}}
+	cfg := &Config{URLGetter: typedGetter{
+		"http://keys":    {Body: []byte(`{"1":true,"2":true}`), Header: header},
+		"http://invalid": {Body: []byte(`{"1":true,"x":true}`), Header: header},
+	}}
+
+	if got := fetchResponse(context.Background(), cfg, "http://keys").numbers; !reflect.DeepEqual(exp, got) {
+		t.Fatalf("numbers mismatch: %s", comp(exp, got))
+	}
+	if res := fetchResponse(context.Background(), cfg, "http://invalid"); !errors.Is(res.err, ErrDecode) {
+		t.Fatalf("invalid key not rejected: %s", comp(ErrDecode, res.err))
+	}
+}
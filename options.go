@@ -0,0 +1,733 @@
+// This file contains the parsing of the query parameters NumbersGetter accepts
+// on top of the input URLs, and the transformations they apply to the result.
+package numbers
+
+import (
+	"container/heap"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// requestOptions holds the per-request options parsed from the query.
+type requestOptions struct {
+	// sample is the number of numbers to randomly pick from the result. Zero
+	// returns the whole result. Unless another option needs every number,
+	// the numbers are sampled as the URLs respond and only the sample is
+	// kept, so that the memory used is bounded by its size.
+	sample int
+
+	// seed seeds the random source used for sampling.
+	seed int64
+
+	// debug adds the outcome of every URL to the response.
+	debug bool
+
+	// verbose adds the status, count of numbers and latency of every URL to
+	// the response.
+	verbose bool
+
+	// digest adds the digest of the returned numbers to the response.
+	digest bool
+
+	// summary adds the count of URLs by outcome to the response.
+	summary bool
+
+	// median adds the median of the returned numbers to the response.
+	median bool
+
+	// stats adds the stats of the request to the response.
+	stats bool
+
+	// pretty indents JSON responses for humans to read.
+	pretty bool
+
+	// provenance adds the count of URLs returning each number to the
+	// response.
+	provenance bool
+
+	// stream writes the numbers as a JSON array as the URLs respond, rather
+	// than once all of them did.
+	stream bool
+
+	// keepDuplicates returns every number received rather than each once,
+	// from ?dedupe or Config.KeepDuplicates.
+	keepDuplicates bool
+
+	// priorities are the priorities given to the URLs with ?priority, nil
+	// if there are none.
+	priorities map[string]int
+
+	// includeErrors adds the URLs that failed, and why, to the response.
+	includeErrors bool
+
+	// bucketBy is the modulus to group the result by. Zero does not group it.
+	bucketBy int
+
+	// maxBytes overrides Config.MaxResponseBytes for the request, if positive.
+	maxBytes int64
+
+	// min and max, if set, bound the numbers kept from the responses.
+	min, max *int
+
+	// sort is the order the numbers are returned in. Empty means ascending.
+	sort string
+
+	// order is the direction the numbers are returned in, from ?order or
+	// Config.Order.
+	order Order
+
+	// encoding is how the returned numbers are written. Empty writes them
+	// as they are.
+	encoding string
+
+	// op is the set operation to apply to the responses of the URLs in place
+	// of their union. Empty means the union.
+	op string
+}
+
+// Order is the direction the numbers of the responses are sorted in.
+type Order int
+
+const (
+	// OrderAsc sorts the numbers in ascending order. This is the default.
+	OrderAsc Order = iota
+
+	// OrderDesc sorts the numbers in descending order.
+	OrderDesc
+)
+
+// The orders that can be asked for with ?sort.
+const (
+	// sortFreq orders the numbers by descending count of URLs returning
+	// them, then by ascending value.
+	sortFreq = "freq"
+)
+
+// The encodings that can be asked for with ?encoding.
+const (
+	// encodingDelta writes the first of the sorted numbers, then the
+	// difference of each number with the previous one. Clients get the
+	// numbers back by prefix summing the list.
+	encodingDelta = "delta"
+)
+
+// The set operations that can be asked for with ?op.
+const (
+	// opSymDiff keeps the numbers returned by exactly one of two URLs.
+	opSymDiff = "symdiff"
+
+	// opComplement returns the numbers of [0, Config.MaxValue) that no URL
+	// returned. Unlike the numbers of the URLs, which MaxValue bounds
+	// inclusively, the universe leaves MaxValue itself out.
+	opComplement = "complement"
+)
+
+// parseOptions parses the request options from the already parsed form of r.
+// An error is returned for malformed or invalid values, or for options that
+// cannot apply to the input urls. Values beyond the limits set in cfg are
+// clamped to them.
+func parseOptions(r *http.Request, urls []string, cfg *Config) (*requestOptions, error) {
+	opts := &requestOptions{seed: time.Now().UnixNano()}
+
+	if cfg.MaxHosts > 0 {
+		if n := countHosts(urls); n > cfg.MaxHosts {
+			return nil, fmt.Errorf("the URLs span %d hosts, more than the maximum of %d", n, cfg.MaxHosts)
+		}
+	}
+	if cfg.MaxURLs > 0 && len(urls) > cfg.MaxURLs {
+		return nil, fmt.Errorf("%d URLs given, more than the maximum of %d", len(urls), cfg.MaxURLs)
+	}
+
+	if v := r.Form.Get("sample"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, errors.New("sample must be a positive integer")
+		}
+		opts.sample = n
+	}
+	if v := r.Form.Get("seed"); v != "" {
+		seed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, errors.New("seed must be an integer")
+		}
+		opts.seed = seed
+	}
+	var err error
+	if opts.debug, err = parseBool(r, "debug"); err != nil {
+		return nil, err
+	}
+	if opts.verbose, err = parseBool(r, "verbose"); err != nil {
+		return nil, err
+	}
+	if opts.includeErrors, err = parseBool(r, "includeErrors"); err != nil {
+		return nil, err
+	}
+	if opts.digest, err = parseBool(r, "digest"); err != nil {
+		return nil, err
+	}
+	if opts.summary, err = parseBool(r, "summary"); err != nil {
+		return nil, err
+	}
+	if opts.median, err = parseBool(r, "median"); err != nil {
+		return nil, err
+	}
+	if opts.stats, err = parseBool(r, "stats"); err != nil {
+		return nil, err
+	}
+	if opts.pretty, err = parseBool(r, "pretty"); err != nil {
+		return nil, err
+	}
+	if opts.provenance, err = parseBool(r, "provenance"); err != nil {
+		return nil, err
+	}
+	if opts.stream, err = parseBool(r, "stream"); err != nil {
+		return nil, err
+	}
+	opts.keepDuplicates = cfg.KeepDuplicates
+	if v := r.Form.Get("dedupe"); v != "" {
+		dedupe, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, errors.New("dedupe must be a boolean")
+		}
+		opts.keepDuplicates = !dedupe
+	} else if opts.stream || r.Form.Get("sort") == sortFreq {
+		// The default gives way to the options writing every number once.
+		opts.keepDuplicates = false
+	}
+	if opts.priorities, err = parsePriorities(r.URL.RawQuery); err != nil {
+		return nil, err
+	}
+	if v := r.Form.Get("bucketBy"); v != "" {
+		m, err := strconv.Atoi(v)
+		if err != nil || m <= 0 {
+			return nil, errors.New("bucketBy must be a positive integer")
+		}
+		opts.bucketBy = m
+	}
+	if v := r.Form.Get("maxBytes"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, errors.New("maxBytes must be a positive integer")
+		}
+		if ceiling := cfg.maxBytesCeiling(); ceiling > 0 && n > ceiling {
+			n = ceiling
+		}
+		opts.maxBytes = n
+	}
+	for _, bound := range []struct {
+		name string
+		v    **int
+	}{{"min", &opts.min}, {"max", &opts.max}} {
+		if v := r.Form.Get(bound.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, errors.New(bound.name + " must be an integer")
+			}
+			*bound.v = &n
+		}
+	}
+	if opts.min != nil && opts.max != nil && *opts.min > *opts.max {
+		return nil, errors.New("min must not be greater than max")
+	}
+	switch opts.sort = r.Form.Get("sort"); opts.sort {
+	case "":
+	case sortFreq:
+		if opts.bucketBy > 0 {
+			return nil, errors.New("sort=freq cannot be combined with bucketBy")
+		}
+		if opts.keepDuplicates {
+			return nil, errors.New("sort=freq cannot be combined with dedupe=false")
+		}
+	default:
+		return nil, errors.New("unknown sort " + opts.sort)
+	}
+	switch v := r.Form.Get("order"); v {
+	case "":
+		// The default order gives way to the options writing the numbers in
+		// an order of their own.
+		opts.order = cfg.Order
+		if opts.sort != "" || opts.bucketBy > 0 || opts.stream {
+			opts.order = OrderAsc
+		}
+	case "asc":
+		opts.order = OrderAsc
+	case "desc":
+		opts.order = OrderDesc
+	default:
+		return nil, errors.New("order must be asc or desc")
+	}
+	if opts.order == OrderDesc && (opts.sort != "" || opts.bucketBy > 0) {
+		return nil, errors.New("order=desc cannot be combined with sort or bucketBy")
+	}
+	switch opts.encoding = r.Form.Get("encoding"); opts.encoding {
+	case "":
+	case encodingDelta:
+		if opts.sort != "" || opts.bucketBy > 0 {
+			return nil, errors.New("encoding=delta cannot be combined with sort or bucketBy")
+		}
+	default:
+		return nil, errors.New("unknown encoding " + opts.encoding)
+	}
+	switch opts.op = r.Form.Get("op"); opts.op {
+	case "":
+	case opSymDiff:
+		if len(urls) != 2 {
+			return nil, errors.New("op=symdiff requires exactly two URLs")
+		}
+	case opComplement:
+		if cfg.MaxValue <= 0 {
+			return nil, errors.New("op=complement requires MaxValue to be configured")
+		}
+	default:
+		return nil, errors.New("unknown op " + opts.op)
+	}
+	if cfg.NumberKind == FloatNumbers && !opts.floatable() {
+		return nil, errors.New("the options only apply to integers, and the numbers are floats")
+	}
+	if opts.stream && r.Method == "POST" {
+		return nil, errors.New("stream=1 is not supported for POST requests")
+	}
+	if opts.stream && !opts.streamable() {
+		return nil, errors.New("stream=1 cannot be combined with options that need every response")
+	}
+	return opts, nil
+}
+
+// parsePriorities returns the priorities set in query with a priority
+// parameter following the u parameter of each URL to prioritize, as in
+// u=http://a&priority=1&u=http://b. It returns nil if there are none.
+func parsePriorities(query string) (map[string]int, error) {
+	var priorities map[string]int
+	last, prioritized := "", false
+	for _, pair := range strings.Split(query, "&") {
+		key, value, _ := strings.Cut(pair, "=")
+		key, err1 := url.QueryUnescape(key)
+		value, err2 := url.QueryUnescape(value)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		switch key {
+		case "u":
+			last, prioritized = value, false
+		case "priority":
+			if last == "" || prioritized {
+				return nil, errors.New("priority must follow the u parameter of its URL")
+			}
+			p, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, errors.New("priority must be an integer")
+			}
+			if priorities == nil {
+				priorities = make(map[string]int)
+			}
+			priorities[last], prioritized = p, true
+		}
+	}
+	return priorities, nil
+}
+
+// countHosts returns the number of distinct hosts in urls, ignoring ports and
+// case. URLs that cannot be parsed are not counted; they fail when queried.
+// Neither are range directives, which query no host.
+func countHosts(urls []string) int {
+	hosts := make(map[string]bool)
+	for _, raw := range urls {
+		if isRangeDirective(raw) {
+			continue
+		}
+		if u, err := url.Parse(raw); err == nil {
+			hosts[strings.ToLower(u.Hostname())] = true
+		}
+	}
+	return len(hosts)
+}
+
+// window returns the filter keeping the numbers within ?min and ?max, or nil
+// if neither is set.
+func (opts *requestOptions) window() func(int) bool {
+	if opts.min == nil && opts.max == nil {
+		return nil
+	}
+	lo, hi := math.MinInt, math.MaxInt
+	if opts.min != nil {
+		lo = *opts.min
+	}
+	if opts.max != nil {
+		hi = *opts.max
+	}
+	return func(n int) bool { return n >= lo && n <= hi }
+}
+
+// windowKey identifies the window of the request among coalesced requests.
+func (opts *requestOptions) windowKey() string {
+	key := ""
+	for _, bound := range []*int{opts.min, opts.max} {
+		if bound != nil {
+			key += strconv.Itoa(*bound)
+		}
+		key += ","
+	}
+	return key
+}
+
+// streamable reports whether the numbers of the response can be written as the
+// URLs respond: it is a plain list, and no option needs them all at once.
+func (opts *requestOptions) streamable() bool {
+	return opts.plain() && opts.sample == 0 && opts.sort == "" && opts.order == OrderAsc && opts.encoding == "" && opts.op == "" && !opts.keepDuplicates
+}
+
+// samplesOnArrival reports whether the numbers can be sampled as the URLs
+// respond, keeping the sample only: no other option needs every number.
+func (opts *requestOptions) samplesOnArrival() bool {
+	return opts.sample > 0 && opts.op == "" && opts.sort != sortFreq && !opts.provenance
+}
+
+// floatable reports whether the options apply to FloatNumbers: none of them
+// needs the numbers to be integers.
+func (opts *requestOptions) floatable() bool {
+	return opts.sample == 0 && !opts.digest && !opts.median && !opts.provenance && !opts.stream &&
+		opts.bucketBy == 0 && opts.min == nil && opts.max == nil && opts.sort == "" && opts.encoding == "" && opts.op == ""
+}
+
+// plain reports whether the response is a plain list of numbers, which can
+// be written by any registered Encoder.
+func (opts *requestOptions) plain() bool {
+	return !opts.debug && !opts.verbose && !opts.includeErrors && !opts.digest && !opts.summary && !opts.median && !opts.stats && !opts.provenance && opts.bucketBy == 0
+}
+
+// namesURLs reports whether the response depends on the URLs of the request
+// as they are written, as it looks up their results by URL.
+func (opts *requestOptions) namesURLs() bool {
+	return opts.debug || opts.verbose || opts.includeErrors || opts.op == opSymDiff
+}
+
+// parseBool parses the boolean query parameter name, which is false if absent.
+func parseBool(r *http.Request, name string) (bool, error) {
+	v := r.Form.Get(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errors.New(name + " must be a boolean")
+	}
+	return b, nil
+}
+
+// sampleNumbers picks n of the distinct numbers of numbers, the merged
+// result of a request, uniformly at random, and returns them sorted. It is
+// used when the numbers could not be sampled as the URLs responded.
+func sampleNumbers(numbers []int, n int, seed int64) []int {
+	if len(numbers) <= n {
+		return numbers
+	}
+	r := newReservoir(n, seed)
+	for _, v := range numbers {
+		r.add(v)
+	}
+	return r.numbers()
+}
+
+// reservoir samples n of the distinct numbers added to it, uniformly at
+// random, in memory bounded by n. It keeps the numbers whose hash, seeded by
+// seed, is among the n smallest seen. Unlike picking replacements at random
+// as the numbers arrive, this keeps duplicates out of the sample without
+// remembering every number, and picks the same numbers for a seed whatever
+// order the URLs responded in.
+type reservoir struct {
+	n    int
+	seed uint64
+	heap sampleHeap
+	kept map[int]bool
+}
+
+func newReservoir(n int, seed int64) *reservoir {
+	return &reservoir{n: n, seed: uint64(seed), kept: make(map[int]bool, n)}
+}
+
+// add adds v to the numbers sampled from.
+func (r *reservoir) add(v int) {
+	if r.kept[v] {
+		return
+	}
+	s := sampled{n: v, hash: sampleHash(v, r.seed)}
+	if len(r.heap) < r.n {
+		heap.Push(&r.heap, s)
+		r.kept[v] = true
+		return
+	}
+	if s.hash >= r.heap[0].hash {
+		return
+	}
+	delete(r.kept, r.heap[0].n)
+	r.heap[0] = s
+	heap.Fix(&r.heap, 0)
+	r.kept[v] = true
+}
+
+// numbers returns the sorted sample.
+func (r *reservoir) numbers() []int {
+	sample := make([]int, len(r.heap))
+	for i, s := range r.heap {
+		sample[i] = s.n
+	}
+	sort.Ints(sample)
+	return sample
+}
+
+// sampleHash mixes v with seed through the finalizer of splitmix64. It maps
+// distinct numbers to distinct hashes for a given seed.
+func sampleHash(v int, seed uint64) uint64 {
+	z := uint64(v) + (seed+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// sampled is a number of a reservoir along with its hash.
+type sampled struct {
+	n    int
+	hash uint64
+}
+
+// sampleHeap is a max-heap of sampled numbers by hash, the largest hash kept
+// being the first to be replaced.
+type sampleHeap []sampled
+
+func (h sampleHeap) Len() int            { return len(h) }
+func (h sampleHeap) Less(i, j int) bool  { return h[i].hash > h[j].hash }
+func (h sampleHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *sampleHeap) Push(x interface{}) { *h = append(*h, x.(sampled)) }
+
+func (h *sampleHeap) Pop() interface{} {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
+
+// bucketNumbers groups the sorted numbers by their remainder modulo m. Buckets
+// are keyed by the remainder, which is always in [0, m) so negative numbers
+// share buckets with positive ones. Empty buckets are left out, and each
+// bucket stays sorted.
+func bucketNumbers(numbers []int, m int) map[string][]int {
+	buckets := make(map[string][]int)
+	for _, n := range numbers {
+		key := strconv.Itoa((n%m + m) % m)
+		buckets[key] = append(buckets[key], n)
+	}
+	return buckets
+}
+
+// complement returns the sorted numbers of [0, maxValue) held by none of the
+// successful results. Numbers out of bounds are ignored.
+func complement(results []urlResult, maxValue int) []int {
+	set := make([]uint64, (maxValue+63)/64)
+	for _, r := range results {
+		if r.err != nil {
+			continue
+		}
+		for _, n := range r.numbers {
+			if n >= 0 && n < maxValue {
+				set[n/64] |= 1 << (n % 64)
+			}
+		}
+	}
+
+	missing := []int{}
+	for n := 0; n < maxValue; n++ {
+		if set[n/64]&(1<<(n%64)) == 0 {
+			missing = append(missing, n)
+		}
+	}
+	return missing
+}
+
+// symmetricDifference returns the sorted numbers returned by exactly one of
+// the URLs a and b. A URL without a successful result counts as an empty set.
+func symmetricDifference(results []urlResult, a, b string) []int {
+	if a == b {
+		return []int{}
+	}
+
+	sets := map[string]map[int]bool{a: {}, b: {}}
+	for _, r := range results {
+		if set, ok := sets[r.url]; ok {
+			for _, n := range r.numbers {
+				set[n] = true
+			}
+		}
+	}
+
+	diff := []int{}
+	for n := range sets[a] {
+		if !sets[b][n] {
+			diff = append(diff, n)
+		}
+	}
+	for n := range sets[b] {
+		if !sets[a][n] {
+			diff = append(diff, n)
+		}
+	}
+
+	sort.Ints(diff)
+	return diff
+}
+
+// sourceCounts returns the count of results that hold each number. A number
+// held more than once by the same result counts once.
+func sourceCounts(results []urlResult) map[int]int {
+	counts := make(map[int]int)
+	for _, r := range results {
+		seen := make(map[int]bool, len(r.numbers))
+		for _, n := range r.numbers {
+			if !seen[n] {
+				seen[n] = true
+				counts[n]++
+			}
+		}
+	}
+	return counts
+}
+
+// numberSources is a number as reported with ?provenance=1.
+type numberSources struct {
+	Number  int `json:"number"`
+	Sources int `json:"sources"`
+}
+
+// provenance returns numbers, in order, along with the count of results that
+// hold them.
+func provenance(results []urlResult, numbers []int) []numberSources {
+	counts := sourceCounts(results)
+	sources := make([]numberSources, len(numbers))
+	for i, n := range numbers {
+		sources[i] = numberSources{Number: n, Sources: counts[n]}
+	}
+	return sources
+}
+
+// sortByFrequency returns a copy of numbers ordered by descending count of
+// results that hold them, ties broken by ascending value. A number held more
+// than once by the same result counts once.
+func sortByFrequency(results []urlResult, numbers []int) []int {
+	counts := sourceCounts(results)
+	ordered := make([]int, len(numbers))
+	copy(ordered, numbers)
+	sort.Slice(ordered, func(i, j int) bool {
+		a, b := ordered[i], ordered[j]
+		if counts[a] != counts[b] {
+			return counts[a] > counts[b]
+		}
+		return a < b
+	})
+	return ordered
+}
+
+// reversed returns a copy of numbers in reverse order.
+func reversed(numbers []int) []int {
+	rev := make([]int, len(numbers))
+	for i, n := range numbers {
+		rev[len(numbers)-1-i] = n
+	}
+	return rev
+}
+
+// deltaEncode returns the first of the sorted numbers followed by the
+// difference of each number with the previous one.
+func deltaEncode(numbers []int) []int {
+	deltas := make([]int, len(numbers))
+	prev := 0
+	for i, n := range numbers {
+		deltas[i] = n - prev
+		prev = n
+	}
+	return deltas
+}
+
+// digestNumbers returns the hex encoded SHA-256 digest of the sorted numbers.
+// Each number is hashed as its 8 byte big endian two's complement, so the
+// digest only depends on the numbers and not on how they are formatted.
+func digestNumbers(numbers []int) string {
+	h := sha256.New()
+	var buf [8]byte
+	for _, n := range numbers {
+		binary.BigEndian.PutUint64(buf[:], uint64(n))
+		h.Write(buf[:])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// sortedMedian returns the median of the sorted numbers, which is the mean of
+// the two middle numbers if there is an even count of them. It returns false
+// if there are no numbers.
+func sortedMedian(numbers []int) (float64, bool) {
+	n := len(numbers)
+	if n == 0 {
+		return 0, false
+	}
+	if n%2 == 1 {
+		return float64(numbers[n/2]), true
+	}
+	return (float64(numbers[n/2-1]) + float64(numbers[n/2])) / 2, true
+}
+
+// bitsetMedian returns the median of the distinct numbers of the results, as
+// sortedMedian would, without sorting them. It takes MaxValue/8 bytes and a
+// pass over the results to mark the numbers in a bitset, and a pass over the
+// bitset to find the middle. It returns false if there are no numbers or if
+// any is outside [0, maxValue].
+func bitsetMedian(results []urlResult, maxValue int) (float64, bool) {
+	set := make([]uint64, maxValue/64+1)
+	count := 0
+	for _, r := range results {
+		for _, n := range r.numbers {
+			if n < 0 || n > maxValue {
+				return 0, false
+			}
+			word, bit := n/64, uint64(1)<<(n%64)
+			if set[word]&bit == 0 {
+				set[word] |= bit
+				count++
+			}
+		}
+	}
+	if count == 0 {
+		return 0, false
+	}
+
+	// lo and hi are the ranks of the middle numbers, equal for an odd count.
+	lo, hi := (count-1)/2, count/2
+	var loValue int
+	seen := 0
+	for word, w := range set {
+		ones := bits.OnesCount64(w)
+		if seen+ones <= lo {
+			seen += ones
+			continue
+		}
+		for ; w != 0; w &= w - 1 {
+			n := word*64 + bits.TrailingZeros64(w)
+			if seen == lo {
+				loValue = n
+			}
+			if seen == hi {
+				return (float64(loValue) + float64(n)) / 2, true
+			}
+			seen++
+		}
+	}
+	return 0, false
+}
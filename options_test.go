@@ -0,0 +1,719 @@
+// Tests for the per-request options of NumbersGetter.
+package numbers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServeHTTPSample(t *testing.T) {
+	expSize := 5
+
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		URLGetter:       staticGetter{"http://a": `{"numbers":[9,8,7,6,5,4,3,2,1,0]}`, "http://b": `{"numbers":[10,11,12]}`},
+	}}
+
+	first := decodeResponse(t, serve(ng, "/numbers?u=http://a&u=http://b&sample=5&seed=42").Body.Bytes())
+	if len(first) != expSize {
+		t.Fatalf("sample size mismatch: %s", comp(expSize, len(first)))
+	}
+	for i := 0; i < 5; i++ {
+		got := decodeResponse(t, serve(ng, "/numbers?u=http://b&u=http://a&sample=5&seed=42").Body.Bytes())
+		if !reflect.DeepEqual(first, got) {
+			t.Fatalf("sample with fixed seed not deterministic: %s", comp(first, got))
+		}
+	}
+}
+
+func TestMergeResultsSample(t *testing.T) {
+	expSize := 3
+
+	resultCh := make(chan urlResult, 3)
+	resultCh <- urlResult{url: "http://a", numbers: []int{1, 2, 3, 4, 5}}
+	resultCh <- urlResult{url: "http://b", numbers: []int{4, 5, 6, 7, 8, 9}}
+	resultCh <- urlResult{url: "http://c", numbers: []int{1, 1, 1}}
+	close(resultCh)
+
+	c := mergeResults(&Config{sample: expSize, sampleSeed: 7}, resultCh)
+	if len(c.numbers) != expSize || !sort.IntsAreSorted(c.numbers) {
+		t.Fatalf("sample mismatch: %s", comp("3 sorted numbers", c.numbers))
+	}
+	for i, n := range c.numbers {
+		if n < 1 || n > 9 || i > 0 && n == c.numbers[i-1] {
+			t.Fatalf("sample mismatch: %s", comp("3 distinct numbers of the results", c.numbers))
+		}
+	}
+	// Only the sample is kept, while the results still count their numbers.
+	for _, r := range c.results {
+		if r.numbers != nil {
+			t.Fatalf("numbers of %s kept: %v", r.url, r.numbers)
+		}
+	}
+	if exp, got := (RequestStats{URLs: 3, Received: 14, Unique: expSize, DedupRatio: 14.0 / 3}), c.stats(); exp != got {
+		t.Fatalf("stats mismatch: %s", comp(exp, got))
+	}
+}
+
+func TestReservoirUniform(t *testing.T) {
+	// 0 is added far more often than the other numbers, which must not make
+	// it more likely to be picked.
+	picks := make(map[int]int)
+	for seed := int64(0); seed < 2000; seed++ {
+		r := newReservoir(1, seed)
+		for n := 0; n < 10; n++ {
+			for i := 0; i < 5; i++ {
+				r.add(0)
+			}
+			r.add(n)
+		}
+		picks[r.numbers()[0]]++
+	}
+	for n := 0; n < 10; n++ {
+		if picks[n] < 120 || picks[n] > 280 {
+			t.Fatalf("picks of %d mismatch: %s", n, comp("about 200", picks))
+		}
+	}
+}
+
+func TestServeHTTPSampleFallback(t *testing.T) {
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		URLGetter:       staticGetter{"http://a": `{"numbers":[9,8,7,6,5,4,3,2,1,0]}`, "http://b": `{"numbers":[10,11,12,0,1]}`},
+	}}
+
+	// With sort=freq, the numbers are only sampled once all collected, and
+	// the seed picks the same ones.
+	exp := decodeResponse(t, serve(ng, "/numbers?u=http://a&u=http://b&sample=4&seed=3").Body.Bytes())
+	got := decodeResponse(t, serve(ng, "/numbers?u=http://a&u=http://b&sample=4&seed=3&sort=freq").Body.Bytes())
+	sort.Ints(got)
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("sample mismatch: %s", comp(exp, got))
+	}
+}
+
+func TestServeHTTPSampleInvalid(t *testing.T) {
+	ng := &NumbersGetter{Config: Config{URLGetter: staticGetter{}}}
+
+	for _, q := range []string{"sample=0", "sample=x", "sample=2&seed=x"} {
+		if w := serve(ng, "/numbers?u=http://a&"+q); w.Code != http.StatusBadRequest {
+			t.Fatalf("status mismatch for %s: %s", q, comp(http.StatusBadRequest, w.Code))
+		}
+	}
+}
+
+func TestServeHTTPBucketBy(t *testing.T) {
+	exp := map[string][]int{
+		"0": {-3, 0, 3, 6},
+		"1": {-2, 1, 7},
+		"2": {2, 5},
+	}
+
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		URLGetter:       staticGetter{"http://a": `{"numbers":[0,1,2,3]}`, "http://b": `{"numbers":[3,5,6,7,-2,-3]}`},
+	}}
+
+	w := serve(ng, "/numbers?u=http://a&u=http://b&bucketBy=3")
+	var got map[string][]int
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("buckets mismatch: %s", comp(exp, got))
+	}
+
+	for _, q := range []string{"bucketBy=0", "bucketBy=-1", "bucketBy=x"} {
+		if w := serve(ng, "/numbers?u=http://a&"+q); w.Code != http.StatusBadRequest {
+			t.Fatalf("status mismatch for %s: %s", q, comp(http.StatusBadRequest, w.Code))
+		}
+	}
+}
+
+func TestServeHTTPSymDiff(t *testing.T) {
+	exp := []int{1, 2, 6, 7}
+
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		URLGetter:       staticGetter{"http://a": `{"numbers":[1,2,3,4,5,3]}`, "http://b": `{"numbers":[7,3,4,5,6]}`},
+	}}
+
+	got := decodeResponse(t, serve(ng, "/numbers?u=http://a&u=http://b&op=symdiff").Body.Bytes())
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("symmetric difference mismatch: %s", comp(exp, got))
+	}
+}
+
+func TestServeHTTPSymDiffURLCount(t *testing.T) {
+	ng := &NumbersGetter{Config: Config{URLGetter: staticGetter{}}}
+
+	for _, q := range []string{"u=http://a", "u=http://a&u=http://b&u=http://c", "u=http://a&u=http://b&op=union"} {
+		if !strings.Contains(q, "op=") {
+			q += "&op=symdiff"
+		}
+		if w := serve(ng, "/numbers?"+q); w.Code != http.StatusBadRequest {
+			t.Fatalf("status mismatch for %s: %s", q, comp(http.StatusBadRequest, w.Code))
+		}
+	}
+}
+
+func TestServeHTTPComplement(t *testing.T) {
+	// The universe is [0, 10), so 10 is never returned.
+	exp := []int{0, 4, 6, 8}
+
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		MaxValue:        10,
+		URLGetter: staticGetter{
+			"http://a":   `{"numbers":[1,2,3,2]}`,
+			"http://b":   `{"numbers":[5,7,9,-1,11]}`,
+			"http://bad": `{"numbers":[4,6,`,
+		},
+	}}
+
+	// The numbers of http://bad are not used, as it does not decode, nor
+	// those of http://down, which fails.
+	got := decodeResponse(t, serve(ng, "/numbers?u=http://a&u=http://b&u=http://bad&u=http://down&op=complement").Body.Bytes())
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("complement mismatch: %s", comp(exp, got))
+	}
+
+	ng = &NumbersGetter{Config: Config{URLGetter: staticGetter{}}}
+	if w := serve(ng, "/numbers?u=http://a&op=complement"); w.Code != http.StatusBadRequest {
+		t.Fatalf("status mismatch without MaxValue: %s", comp(http.StatusBadRequest, w.Code))
+	}
+}
+
+func TestServeHTTPMinMax(t *testing.T) {
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		URLGetter:       staticGetter{"http://a": `{"numbers":[-5,0,3,7]}`, "http://b": `{"numbers":[10,3,12,-1]}`},
+	}}
+
+	for q, exp := range map[string][]int{
+		"min=0&max=10": {0, 3, 7, 10},
+		"min=3":        {3, 7, 10, 12},
+		"max=-1":       {-5, -1},
+		"min=4&max=4":  {},
+	} {
+		got := decodeResponse(t, serve(ng, "/numbers?u=http://a&u=http://b&"+q).Body.Bytes())
+		if !reflect.DeepEqual(exp, got) {
+			t.Fatalf("numbers mismatch for %s: %s", q, comp(exp, got))
+		}
+	}
+
+	// The window applies to the outcome of the set operations, which count
+	// the numbers returned outside of it.
+	ng = &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		MaxValue:        6,
+		URLGetter:       staticGetter{"http://a": `{"numbers":[1,2]}`, "http://b": `{"numbers":[2,3]}`},
+	}}
+	for q, exp := range map[string][]int{
+		"op=complement&min=2":    {4, 5},
+		"op=complement&max=3":    {0},
+		"op=symdiff&min=2":       {3},
+		"op=symdiff&min=0&max=1": {1},
+	} {
+		got := decodeResponse(t, serve(ng, "/numbers?u=http://a&u=http://b&"+q).Body.Bytes())
+		if !reflect.DeepEqual(exp, got) {
+			t.Fatalf("numbers mismatch for %s: %s", q, comp(exp, got))
+		}
+	}
+
+	for _, q := range []string{"min=5&max=4", "min=x", "max=1.5"} {
+		if w := serve(ng, "/numbers?u=http://a&"+q); w.Code != http.StatusBadRequest {
+			t.Fatalf("status mismatch for %s: %s", q, comp(http.StatusBadRequest, w.Code))
+		}
+	}
+}
+
+func TestServeHTTPOrder(t *testing.T) {
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		URLGetter:       staticGetter{"http://a": `{"numbers":[3,1]}`, "http://b": `{"numbers":[2,3]}`},
+	}}
+
+	for q, exp := range map[string][]int{
+		"":           {1, 2, 3},
+		"order=asc":  {1, 2, 3},
+		"order=desc": {3, 2, 1},
+	} {
+		got := decodeResponse(t, serve(ng, "/numbers?u=http://a&u=http://b&"+q).Body.Bytes())
+		if !reflect.DeepEqual(exp, got) {
+			t.Fatalf("numbers mismatch for %q: %s", q, comp(exp, got))
+		}
+	}
+
+	// Config.Order applies to requests without ?order.
+	ng.Order = OrderDesc
+	for q, exp := range map[string][]int{
+		"":          {3, 2, 1},
+		"order=asc": {1, 2, 3},
+	} {
+		got := decodeResponse(t, serve(ng, "/numbers?u=http://a&u=http://b&"+q).Body.Bytes())
+		if !reflect.DeepEqual(exp, got) {
+			t.Fatalf("numbers mismatch for %q with OrderDesc: %s", q, comp(exp, got))
+		}
+	}
+	// It does not apply to the options with an order of their own.
+	if got, exp := decodeResponse(t, serve(ng, "/numbers?u=http://a&u=http://b&sort=freq").Body.Bytes()), []int{3, 1, 2}; !reflect.DeepEqual(exp, got) {
+		t.Fatalf("numbers mismatch for sort=freq with OrderDesc: %s", comp(exp, got))
+	}
+	for _, q := range []string{"bucketBy=2", "stream=1"} {
+		if w := serve(ng, "/numbers?u=http://a&u=http://b&"+q); w.Code != http.StatusOK {
+			t.Fatalf("status mismatch for %s with OrderDesc: %s", q, comp(http.StatusOK, w.Code))
+		}
+	}
+
+	for _, q := range []string{"order=down", "order=desc&sort=freq"} {
+		if w := serve(ng, "/numbers?u=http://a&"+q); w.Code != http.StatusBadRequest {
+			t.Fatalf("status mismatch for %s: %s", q, comp(http.StatusBadRequest, w.Code))
+		}
+	}
+}
+
+func TestServeHTTPDedupe(t *testing.T) {
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		URLGetter:       staticGetter{"http://a": `{"numbers":[3,1,3]}`, "http://b": `{"numbers":[1,2]}`},
+	}}
+
+	for q, exp := range map[string][]int{
+		"":             {1, 2, 3},
+		"dedupe=true":  {1, 2, 3},
+		"dedupe=false": {1, 1, 2, 3, 3},
+	} {
+		got := decodeResponse(t, serve(ng, "/numbers?u=http://a&u=http://b&"+q).Body.Bytes())
+		if !reflect.DeepEqual(exp, got) {
+			t.Fatalf("numbers mismatch for %q: %s", q, comp(exp, got))
+		}
+	}
+
+	// The request overrides Config.KeepDuplicates.
+	ng.KeepDuplicates = true
+	if got, exp := decodeResponse(t, serve(ng, "/numbers?u=http://a&u=http://b&dedupe=1").Body.Bytes()), []int{1, 2, 3}; !reflect.DeepEqual(exp, got) {
+		t.Fatalf("numbers mismatch with KeepDuplicates: %s", comp(exp, got))
+	}
+	// It does not apply to the options writing every number once.
+	if got, exp := decodeResponse(t, serve(ng, "/numbers?u=http://a&u=http://b&sort=freq").Body.Bytes()), []int{1, 2, 3}; !reflect.DeepEqual(exp, got) {
+		t.Fatalf("numbers mismatch for sort=freq with KeepDuplicates: %s", comp(exp, got))
+	}
+	if w := serve(ng, "/numbers?u=http://a&u=http://b&stream=1"); w.Code != http.StatusOK {
+		t.Fatalf("status mismatch for stream=1 with KeepDuplicates: %s", comp(http.StatusOK, w.Code))
+	}
+
+	for _, q := range []string{"dedupe=maybe", "dedupe=false&sort=freq"} {
+		if w := serve(ng, "/numbers?u=http://a&"+q); w.Code != http.StatusBadRequest {
+			t.Fatalf("status mismatch for %s: %s", q, comp(http.StatusBadRequest, w.Code))
+		}
+	}
+}
+
+func TestServeHTTPDigest(t *testing.T) {
+	getter := staticGetter{
+		"http://a": `{"numbers":[3,1,2]}`,
+		"http://b": `{"numbers":[2,4]}`,
+		"http://c": `{"numbers":[5]}`,
+	}
+	ng := &NumbersGetter{Config: Config{ResponseTimeout: 500 * time.Millisecond, URLGetter: getter}}
+
+	digest := func(q string) string {
+		var resp numbersResponse
+		body := serve(ng, "/numbers?digest=1&"+q).Body.Bytes()
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatalf("invalid response %q: %v", body, err)
+		}
+		return resp.Digest
+	}
+
+	first := digest("u=http://a&u=http://b")
+	if first == "" {
+		t.Fatalf("digest missing: %s", comp("digest", first))
+	}
+	for i := 0; i < 3; i++ {
+		if got := digest("u=http://b&u=http://a"); got != first {
+			t.Fatalf("digest not stable for identical input: %s", comp(first, got))
+		}
+	}
+	if got := digest("u=http://a&u=http://b&u=http://c"); got == first {
+		t.Fatalf("digest unchanged for changed input: %s", comp("a different digest", got))
+	}
+}
+
+func TestServeHTTPMaxBytes(t *testing.T) {
+	// The body is 40 bytes long.
+	ts := httptest.NewServer(numbersHandler(`{"numbers":[1,2,3,4,5,6,7,8,9,10,11,12]}`))
+	defer ts.Close()
+
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout:         time.Second,
+		MaxResponseBytes:        10,
+		MaxResponseBytesCeiling: 100,
+	}}
+
+	tests := []struct {
+		query string
+		count int
+	}{
+		// The default limit is too small for the body.
+		{"", 0},
+		{"&maxBytes=50", 12},
+		// The override is clamped to the ceiling, which is large enough.
+		{"&maxBytes=1000000", 12},
+		{"&maxBytes=39", 0},
+	}
+	for _, tt := range tests {
+		got := decodeResponse(t, serve(ng, "/numbers?u="+ts.URL+tt.query).Body.Bytes())
+		if len(got) != tt.count {
+			t.Fatalf("numbers count mismatch for %q: %s", tt.query, comp(tt.count, len(got)))
+		}
+	}
+
+	ng.MaxResponseBytesCeiling = 20
+	if got := decodeResponse(t, serve(ng, "/numbers?maxBytes=50&u="+ts.URL).Body.Bytes()); len(got) != 0 {
+		t.Fatalf("override not clamped to the ceiling: %s", comp(0, len(got)))
+	}
+
+	for _, q := range []string{"maxBytes=0", "maxBytes=-5", "maxBytes=x"} {
+		if w := serve(ng, "/numbers?u="+ts.URL+"&"+q); w.Code != http.StatusBadRequest {
+			t.Fatalf("status mismatch for %s: %s", q, comp(http.StatusBadRequest, w.Code))
+		}
+	}
+}
+
+func TestServeHTTPSummary(t *testing.T) {
+	exp := urlSummary{Succeeded: 2, TimedOut: 1, Failed: 1, Skipped: 2, Blocked: 1}
+
+	// With a single goroutine, the URLs after http://slow are still waiting
+	// for their turn when the request times out. The getter lingers on
+	// http://slow so that they are skipped rather than queried.
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 100 * time.Millisecond,
+		NumGoRoutines:   1,
+		URLRewrite: func(url string) (string, error) {
+			if url == "http://blocked" {
+				return "", errors.New("internal address")
+			}
+			return url, nil
+		},
+		URLGetter: &delayGetter{
+			bodies: map[string]string{"http://a": `{"numbers":[1]}`, "http://b": `{"numbers":[2]}`},
+			delays: map[string]time.Duration{"http://slow": time.Second},
+			linger: 50 * time.Millisecond,
+		},
+	}}
+
+	w := serve(ng, "/numbers?summary=1&u=http://a&u=http://fail&u=http://blocked&u=http://b&u=http://slow&u=http://c&u=http://d")
+	var got struct {
+		Summary urlSummary `json:"summary"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+	if got.Summary != exp {
+		t.Fatalf("summary mismatch: %s", comp(exp, got.Summary))
+	}
+}
+
+func TestServeHTTPStats(t *testing.T) {
+	// 8 numbers are received, 4 of them unique.
+	exp := RequestStats{URLs: 3, Received: 8, Unique: 4, DedupRatio: 2}
+
+	var hooked RequestStats
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		StatsHook:       func(s RequestStats) { hooked = s },
+		URLGetter: staticGetter{
+			"http://a": `{"numbers":[1,2,3]}`,
+			"http://b": `{"numbers":[2,3,4]}`,
+			"http://c": `{"numbers":[1,1]}`,
+		},
+	}}
+
+	w := serve(ng, "/numbers?stats=1&u=http://a&u=http://b&u=http://c")
+	var got struct {
+		Stats RequestStats `json:"stats"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+	if got.Stats != exp {
+		t.Fatalf("stats mismatch: %s", comp(exp, got.Stats))
+	}
+	if hooked != exp {
+		t.Fatalf("hooked stats mismatch: %s", comp(exp, hooked))
+	}
+
+	// The hook is called even if the stats are not asked for.
+	hooked = RequestStats{}
+	serve(ng, "/numbers?u=http://down")
+	if exp := (RequestStats{URLs: 1}); hooked != exp {
+		t.Fatalf("hooked stats mismatch without numbers: %s", comp(exp, hooked))
+	}
+}
+
+func TestServeHTTPPretty(t *testing.T) {
+	exp := "{\n  \"Numbers\": [\n    1,\n    2\n  ]\n}\n"
+
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		URLGetter:       staticGetter{"http://a": `{"numbers":[2,1]}`},
+	}}
+
+	w := serve(ng, "/numbers?pretty=1&u=http://a")
+	if got := w.Body.String(); got != exp {
+		t.Fatalf("pretty response mismatch: %s", comp(exp, got))
+	}
+	if got := decodeResponse(t, w.Body.Bytes()); !reflect.DeepEqual([]int{1, 2}, got) {
+		t.Fatalf("pretty numbers mismatch: %s", comp([]int{1, 2}, got))
+	}
+
+	w = serve(ng, "/numbers?u=http://a")
+	if exp := "{\"Numbers\":[1,2]}\n"; w.Body.String() != exp {
+		t.Fatalf("compact response mismatch: %s", comp(exp, w.Body.String()))
+	}
+}
+
+func TestMedian(t *testing.T) {
+	results := []urlResult{
+		{numbers: []int{9, 3, 70, 1}},
+		{numbers: []int{3, 128, 64, 1}},
+		{numbers: []int{200, 63}},
+	}
+	// The distinct numbers are 1 3 9 63 64 70 128 200.
+	exp := 63.5
+
+	if got, ok := bitsetMedian(results, 1000); !ok || got != exp {
+		t.Fatalf("bitset median mismatch: %s", comp(exp, got))
+	}
+	if got, ok := sortedMedian(mergeUnique(results)); !ok || got != exp {
+		t.Fatalf("sorted median mismatch: %s", comp(exp, got))
+	}
+
+	results = append(results, urlResult{numbers: []int{65}})
+	exp = 64
+	if got, ok := bitsetMedian(results, 1000); !ok || got != exp {
+		t.Fatalf("odd count bitset median mismatch: %s", comp(exp, got))
+	}
+	if got, ok := sortedMedian(mergeUnique(results)); !ok || got != exp {
+		t.Fatalf("odd count sorted median mismatch: %s", comp(exp, got))
+	}
+
+	if _, ok := bitsetMedian(results, 100); ok {
+		t.Fatalf("out of bounds numbers not detected: %s", comp(false, ok))
+	}
+	if _, ok := sortedMedian(nil); ok {
+		t.Fatalf("median of no numbers: %s", comp(false, ok))
+	}
+}
+
+func TestServeHTTPMedian(t *testing.T) {
+	getter := staticGetter{
+		"http://a": `{"numbers":[6,1,2]}`,
+		"http://b": `{"numbers":[2,5,-3]}`,
+		"http://c": `{"numbers":[3]}`,
+	}
+	// http://b returns a negative number, which makes the bitset path fall
+	// back to sorting when MaxValue is set.
+	exp := map[string]float64{
+		"u=http://a&u=http://b": 2,
+		"u=http://a&u=http://c": 2.5,
+	}
+
+	for _, maxValue := range []int{0, 100} {
+		ng := &NumbersGetter{Config: Config{ResponseTimeout: 500 * time.Millisecond, MaxValue: maxValue, URLGetter: getter}}
+		for q, expMedian := range exp {
+			var resp numbersResponse
+			body := serve(ng, "/numbers?median=1&"+q).Body.Bytes()
+			if err := json.Unmarshal(body, &resp); err != nil {
+				t.Fatalf("invalid response %q: %v", body, err)
+			}
+			if resp.Median == nil || *resp.Median != expMedian {
+				t.Fatalf("median mismatch for %s with MaxValue %d: %s", q, maxValue, comp(expMedian, resp.Median))
+			}
+		}
+	}
+}
+
+func TestServeHTTPMaxHosts(t *testing.T) {
+	cg := &countingGetter{URLGetter: staticGetter{}}
+	ng := &NumbersGetter{Config: Config{ResponseTimeout: 500 * time.Millisecond, MaxHosts: 2, URLGetter: cg}}
+
+	// Ports and case do not make hosts distinct.
+	w := serve(ng, "/numbers?u=http://a/1&u=http://A:8080/2&u=http://b/1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status mismatch within the cap: %s", comp(http.StatusOK, w.Code))
+	}
+
+	w = serve(ng, "/numbers?u=http://a/1&u=http://b/1&u=http://c/1")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status mismatch above the cap: %s", comp(http.StatusBadRequest, w.Code))
+	}
+	if n := cg.count("http://c/1"); n != 0 {
+		t.Fatalf("rejected request fetched: %s", comp(0, n))
+	}
+}
+
+func TestServeHTTPMaxURLs(t *testing.T) {
+	cg := &countingGetter{URLGetter: staticGetter{}}
+	ng := &NumbersGetter{Config: Config{ResponseTimeout: 500 * time.Millisecond, MaxURLs: 2, URLGetter: cg}}
+
+	if w := serve(ng, "/numbers?u=http://a/1&u=http://a/2"); w.Code != http.StatusOK {
+		t.Fatalf("status mismatch within the cap: %s", comp(http.StatusOK, w.Code))
+	}
+
+	w := serve(ng, "/numbers?u=http://a/1&u=http://a/2&u=range:1-3")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status mismatch above the cap: %s", comp(http.StatusBadRequest, w.Code))
+	}
+	if n := cg.count("http://a/1"); n != 1 {
+		t.Fatalf("rejected request fetched: %s", comp(1, n))
+	}
+}
+
+func TestServeHTTPSortFreq(t *testing.T) {
+	// 3 is returned by every URL, 2 and 5 by two of them, once each by
+	// http://c despite its duplicates, and 1, 4 and 9 by one.
+	exp := []int{3, 2, 5, 1, 4, 9}
+
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		URLGetter: staticGetter{
+			"http://a": `{"numbers":[9,3,2]}`,
+			"http://b": `{"numbers":[1,5,3]}`,
+			"http://c": `{"numbers":[4,5,5,3,2,2]}`,
+		},
+	}}
+
+	w := serve(ng, "/numbers?sort=freq&u=http://a&u=http://b&u=http://c")
+	if got := decodeResponse(t, w.Body.Bytes()); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("frequency order mismatch: %s", comp(exp, got))
+	}
+
+	for _, q := range []string{"sort=count", "sort=freq&bucketBy=2"} {
+		if w := serve(ng, "/numbers?u=http://a&"+q); w.Code != http.StatusBadRequest {
+			t.Fatalf("status mismatch for %s: %s", q, comp(http.StatusBadRequest, w.Code))
+		}
+	}
+}
+
+func TestServeHTTPDeltaEncoding(t *testing.T) {
+	exp := []int{-4, 1, 2, 3, 10, 1000}
+
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		URLGetter: staticGetter{
+			"http://a": `{"numbers":[1000,2,-4]}`,
+			"http://b": `{"numbers":[3,1,10,2]}`,
+		},
+	}}
+
+	w := serve(ng, "/numbers?encoding=delta&u=http://a&u=http://b")
+	deltas := decodeResponse(t, w.Body.Bytes())
+	if expDeltas := []int{-4, 5, 1, 1, 7, 990}; !reflect.DeepEqual(expDeltas, deltas) {
+		t.Fatalf("deltas mismatch: %s", comp(expDeltas, deltas))
+	}
+
+	got := make([]int, len(deltas))
+	sum := 0
+	for i, d := range deltas {
+		sum += d
+		got[i] = sum
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("prefix summed numbers mismatch: %s", comp(exp, got))
+	}
+
+	for _, q := range []string{"encoding=zigzag", "encoding=delta&sort=freq", "encoding=delta&bucketBy=2"} {
+		if w := serve(ng, "/numbers?u=http://a&"+q); w.Code != http.StatusBadRequest {
+			t.Fatalf("status mismatch for %s: %s", q, comp(http.StatusBadRequest, w.Code))
+		}
+	}
+}
+
+func TestServeHTTPProvenance(t *testing.T) {
+	exp := []numberSources{{1, 1}, {2, 2}, {3, 3}, {5, 2}, {9, 1}}
+
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		URLGetter: staticGetter{
+			"http://a": `{"numbers":[9,3,2]}`,
+			"http://b": `{"numbers":[1,5,3]}`,
+			"http://c": `{"numbers":[5,3,3,2]}`,
+		},
+	}}
+
+	w := serve(ng, "/numbers?provenance=1&u=http://a&u=http://b&u=http://c")
+	var got struct {
+		Provenance []numberSources `json:"provenance"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+	if !reflect.DeepEqual(exp, got.Provenance) {
+		t.Fatalf("provenance mismatch: %s", comp(exp, got.Provenance))
+	}
+}
+
+func TestServeHTTPPriority(t *testing.T) {
+	exp := []string{"http://c", "http://a", "http://d", "http://b"}
+
+	og := &orderGetter{URLGetter: staticGetter{
+		"http://a": `{"numbers":[1]}`,
+		"http://b": `{"numbers":[2]}`,
+		"http://c": `{"numbers":[3]}`,
+		"http://d": `{"numbers":[4]}`,
+	}}
+	// A single worker takes the URLs one at a time, in dispatch order.
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		NumGoRoutines:   1,
+		URLGetter:       og,
+	}}
+
+	serve(ng, "/numbers?u=http://a&priority=1&u=http://b&priority=-1&u=http://c&priority=5&u=http://d")
+	if !reflect.DeepEqual(exp, og.order) {
+		t.Fatalf("dispatch order mismatch: %s", comp(exp, og.order))
+	}
+
+	for _, q := range []string{"priority=1&u=http://a", "u=http://a&priority=high", "u=http://a&priority=1&priority=2"} {
+		if w := serve(ng, "/numbers?"+q); w.Code != http.StatusBadRequest {
+			t.Fatalf("status mismatch for %s: %s", q, comp(http.StatusBadRequest, w.Code))
+		}
+	}
+}
+
+// orderGetter records the order of the GETs before delegating to the
+// embedded URLGetter.
+type orderGetter struct {
+	URLGetter
+
+	mu    sync.Mutex
+	order []string
+}
+
+func (o *orderGetter) Get(ctx context.Context, url string) ([]byte, error) {
+	o.mu.Lock()
+	o.order = append(o.order, url)
+	o.mu.Unlock()
+	return o.URLGetter.Get(ctx, url)
+}
+
+// decodeResponse decodes the numbers of a JSON response body.
+func decodeResponse(t *testing.T, body []byte) []int {
+	var resp struct{ Numbers []int }
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("invalid response %q: %v", body, err)
+	}
+	return resp.Numbers
+}
@@ -0,0 +1,105 @@
+// This file contains the buffering of URL results between the goroutines
+// querying the URLs and a consumer that may not keep up with them.
+package numbers
+
+import "context"
+
+// OverflowPolicy is what happens to URL results that arrive while the buffer
+// of Config.ResultBuffer results is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes the goroutines querying the URLs wait for the
+	// consumer to make room. This is the default policy.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropOldest drops the oldest result in the buffer to make room.
+	OverflowDropOldest
+
+	// OverflowFail aborts the run: the URLs not queried yet are skipped, the
+	// queries in flight are cancelled, and the result that did not fit is
+	// dropped, as are those arriving while the buffer is still full.
+	OverflowFail
+)
+
+// bufferResults relays the results received from in, holding up to
+// cfg.ResultBuffer of them for a slow consumer and applying
+// cfg.OverflowPolicy once they are that many. cancel cancels the run, and is
+// called once in is drained. The URLs of dropped results are sent last, failed
+// with ErrOverflow, once the buffer is drained, so that they are still
+// accounted for without holding on to their numbers. If cfg.AbandonOnCancel
+// is set, the buffer is given up on once ctx is done.
+func bufferResults(ctx context.Context, cfg *Config, cancel context.CancelFunc, in <-chan urlResult) <-chan urlResult {
+	out := make(chan urlResult)
+	go func() {
+		defer close(out)
+		defer cancel()
+
+		// The goroutines sending on in give up along with the buffer, as
+		// their context derives from ctx.
+		done := cfg.abandoned(ctx)
+
+		buf := make([]urlResult, 0, cfg.ResultBuffer)
+		var dropped []string
+		for in != nil || len(buf) > 0 {
+			var send chan<- urlResult
+			var next urlResult
+			if len(buf) > 0 {
+				send, next = out, buf[0]
+			}
+			recv := in
+			if len(buf) == cfg.ResultBuffer && cfg.OverflowPolicy == OverflowBlock {
+				recv = nil
+			}
+
+			select {
+			case r, ok := <-recv:
+				if !ok {
+					in = nil
+					continue
+				}
+				if len(buf) < cfg.ResultBuffer {
+					buf = append(buf, r)
+					continue
+				}
+				switch cfg.OverflowPolicy {
+				case OverflowDropOldest:
+					dropped = append(dropped, buf[0].url)
+					buf = append(buf[1:], r)
+				case OverflowFail:
+					cancel()
+					dropped = append(dropped, r.url)
+				}
+			case send <- next:
+				buf = buf[1:]
+			case <-done:
+				return
+			}
+		}
+
+		for _, url := range dropped {
+			deliver(ctx, cfg, out, urlResult{url: url, err: ErrOverflow})
+		}
+	}()
+	return out
+}
+
+// abandoned returns the channel closed once the results of a run over ctx can
+// be dropped rather than waited for: ctx.Done() if cfg.AbandonOnCancel is set,
+// and cfg.abandon otherwise, which is nil and never closed unless the run is
+// that of a NumbersGetter request.
+func (cfg *Config) abandoned(ctx context.Context) <-chan struct{} {
+	if cfg.AbandonOnCancel {
+		return ctx.Done()
+	}
+	return cfg.abandon
+}
+
+// deliver sends r over out, unless the results of the run over ctx are
+// abandoned before the consumer takes it. See Config.AbandonOnCancel.
+func deliver(ctx context.Context, cfg *Config, out chan<- urlResult, r urlResult) {
+	select {
+	case out <- r:
+	case <-cfg.abandoned(ctx):
+	}
+}
@@ -0,0 +1,69 @@
+// Tests for the buffering of URL results.
+package numbers
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestProcessResultsOverflowPolicy(t *testing.T) {
+	fast := map[string]string{
+		"http://a": `{"numbers":[1]}`,
+		"http://b": `{"numbers":[2]}`,
+		"http://c": `{"numbers":[3]}`,
+	}
+	urls := []string{"http://a", "http://b", "http://c", "http://d", "http://e"}
+	bodies := map[string]string{"http://d": `{"numbers":[4]}`, "http://e": `{"numbers":[5]}`}
+	for url, body := range fast {
+		bodies[url] = body
+	}
+
+	for _, tc := range []struct {
+		policy      OverflowPolicy
+		delays      map[string]time.Duration
+		expOK       int
+		expOverflow int
+	}{
+		// Every URL responds before the consumer reads, and all of them get
+		// through.
+		{OverflowBlock, nil, 5, 0},
+		// Only the last two URLs to respond stay in the buffer.
+		{OverflowDropOldest, nil, 2, 3},
+		// http://d and http://e would take a second, but they are cancelled
+		// as soon as the third result does not fit.
+		{OverflowFail, map[string]time.Duration{"http://d": time.Second, "http://e": time.Second}, 2, 3},
+	} {
+		cfg := &Config{
+			NumGoRoutines:  len(urls),
+			ResultBuffer:   2,
+			OverflowPolicy: tc.policy,
+			URLGetter:      &delayGetter{bodies: bodies, delays: tc.delays},
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+
+		start := time.Now()
+		results := processResults(ctx, cfg, urls)
+
+		// The consumer only starts reading once every URL had time to respond.
+		time.Sleep(100 * time.Millisecond)
+		var ok, overflow int
+		for r := range results {
+			switch {
+			case r.err == nil:
+				ok++
+			case r.err == ErrOverflow:
+				overflow++
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+
+		if ok != tc.expOK || overflow != tc.expOverflow {
+			t.Fatalf("results mismatch with policy %d: %s", tc.policy, comp([]int{tc.expOK, tc.expOverflow}, []int{ok, overflow}))
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Fatalf("run not aborted with policy %d: %s", tc.policy, comp("< 500ms", elapsed))
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Package protobuf registers a protocol buffers decoder for the URL responses
+// queried by numbers.NumbersGetter. Importing it for its side effect is enough:
+//
+//	import _ "numbers/protobuf"
+//
+// Responses sent with "Content-Type: application/x-protobuf" are then decoded
+// as the message
+//
+//	message NumbersMessage {
+//	  repeated int64 numbers = 1;
+//	}
+//
+// The wire format of such a message is simple enough that it is read by hand
+// here, which keeps the package free of third party dependencies.
+package protobuf
+
+import (
+	"numbers"
+	"numbers/internal/protowire"
+)
+
+// ContentType is the media type the decoder is registered for.
+const ContentType = "application/x-protobuf"
+
+// numbersField is the field number of numbers in NumbersMessage.
+const numbersField = 1
+
+func init() {
+	numbers.RegisterDecoder(ContentType, ProtoDecoder{})
+}
+
+// ProtoDecoder implements numbers.Decoder for NumbersMessage. Both packed and
+// unpacked encodings of numbers are accepted, and unknown fields are skipped.
+type ProtoDecoder struct{}
+
+// Decode returns the numbers of the NumbersMessage in data.
+func (ProtoDecoder) Decode(data []byte) ([]int, error) {
+	var ns []int
+	for len(data) > 0 {
+		field, wire, rest, err := protowire.ReadTag(data)
+		if err != nil {
+			return nil, err
+		}
+		data = rest
+
+		if field == numbersField && wire == protowire.Varint {
+			v, rest, err := protowire.ReadVarint(data)
+			if err != nil {
+				return nil, err
+			}
+			data = rest
+			ns = append(ns, int(int64(v)))
+			continue
+		}
+		if field == numbersField && wire == protowire.Bytes {
+			packed, rest, err := protowire.ReadBytes(data)
+			if err != nil {
+				return nil, err
+			}
+			for len(packed) > 0 {
+				var v uint64
+				if v, packed, err = protowire.ReadVarint(packed); err != nil {
+					return nil, err
+				}
+				ns = append(ns, int(int64(v)))
+			}
+			data = rest
+			continue
+		}
+
+		if data, err = protowire.SkipField(data, wire); err != nil {
+			return nil, err
+		}
+	}
+	return ns, nil
+}
@@ -0,0 +1,81 @@
+// Tests for the protocol buffers decoder.
+package protobuf
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"numbers"
+	"numbers/internal/protowire"
+	"numbers/internal/testutil"
+)
+
+func TestDecode(t *testing.T) {
+	exp := []int{-1 << 40, -5, 0, 3, 300, 1 << 40}
+
+	got, err := ProtoDecoder{}.Decode(encode(exp, true))
+	if err != nil {
+		t.Fatalf("unexpected decode error: %v", err)
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("packed numbers mismatch: %s", testutil.Comp(exp, got))
+	}
+
+	// An unknown string field is skipped.
+	data := append([]byte{2<<3 | protowire.Bytes, 2, 'h', 'i'}, encode(exp, false)...)
+	got, err = ProtoDecoder{}.Decode(data)
+	if err != nil {
+		t.Fatalf("unexpected decode error: %v", err)
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("unpacked numbers mismatch: %s", testutil.Comp(exp, got))
+	}
+
+	if _, err := (ProtoDecoder{}).Decode(data[:len(data)-1]); err == nil {
+		t.Fatalf("truncated message decoded: %s", testutil.Comp("error", nil))
+	}
+}
+
+func TestDecodeResponse(t *testing.T) {
+	exp := []int{1, 2, 3, 5, 8}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		w.Write(encode(exp, true))
+	}))
+	defer ts.Close()
+
+	cfg := &numbers.Config{GetTimeout: 500 * time.Millisecond}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	var got []int
+	for ns := range numbers.ProcessURLs(ctx, cfg, []string{ts.URL}) {
+		got = append(got, ns...)
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("numbers mismatch: %s", testutil.Comp(exp, got))
+	}
+}
+
+// encode returns the NumbersMessage of ns, packed or not.
+func encode(ns []int, packed bool) []byte {
+	var values []byte
+	for _, n := range ns {
+		if !packed {
+			values = append(values, numbersField<<3|protowire.Varint)
+		}
+		values = binary.AppendUvarint(values, uint64(int64(n)))
+	}
+	if !packed {
+		return values
+	}
+	data := []byte{numbersField<<3 | protowire.Bytes}
+	data = binary.AppendUvarint(data, uint64(len(values)))
+	return append(data, values...)
+}
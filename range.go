@@ -0,0 +1,94 @@
+// This file contains range directives, which stand for a range of numbers in
+// place of a URL responding with them.
+package numbers
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// rangeDirective prefixes the URLs that are range directives, such as
+// range:1-100.
+const rangeDirective = "range:"
+
+// maxRangeNumbers caps the numbers a single range directive may expand to,
+// and those the range directives of a request may expand to together, so that
+// a request cannot make the server allocate without bound.
+const maxRangeNumbers = 100000
+
+// isRangeDirective reports whether u is a range directive rather than a URL.
+func isRangeDirective(u string) bool {
+	return strings.HasPrefix(u, rangeDirective)
+}
+
+// parseRangeDirective returns the bounds of the range directive u, which has
+// the form range:first-last, both non-negative and inclusive. An error is
+// returned if the range is malformed or spans more than maxRangeNumbers
+// numbers.
+func parseRangeDirective(u string) (int, int, error) {
+	first, last, err := parseRange(strings.TrimPrefix(u, rangeDirective))
+	if err != nil {
+		return 0, 0, err
+	}
+	if last-first >= maxRangeNumbers {
+		return 0, 0, fmt.Errorf("range spans more than %d numbers", maxRangeNumbers)
+	}
+	return first, last, nil
+}
+
+// checkRangeDirectives returns an error for the first malformed range
+// directive among urls, if any, or if the directives expand to more than
+// maxRangeNumbers numbers together.
+func checkRangeDirectives(urls []string) error {
+	total := 0
+	for _, u := range urls {
+		if !isRangeDirective(u) {
+			continue
+		}
+		var err error
+		if total, err = addRange(total, u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addRange returns total, the count of numbers of the range directives of a
+// request so far, with those of the range directive u added. An error is
+// returned if u is malformed or the total exceeds maxRangeNumbers.
+func addRange(total int, u string) (int, error) {
+	first, last, err := parseRangeDirective(u)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", u, err)
+	}
+	if total += last - first + 1; total > maxRangeNumbers {
+		return 0, fmt.Errorf("range directives span more than %d numbers", maxRangeNumbers)
+	}
+	return total, nil
+}
+
+// expandRange returns the result of the range directive u, holding every
+// number of its range that cfg keeps, without querying anything. A
+// malformed directive fails like a URL that cannot be parsed.
+func expandRange(cfg *Config, u string) urlResult {
+	res := urlResult{url: u}
+	first, last, err := parseRangeDirective(u)
+	if err != nil {
+		res.err = &url.Error{Op: "parse", URL: u, Err: err}
+		return res
+	}
+
+	numbers := make([]int, 0, last-first+1)
+	for n := first; n <= last; n++ {
+		if cfg.keep == nil || cfg.keep(n) {
+			numbers = append(numbers, n)
+		}
+	}
+	if cfg.NumberKind == FloatNumbers {
+		res.floats = intsToFloats(numbers)
+	} else {
+		res.numbers = numbers
+	}
+	return res
+}
@@ -0,0 +1,66 @@
+// Tests for range directives.
+package numbers
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestServeHTTPRangeDirective(t *testing.T) {
+	exp := []int{1, 2, 3, 4, 5, 10, 20}
+
+	cg := &countingGetter{URLGetter: staticGetter{"http://a": `{"numbers":[20,3,10]}`}}
+	ng := &NumbersGetter{Config: Config{ResponseTimeout: 500 * time.Millisecond, URLGetter: cg}}
+
+	w := serve(ng, "/numbers?u=range:1-5&u=http://a")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status mismatch: %s", comp(http.StatusOK, w.Code))
+	}
+	if got := decodeResponse(t, w.Body.Bytes()); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("numbers mismatch: %s", comp(exp, got))
+	}
+	if n := cg.count("range:1-5"); n != 0 {
+		t.Fatalf("range directive fetched: %s", comp(0, n))
+	}
+	if n := cg.count("http://a"); n != 1 {
+		t.Fatalf("fetch count mismatch: %s", comp(1, n))
+	}
+
+	// The range is filtered like fetched numbers.
+	exp = []int{3, 4, 5, 10}
+	if got := decodeResponse(t, serve(ng, "/numbers?u=range:1-5&u=http://a&min=3&max=10").Body.Bytes()); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("windowed numbers mismatch: %s", comp(exp, got))
+	}
+}
+
+func TestServeHTTPRangeDirectiveInvalid(t *testing.T) {
+	ng := &NumbersGetter{Config: Config{ResponseTimeout: 500 * time.Millisecond, URLGetter: staticGetter{}}}
+
+	for _, u := range []string{"range:", "range:5-1", "range:1", "range:a-b", "range:0-100000"} {
+		if w := serve(ng, "/numbers?u="+u); w.Code != http.StatusBadRequest {
+			t.Fatalf("status mismatch for %s: %s", u, comp(http.StatusBadRequest, w.Code))
+		}
+	}
+	if w := serve(ng, "/numbers?u=range:0-99999"); w.Code != http.StatusOK {
+		t.Fatalf("status mismatch for the largest range: %s", comp(http.StatusOK, w.Code))
+	}
+
+	// The cap applies to the directives of a request together.
+	if w := serve(ng, "/numbers?u=range:0-49999&u=range:0-49999"); w.Code != http.StatusOK {
+		t.Fatalf("status mismatch for ranges within the cap: %s", comp(http.StatusOK, w.Code))
+	}
+	if w := serve(ng, "/numbers?u=range:0-49999&u=range:0-50000"); w.Code != http.StatusBadRequest {
+		t.Fatalf("status mismatch for ranges past the cap: %s", comp(http.StatusBadRequest, w.Code))
+	}
+}
+
+func TestExpandRangeInvalid(t *testing.T) {
+	exp := reasonInvalidURL
+
+	res := expandRange(&Config{}, "range:9-0")
+	if got := classify(res.err); got != exp {
+		t.Fatalf("reason mismatch: %s", comp(exp, got))
+	}
+}
@@ -0,0 +1,126 @@
+// This file contains a URLGetter pacing the requests of another one, so that
+// no host receives more requests per second than it is configured to take.
+package numbers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimitSweepInterval is how often a RateLimitedGetter forgets the hosts
+// whose next turn has passed, which are not waited for anymore.
+const rateLimitSweepInterval = time.Minute
+
+// RateLimitedGetter is a URLGetter that waits, before every GET made by the
+// URLGetter it wraps, until the host of the URL is allowed another request.
+// Each host has its own token bucket, holding a single token refilled at the
+// rate of the host.
+type RateLimitedGetter struct {
+	getter     URLGetter
+	defaultRPS float64
+	perHost    map[string]float64
+	clock      Clock
+
+	mu sync.Mutex
+	// next is the earliest time the next request to each host can be made.
+	// The hosts whose time has passed are removed by the first wait every
+	// rateLimitSweepInterval.
+	next      map[string]time.Time
+	nextSweep time.Time
+}
+
+// NewRateLimitedGetter returns a getter limiting the requests of g to the
+// hosts in perHost to their requests per second, and to defaultRPS for other
+// hosts. A rate of zero means no limit. The hosts of perHost are matched
+// regardless of case.
+func NewRateLimitedGetter(g URLGetter, defaultRPS float64, perHost map[string]float64) *RateLimitedGetter {
+	lower := make(map[string]float64, len(perHost))
+	for host, rps := range perHost {
+		lower[strings.ToLower(host)] = rps
+	}
+	return &RateLimitedGetter{getter: g, defaultRPS: defaultRPS, perHost: lower, clock: realClock{}, next: make(map[string]time.Time)}
+}
+
+// Get waits for the turn of the host of url and GETs it with the wrapped
+// getter. It returns the error of ctx if ctx is done first.
+func (g *RateLimitedGetter) Get(ctx context.Context, url string) ([]byte, error) {
+	if err := g.wait(ctx, url); err != nil {
+		return nil, err
+	}
+	return g.getter.Get(ctx, url)
+}
+
+// GetResponse works like Get, keeping the status and headers of the response
+// if the wrapped getter reports them.
+func (g *RateLimitedGetter) GetResponse(ctx context.Context, url string) (*Response, error) {
+	if err := g.wait(ctx, url); err != nil {
+		return nil, err
+	}
+	return get(ctx, g.getter, url)
+}
+
+// Client returns the http.Client of the wrapped getter.
+func (g *RateLimitedGetter) Client() *http.Client {
+	return g.getter.Client()
+}
+
+// wait waits until the host of url can be sent a request, or until ctx is
+// done. A turn given up because ctx is done goes back to the host, unless a
+// later request already took the one after it.
+func (g *RateLimitedGetter) wait(ctx context.Context, url string) error {
+	host := urlHost(url)
+	rps, ok := g.perHost[host]
+	if !ok {
+		rps = g.defaultRPS
+	}
+	if rps <= 0 {
+		return nil
+	}
+
+	// Requests take the turns of the host in the order they ask for them.
+	g.mu.Lock()
+	now := g.clock.Now()
+	if !now.Before(g.nextSweep) {
+		g.sweep(now)
+		g.nextSweep = now.Add(rateLimitSweepInterval)
+	}
+	turn := g.next[host]
+	if turn.Before(now) {
+		turn = now
+	}
+	end := turn.Add(time.Duration(float64(time.Second) / rps))
+	g.next[host] = end
+	g.mu.Unlock()
+
+	delay := turn.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-g.clock.After(delay):
+		return nil
+	case <-ctx.Done():
+		g.mu.Lock()
+		if g.next[host].Equal(end) {
+			g.next[host] = turn
+		}
+		g.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// sweep removes the hosts whose next turn is not after now. The others are
+// moved to a new map, since maps do not shrink as their keys are deleted.
+// g.mu must be held.
+func (g *RateLimitedGetter) sweep(now time.Time) {
+	next := make(map[string]time.Time, len(g.next))
+	for host, turn := range g.next {
+		if turn.After(now) {
+			next[host] = turn
+		}
+	}
+	g.next = next
+}
@@ -0,0 +1,167 @@
+// Tests for the per host rate limits.
+package numbers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedGetterPacing(t *testing.T) {
+	g := NewRateLimitedGetter(staticGetter{
+		"http://a/1": `{"numbers":[1]}`,
+		"http://b/1": `{"numbers":[2]}`,
+		"http://c/1": `{"numbers":[3]}`,
+	}, 0, map[string]float64{"a": 20, "b": 20})
+
+	// 5 GETs of each limited host take 4 intervals of 50ms, which the hosts
+	// wait concurrently, rather than 9 if they shared a bucket. http://c is
+	// not limited.
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, url := range []string{"http://a/1", "http://b/1", "http://c/1"} {
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func(url string) {
+				defer wg.Done()
+				if _, err := g.Get(context.Background(), url); err != nil {
+					t.Errorf("unexpected error for %s: %v", url, err)
+				}
+			}(url)
+		}
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > 400*time.Millisecond {
+		t.Fatalf("pacing mismatch: %s", comp("200ms to 400ms", elapsed))
+	}
+
+	start = time.Now()
+	for i := 0; i < 5; i++ {
+		if _, err := g.Get(context.Background(), "http://c/1"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("host without a limit paced: %s", comp("< 50ms", elapsed))
+	}
+}
+
+func TestRateLimitedGetterCancel(t *testing.T) {
+	cg := &countingGetter{URLGetter: staticGetter{"http://a/1": `{"numbers":[1]}`}}
+	g := NewRateLimitedGetter(cg, 1, nil)
+
+	if _, err := g.Get(context.Background(), "http://a/1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := g.Get(ctx, "http://a/1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error mismatch: %s", comp(context.DeadlineExceeded, err))
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("wait not cut short by the context: %s", comp("< 500ms", elapsed))
+	}
+	if got := cg.count("http://a/1"); got != 1 {
+		t.Fatalf("GETs mismatch: %s", comp(1, got))
+	}
+}
+
+func TestRateLimitedGetterCancelReturnsTurn(t *testing.T) {
+	fc := newFakeClock()
+	g := NewRateLimitedGetter(staticGetter{"http://a/1": `{"numbers":[1]}`}, 1, nil)
+	g.clock = fc
+	start := fc.Now()
+
+	if _, err := g.Get(context.Background(), "http://a/1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The clock is never advanced, so the turns given up are the only ones
+	// that end the waits.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 3; i++ {
+		if _, err := g.Get(ctx, "http://a/1"); !errors.Is(err, context.Canceled) {
+			t.Fatalf("error mismatch: %s", comp(context.Canceled, err))
+		}
+	}
+	if exp, got := start.Add(time.Second), g.next["a"]; !got.Equal(exp) {
+		t.Fatalf("next turn mismatch: %s", comp(exp, got))
+	}
+
+	// A turn followed by another one taken in the meantime is kept. The
+	// turns given up above left a timer each.
+	errCh := make(chan error, 2)
+	get := func(timers int) {
+		go func() {
+			_, err := g.Get(context.Background(), "http://a/1")
+			errCh <- err
+		}()
+		fc.waitForTimers(timers)
+	}
+	get(4)
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		fc.waitForTimers(5)
+		get(6)
+		cancel()
+	}()
+	if _, err := g.Get(ctx, "http://a/1"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("error mismatch: %s", comp(context.Canceled, err))
+	}
+	if exp, got := start.Add(4*time.Second), g.next["a"]; !got.Equal(exp) {
+		t.Fatalf("next turn mismatch: %s", comp(exp, got))
+	}
+	fc.Advance(3 * time.Second)
+	for i := 0; i < 2; i++ {
+		if err := <-errCh; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestRateLimitedGetterSweep(t *testing.T) {
+	fc := newFakeClock()
+	g := NewRateLimitedGetter(staticGetter{}, 10, nil)
+	g.clock = fc
+
+	for _, host := range []string{"a", "b", "c"} {
+		g.Get(context.Background(), "http://"+host+"/1")
+	}
+	fc.Advance(rateLimitSweepInterval)
+	g.Get(context.Background(), "http://d/1")
+
+	if _, ok := g.next["d"]; len(g.next) != 1 || !ok {
+		t.Fatalf("hosts mismatch: %s", comp("d", g.next))
+	}
+}
+
+func TestRateLimitedGetterHostCase(t *testing.T) {
+	g := NewRateLimitedGetter(staticGetter{"http://example.com/1": `{"numbers":[1]}`}, 0, map[string]float64{"Example.COM": 1})
+	g.clock = newFakeClock()
+
+	if _, err := g.Get(context.Background(), "http://example.com/1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := g.Get(ctx, "http://example.com/1"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("host not limited: %s", comp(context.Canceled, err))
+	}
+}
+
+func TestConfigRateLimits(t *testing.T) {
+	cfg := &Config{DefaultHostRPS: 10, URLGetter: staticGetter{}}
+	cfg.setDefaults()
+	cfg.setDefaults()
+
+	g, ok := cfg.URLGetter.(*RateLimitedGetter)
+	if !ok {
+		t.Fatalf("getter not rate limited: %T", cfg.URLGetter)
+	}
+	if _, ok := g.getter.(staticGetter); !ok {
+		t.Fatalf("getter wrapped more than once: %T", g.getter)
+	}
+}
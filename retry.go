@@ -0,0 +1,68 @@
+// This file contains the retry loop URLs are queried with when Config allows
+// retries. Retries are only attempted while they can still complete within
+// the budget left by the context.
+package numbers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// retryFetch calls fetch for url, and calls it again up to cfg.MaxRetries
+// times while its result is worth retrying. Before every retry it waits for a
+// jittered backoff of between half and all of cfg.RetryBackoff. A retry is
+// skipped, and the last result returned at once, if the backoff plus the time
+// the last attempt took would exceed the deadline of ctx. With cfg.GetRetry,
+// the transient failures are retried according to it instead.
+func retryFetch(ctx context.Context, cfg *Config, url string, fetch func() urlResult) urlResult {
+	clock := cfg.clock()
+	if cfg.GetRetry.MaxAttempts > 1 {
+		var res urlResult
+		retryTransient(ctx, clock, cfg.GetRetry, url, func() error {
+			res = fetch()
+			return res.err
+		})
+		return res
+	}
+	for attempt := 0; ; attempt++ {
+		start := clock.Now()
+		res := fetch()
+		if !retryable(cfg, res) || attempt >= cfg.MaxRetries || ctx.Err() != nil {
+			return res
+		}
+
+		backoff := jitter(cfg.RetryBackoff)
+		if deadline, ok := ctx.Deadline(); ok && clock.Now().Add(backoff+clock.Since(start)).After(deadline) {
+			log.Printf("not retrying url %s, not enough time left before the deadline", url)
+			return res
+		}
+
+		log.Printf("retrying url %s in %v", url, backoff)
+		select {
+		case <-clock.After(backoff):
+		case <-ctx.Done():
+			return res
+		}
+	}
+}
+
+// retryable reports whether res is worth retrying: the GET failed, or it
+// returned no numbers and cfg.RetryOnEmpty is set. Responses that could not
+// be decoded are not retried, as they would most likely fail the same way.
+func retryable(cfg *Config, res urlResult) bool {
+	if res.err == nil {
+		return cfg.RetryOnEmpty && res.count() == 0
+	}
+	return !errors.Is(res.err, ErrDecode)
+}
+
+// jitter returns a random duration between half and all of d.
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d-d/2)))
+}
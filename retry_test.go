@@ -0,0 +1,162 @@
+// Tests for the retry loop.
+package numbers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetchResponseRetry(t *testing.T) {
+	exp := 3
+
+	fg := &flakyGetter{failures: 2}
+	cfg := &Config{MaxRetries: 5, RetryBackoff: time.Millisecond, URLGetter: fg}
+
+	if res := fetchResponse(context.Background(), cfg, "http://flaky"); res.err != nil {
+		t.Fatalf("unexpected error after retries: %v", res.err)
+	}
+	if got := int(fg.attempts.Load()); got != exp {
+		t.Fatalf("attempts mismatch: %s", comp(exp, got))
+	}
+}
+
+func TestFetchResponseRetryBudget(t *testing.T) {
+	fg := &flakyGetter{failures: 100, delay: 5 * time.Millisecond}
+	cfg := &Config{MaxRetries: 100, RetryBackoff: 80 * time.Millisecond, URLGetter: fg}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	res := fetchResponse(ctx, cfg, "http://flaky")
+	if !errors.Is(res.err, errFlaky) {
+		t.Fatalf("last error not returned: %s", comp(errFlaky, res.err))
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("retries not stopped before the deadline: %s", comp(nil, ctx.Err()))
+	}
+	// At most 200ms / (40ms backoff + 5ms) attempts fit in the budget.
+	if got := fg.attempts.Load(); got > 5 {
+		t.Fatalf("too many attempts: %s", comp("at most 5", got))
+	}
+}
+
+func TestFetchResponseRetryCancelledBackoff(t *testing.T) {
+	fg := &flakyGetter{failures: 100}
+	cfg := &Config{MaxRetries: 5, RetryBackoff: time.Hour, URLGetter: fg}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	res := fetchResponse(ctx, cfg, "http://flaky")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("backoff not cut short by cancellation: %s", comp("< 1s", elapsed))
+	}
+	if !errors.Is(res.err, errFlaky) {
+		t.Fatalf("last error not returned: %s", comp(errFlaky, res.err))
+	}
+	if got := fg.attempts.Load(); got != 1 {
+		t.Fatalf("attempts mismatch: %s", comp(1, got))
+	}
+}
+
+func TestFetchResponseRetryOnEmpty(t *testing.T) {
+	exp := []int{1}
+
+	fg := &flakyGetter{empties: 1}
+	cfg := &Config{MaxRetries: 2, RetryBackoff: time.Millisecond, URLGetter: fg}
+
+	if res := fetchResponse(context.Background(), cfg, "http://flaky"); res.err != nil || len(res.numbers) != 0 {
+		t.Fatalf("empty response retried by default: %s", comp("[]", res.numbers))
+	}
+
+	fg.attempts.Store(0)
+	cfg.RetryOnEmpty = true
+	res := fetchResponse(context.Background(), cfg, "http://flaky")
+	if res.err != nil || !reflect.DeepEqual(exp, res.numbers) {
+		t.Fatalf("empty response not retried: %s", comp(exp, res.numbers))
+	}
+	if got := fg.attempts.Load(); got != 2 {
+		t.Fatalf("attempts mismatch: %s", comp(2, got))
+	}
+}
+
+func TestFetchResponseRetryOnEmptyFloats(t *testing.T) {
+	expFetches := 1
+
+	cg := &countingGetter{URLGetter: staticGetter{"http://a": `{"numbers":[1.5]}`}}
+	cfg := &Config{MaxRetries: 3, RetryBackoff: time.Millisecond, RetryOnEmpty: true, NumberKind: FloatNumbers, URLGetter: cg}
+
+	if res := fetchResponse(context.Background(), cfg, "http://a"); res.err != nil || len(res.floats) != 1 {
+		t.Fatalf("floats mismatch: %s", comp("[1.5]", res.floats))
+	}
+	if got := cg.count("http://a"); got != expFetches {
+		t.Fatalf("fetch count mismatch: %s", comp(expFetches, got))
+	}
+}
+
+func TestFetchResponsePerURLBudget(t *testing.T) {
+	exp := []int{1}
+
+	// The three attempts, with their backoffs, take longer than GetTimeout
+	// but fit in the budget of the URL.
+	fg := &flakyGetter{failures: 2, delay: 5 * time.Millisecond}
+	cfg := &Config{
+		GetTimeout:   10 * time.Millisecond,
+		MaxRetries:   5,
+		RetryBackoff: 20 * time.Millisecond,
+		PerURLBudget: 200 * time.Millisecond,
+		URLGetter:    fg,
+	}
+	res := fetchResponse(context.Background(), cfg, "http://flaky")
+	if res.err != nil || !reflect.DeepEqual(exp, res.numbers) {
+		t.Fatalf("numbers mismatch within the budget: %s", comp(exp, res.numbers))
+	}
+
+	// The global deadline caps the budget, leaving no time for a retry.
+	fg = &flakyGetter{failures: 2, delay: 5 * time.Millisecond}
+	cfg.URLGetter = fg
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if res := fetchResponse(ctx, cfg, "http://flaky"); !errors.Is(res.err, errFlaky) {
+		t.Fatalf("budget not capped by the global deadline: %s", comp(errFlaky, res.err))
+	}
+	if got := fg.attempts.Load(); got != 1 {
+		t.Fatalf("attempts mismatch past the global deadline: %s", comp(1, got))
+	}
+}
+
+var errFlaky = errors.New("flaky failure")
+
+// flakyGetter fails its first GETs, after the delay, then responds with no
+// numbers for the following empties GETs, and with a single number after.
+type flakyGetter struct {
+	failures int64
+	empties  int64
+	delay    time.Duration
+	attempts atomic.Int64
+}
+
+func (f *flakyGetter) Get(ctx context.Context, url string) ([]byte, error) {
+	time.Sleep(f.delay)
+	attempt := f.attempts.Add(1)
+	if attempt <= f.failures {
+		return nil, errFlaky
+	}
+	if attempt <= f.failures+f.empties {
+		return []byte(`{"numbers":[]}`), nil
+	}
+	return []byte(`{"numbers":[1]}`), nil
+}
+
+func (f *flakyGetter) Client() *http.Client {
+	return nil
+}
@@ -0,0 +1,67 @@
+// This file contains a self-test running the whole pipeline, scheduling,
+// decoding and merging, against canned responses. It can be run at startup
+// or as a deep health check.
+package numbers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+)
+
+// selfTestResponses are the canned responses of the self-test, keyed by URL.
+var selfTestResponses = map[string]string{
+	"selftest://a": `{"numbers":[3,1,2]}`,
+	"selftest://b": `{"numbers":[5,2,4,4]}`,
+	"selftest://c": `{"numbers":[]}`,
+}
+
+// selfTestNumbers are the merged numbers selfTestResponses must result in.
+var selfTestNumbers = []int{1, 2, 3, 4, 5}
+
+// SelfTest runs ProcessURLs with both strategies against canned in-memory
+// responses, and checks that their merged numbers are the expected ones. It
+// fails if ctx is done before the responses are processed.
+func SelfTest(ctx context.Context) error {
+	return selfTest(ctx, selfTestGetter{})
+}
+
+// selfTest works like SelfTest with the responses of g.
+func selfTest(ctx context.Context, g URLGetter) error {
+	urls := []string{"selftest://a", "selftest://b", "selftest://c"}
+	for _, strategy := range []Strategy{FixedPool, OnDemand} {
+		cfg := &Config{NumGoRoutines: 2, Strategy: strategy, URLGetter: g}
+
+		var results []urlResult
+		for numbers := range ProcessURLs(ctx, cfg, urls) {
+			results = append(results, urlResult{numbers: numbers})
+		}
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("self-test interrupted: %w", err)
+		}
+		if len(results) != len(urls) {
+			return fmt.Errorf("self-test with strategy %d: %d of %d URLs processed", strategy, len(results), len(urls))
+		}
+		if got := mergeUnique(results); !reflect.DeepEqual(got, selfTestNumbers) {
+			return fmt.Errorf("self-test with strategy %d: merged numbers %v, expected %v", strategy, got, selfTestNumbers)
+		}
+	}
+	return nil
+}
+
+// selfTestGetter serves selfTestResponses.
+type selfTestGetter struct{}
+
+func (selfTestGetter) Get(ctx context.Context, url string) ([]byte, error) {
+	body, ok := selfTestResponses[url]
+	if !ok {
+		return nil, errors.New("no canned response for " + url)
+	}
+	return []byte(body), nil
+}
+
+func (selfTestGetter) Client() *http.Client {
+	return nil
+}
@@ -0,0 +1,32 @@
+// Tests for the self-test.
+package numbers
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSelfTest(t *testing.T) {
+	if err := SelfTest(context.Background()); err != nil {
+		t.Fatalf("unexpected self-test error: %v", err)
+	}
+
+	// A getter responding with the wrong numbers, or failing, is caught.
+	broken := staticGetter{
+		"selftest://a": `{"numbers":[3,1,2]}`,
+		"selftest://b": `{"numbers":[5,2,4,6]}`,
+		"selftest://c": `{"numbers":[]}`,
+	}
+	if err := selfTest(context.Background(), broken); err == nil {
+		t.Fatal("expected an error for wrong numbers")
+	}
+	if err := selfTest(context.Background(), staticGetter{}); err == nil {
+		t.Fatal("expected an error for a failing getter")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := SelfTest(ctx); err == nil {
+		t.Fatal("expected an error for a cancelled context")
+	}
+}
@@ -7,36 +7,925 @@ package numbers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// watchdogMargin is the time added to ResponseTimeout and FlushGrace before
+// the watchdog of NumbersGetter gives up on a response.
+const watchdogMargin = 100 * time.Millisecond
+
 // NumbersGetter is the exported type that handles incoming requests.
 // It is this functions responsibility to range over all the number
 // slices it recieves from ProcessURLs, collect them, and sort them.
 // It satisfies the http.ServeHTTP interface.
 type NumbersGetter struct {
 	Config
+
+	setup   sync.Once
+	flights flightGroup
+
+	// workers overrides NumGoRoutines for new requests once set through the
+	// TuneHandler. Zero keeps NumGoRoutines.
+	workers atomic.Int64
+
+	// drain is closed by Drain to soft-cancel the requests in flight.
+	drainMu sync.Mutex
+	drain   chan struct{}
+}
+
+// Drain cuts short the requests in flight: the URLs they still query are
+// cancelled, and their responses are sent with the numbers collected so far
+// and an X-Partial: true header. It is meant to be called when the server is asked
+// to shut down, before http.Server.Shutdown gives the responses time to be
+// written.
+func (ng *NumbersGetter) Drain() {
+	ng.drainMu.Lock()
+	defer ng.drainMu.Unlock()
+	select {
+	case <-ng.drainCh():
+	default:
+		close(ng.drain)
+	}
+}
+
+// drainCh returns the channel closed by Drain. drainMu must be held.
+func (ng *NumbersGetter) drainCh() chan struct{} {
+	if ng.drain == nil {
+		ng.drain = make(chan struct{})
+	}
+	return ng.drain
+}
+
+// draining returns a channel that is closed once Drain is called.
+func (ng *NumbersGetter) draining() <-chan struct{} {
+	ng.drainMu.Lock()
+	defer ng.drainMu.Unlock()
+	return ng.drainCh()
+}
+
+// drained reports whether Drain was called.
+func (ng *NumbersGetter) drained() bool {
+	select {
+	case <-ng.draining():
+		return true
+	default:
+		return false
+	}
+}
+
+// drainable returns a copy of ctx that is cancelled once Drain is called.
+func (ng *NumbersGetter) drainable(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	drain := ng.draining()
+	go func() {
+		select {
+		case <-drain:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
 }
 
 // ServeHTTP handles incoming requests.
 func (ng *NumbersGetter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		log.Fatal("invalid request form")
+		http.Error(w, "invalid request form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The URLs of POST requests are only known once their body is read, and
+	// are not subject to the options that need them upfront.
+	post := r.Method == "POST"
+	urls, err := expandTemplates(r.Form["u"], r.Form.Get("range"))
+	if err == nil {
+		err = checkRangeDirectives(urls)
+	}
+	if err == nil && post && len(urls) > 0 {
+		err = errors.New("the URLs of POST requests must be in the body")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !post {
+		ng.logURLs(urls)
+	}
+
+	opts, err := parseOptions(r, urls, &ng.Config)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Defaults are set up once so that concurrent requests do not race on them.
+	ng.setup.Do(ng.setDefaults)
+
+	// The request keeps the settings it started with even if they are tuned
+	// while it is served.
+	cfg := ng.requestConfig()
+	// The set operations need every number the URLs returned, so the window
+	// only applies to their outcome.
+	if opts.op == "" {
+		cfg.keep = opts.window()
+	}
+	cfg.KeepDuplicates = opts.keepDuplicates
+	cfg.priorities = opts.priorities
+	if opts.samplesOnArrival() {
+		cfg.sample, cfg.sampleSeed = opts.sample, opts.seed
+	}
+	// Once the numbers are collected, or collecting them panicked, the
+	// results left are dropped so that the URLs in flight do not block.
+	cfg.abandon = make(chan struct{})
+	if opts.stream {
+		defer close(cfg.abandon)
+		ng.serveStream(w, r, cfg, opts, urls)
+		return
+	}
+
+	// The numbers are collected in their own goroutine so that the watchdog
+	// below can complete the response even if collecting hangs past its
+	// deadline. Its channels are buffered so the goroutine never blocks on
+	// them. A panic while collecting is raised again by the handler, for the
+	// server to recover from it like from those of the handler itself.
+	responseCh := make(chan *collection, 1)
+	panicCh := make(chan interface{}, 1)
+	errCh := make(chan error, 1)
+	cfg.merged = &mergedResults{}
+	// The body of POST requests is decoded apart from the collection, so
+	// that the watchdog can stop it before the handler returns.
+	var stream *urlStream
+	var streamCtx context.Context
+	stopStream := func() {}
+	if post {
+		ctx, cancel := withTimeout(ng.requestContext(r, opts, r.Context()), cfg.clock(), ng.ResponseTimeout)
+		ctx, cancelDrain := ng.drainable(ctx)
+		stopStream = func() {
+			cancelDrain()
+			cancel()
+		}
+		defer stopStream()
+
+		body := r.Body
+		if ng.MaxRequestBytes > 0 {
+			body = http.MaxBytesReader(w, r.Body, ng.MaxRequestBytes)
+		}
+		stream = streamURLs(ctx, body, ng.MaxHosts, ng.MaxURLs)
+		streamCtx = ctx
+	}
+	go func() {
+		defer func() {
+			close(cfg.abandon)
+			if p := recover(); p != nil {
+				panicCh <- p
+			}
+		}()
+		if post {
+			responseCh <- collectStream(streamCtx, cfg, stream.ch)
+			return
+		}
+		if scope, shared := ng.requestKey(r); ng.CoalesceRequests && shared {
+			// Requests are only coalesced with those querying the URLs the
+			// same way.
+			key := cfg.cacheKey(urls) + "\nmaxBytes=" + strconv.FormatInt(opts.maxBytes, 10) + "\nwindow=" + opts.windowKey() + "\nduplicates=" + strconv.FormatBool(opts.keepDuplicates) + "\nsample=" + strconv.Itoa(cfg.sample) + "," + strconv.FormatInt(cfg.sampleSeed, 10) + "\nrequest=" + scope
+			if cfg.CacheKeyFunc != nil && opts.namesURLs() {
+				// The results are those of the URLs of the request that
+				// queried them, which CacheKeyFunc may map other URLs to.
+				key += "\nurls=" + flightKey(urls)
+			}
+			c, err := ng.flights.do(key, func() *collection {
+				// The shared work must not be cancelled along with the request
+				// that happened to start it.
+				ctx, cancel := withTimeout(ng.requestContext(r, opts, context.Background()), cfg.clock(), ng.ResponseTimeout)
+				defer cancel()
+				ctx, cancelDrain := ng.drainable(ctx)
+				defer cancelDrain()
+				return collect(ctx, cfg, urls)
+			})
+			if err != nil {
+				errCh <- err
+				return
+			}
+			responseCh <- c
+			return
+		}
+		ctx, cancel := withTimeout(ng.requestContext(r, opts, r.Context()), cfg.clock(), ng.ResponseTimeout)
+		defer cancel()
+		ctx, cancelDrain := ng.drainable(ctx)
+		defer cancelDrain()
+		responseCh <- collect(ctx, cfg, urls)
+	}()
+
+	var c *collection
+	select {
+	case c = <-responseCh:
+	case p := <-panicCh:
+		panic(p)
+	case err := <-errCh:
+		log.Printf("error collecting the numbers of %v: %v", urls, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	case <-cfg.clock().After(ng.ResponseTimeout + ng.FlushGrace + watchdogMargin):
+		log.Printf("warning: response for %v not ready past its deadline, responding with the results merged so far", urls)
+		if stream != nil {
+			// A read blocked on a stalled client is cut short by the read
+			// deadline, where the connection supports one.
+			stopStream()
+			http.NewResponseController(w).SetReadDeadline(time.Now())
+			stream.wait()
+		}
+		c = cfg.merged.collection(cfg, stream != nil)
+	}
+	if stream != nil && !c.abandoned {
+		if err := stream.wait(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// The URLs of the body are only gathered back, from their
+		// results, if they are to be logged.
+		if ng.debugLogger() != nil {
+			ng.logURLs(c.streamedURLs())
+		}
+	}
+
+	if ng.StatsHook != nil {
+		ng.StatsHook(c.stats())
+	}
+	if cfg.NumberKind == FloatNumbers {
+		ng.writeFloats(w, c, opts, urls)
+		return
+	}
+
+	response := c.numbers
+	switch opts.op {
+	case opSymDiff:
+		response = symmetricDifference(c.results, urls[0], urls[1])
+	case opComplement:
+		response = complement(c.results, ng.MaxValue)
+	}
+	if keep := opts.window(); opts.op != "" && keep != nil {
+		response = filterNumbers(response, keep)
+	}
+	if opts.sample > 0 {
+		response = sampleNumbers(response, opts.sample, opts.seed)
+	}
+
+	// response stays sorted for the options that rely on it, and the numbers
+	// are only written in the requested order.
+	ordered := response
+	if opts.sort == sortFreq {
+		ordered = sortByFrequency(c.results, response)
+	}
+	if opts.order == OrderDesc {
+		ordered = reversed(response)
+	}
+	if opts.encoding == encodingDelta {
+		ordered = deltaEncode(ordered)
+	}
+
+	status := http.StatusOK
+	if len(response) == 0 && ng.EmptyStatus != 0 {
+		status = ng.EmptyStatus
+	}
+	if ng.drained() {
+		w.Header().Set("X-Partial", "true")
+	}
+	if c.capped {
+		w.Header().Set("X-Memory-Capped", "true")
+	}
+	if status == http.StatusNoContent {
+		w.WriteHeader(status)
+		return
+	}
+	// Plain results are encoded following the Accept header, which caches
+	// must take into account.
+	contentType, encoder := encoderFor(r.Header.Get("Accept"))
+	if opts.plain() {
+		w.Header().Add("Vary", "Accept")
+	}
+	// Only complete results get an entity tag, so that clients do not keep
+	// reusing a partial one.
+	if opts.plain() && !ng.drained() && !c.timedOut() && !c.capped {
+		mediaType := contentType
+		if mediaType == "" {
+			mediaType = "application/json"
+		}
+		etag := etagFor(ordered, mediaType)
+		w.Header().Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	// The count of numbers is also sent as a trailer once the body is
+	// written, so that clients reading the body as it comes learn it at the
+	// end without counting.
+	w.Header().Set("Trailer", "X-Final-Count")
+	defer w.Header().Set("X-Final-Count", strconv.Itoa(len(response)))
+
+	if contentType == ndjsonContentType && opts.plain() {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(status)
+		if err := writeNDJSON(w, ordered, ng.StreamChunkSize); err != nil {
+			log.Printf("error writing NDJSON response: %v", err)
+		}
+		return
+	}
+	if encoder != nil && opts.plain() {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(status)
+		if err := encoder.Encode(w, ordered); err != nil {
+			log.Printf("error encoding %s response: %v", contentType, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	resp := &numbersResponse{Numbers: ordered}
+	ng.describe(resp, c, opts, urls)
+	if opts.digest {
+		resp.Digest = digestNumbers(response)
+	}
+	if opts.median {
+		resp.Median = ng.median(c, opts, response)
+	}
+	if opts.provenance {
+		resp.Provenance = provenance(c.results, response)
+	}
+
+	var body interface{} = resp
+	if opts.bucketBy > 0 {
+		body = bucketNumbers(response, opts.bucketBy)
+	}
+	enc := json.NewEncoder(w)
+	if opts.pretty {
+		enc.SetIndent("", "  ")
+	}
+	enc.Encode(body)
+}
+
+// logURLs logs the input URLs of a request at the debug level, without their
+// query strings with RedactURLs.
+func (ng *NumbersGetter) logURLs(urls []string) {
+	logger := ng.debugLogger()
+	if logger == nil {
+		return
+	}
+	if ng.RedactURLs {
+		redacted := make([]string, len(urls))
+		for i, u := range urls {
+			redacted[i] = redactURL(u)
+		}
+		urls = redacted
+	}
+	logger.Debug("input URLs", "urls", urls)
+}
+
+// debugLogger returns the logger of ng, or nil if it does not log at the
+// debug level.
+func (ng *NumbersGetter) debugLogger() *slog.Logger {
+	logger := ng.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if !logger.Enabled(context.Background(), slog.LevelDebug) {
+		return nil
+	}
+	return logger
+}
+
+// redactURL returns raw without its query string, which is replaced by a
+// marker if there was one.
+func redactURL(raw string) string {
+	if i := strings.IndexByte(raw, '?'); i >= 0 {
+		return raw[:i] + "?REDACTED"
+	}
+	return raw
+}
+
+// describe fills the parts of resp that describe how the URLs of c did
+// rather than the numbers, as asked for by opts.
+func (ng *NumbersGetter) describe(resp *numbersResponse, c *collection, opts *requestOptions, urls []string) {
+	if opts.debug {
+		resp.Debug = c.reports(urls)
+	}
+	if opts.verbose {
+		resp.URLs = c.statuses()
+	}
+	if opts.includeErrors {
+		resp.Errors = c.errors()
+		if len(resp.Errors) > 0 {
+			resp.Replay = &replayRequest{URLs: make([]string, len(resp.Errors))}
+			for i, e := range resp.Errors {
+				resp.Replay.URLs[i] = e.URL
+			}
+		}
+	}
+	if opts.summary {
+		resp.Summary = c.summary()
+	}
+	if opts.stats {
+		stats := c.stats()
+		resp.Stats = &stats
+	}
+	if ng.InlineTimeoutWarning && c.timedOut() || c.abandoned {
+		resp.Warning = timeoutWarning
+	}
+}
+
+// median returns the median of the response numbers of c, or nil if there
+// are none. The bitset path applies to the de-duplicated union of the results
+// only, so that it is not taken once the response was transformed by another
+// option or holds duplicates.
+func (ng *NumbersGetter) median(c *collection, opts *requestOptions, response []int) *float64 {
+	m, ok := 0.0, false
+	if ng.MaxValue > 0 && opts.op == "" && opts.sample == 0 && c.unique == len(c.numbers) {
+		m, ok = bitsetMedian(c.results, ng.MaxValue)
+	}
+	if !ok {
+		m, ok = sortedMedian(response)
+	}
+	if !ok {
+		return nil
+	}
+	return &m
+}
+
+// requestConfig returns a copy of the Config of ng with the settings tuned at
+// runtime applied.
+func (ng *NumbersGetter) requestConfig() *Config {
+	cfg := ng.Config
+	if n := ng.workers.Load(); n > 0 {
+		cfg.NumGoRoutines = int(n)
+	}
+	return &cfg
+}
+
+// requestContext returns ctx with the values the URLs of r must be queried
+// with: those of the request options and of the ContextEnricher, if any.
+// Responses are cached apart for each request key.
+func (ng *NumbersGetter) requestContext(r *http.Request, opts *requestOptions, ctx context.Context) context.Context {
+	if opts.maxBytes > 0 {
+		ctx = withMaxResponseBytes(ctx, opts.maxBytes)
+	}
+	if ng.ContextEnricher == nil {
+		return ctx
+	}
+	scope, shared := ng.requestKey(r)
+	if !shared {
+		ctx = withoutCache(ctx)
+	} else {
+		ctx = withCacheScope(ctx, scope)
+	}
+	return ng.ContextEnricher(r, ctx)
+}
+
+// requestKey returns the key of the values the ContextEnricher adds for r,
+// and whether r may share work and cached responses with the requests of the
+// same key. It may not if there is a ContextEnricher but no RequestKey.
+func (ng *NumbersGetter) requestKey(r *http.Request) (string, bool) {
+	if ng.ContextEnricher == nil {
+		return "", true
+	}
+	if ng.RequestKey == nil {
+		return "", false
 	}
+	return ng.RequestKey(r), true
+}
+
+// numbersResponse is the JSON body of a response.
+type numbersResponse struct {
+	Numbers []int `json:"Numbers"`
+
+	// Debug reports the outcome of every URL, when asked for with ?debug=1.
+	Debug []urlReport `json:"debug,omitempty"`
+
+	// URLs breaks the result down by URL, when asked for with ?verbose=1.
+	URLs []urlStatus `json:"urls,omitempty"`
+
+	// Errors lists the URLs that failed, when asked for with ?includeErrors=1.
+	Errors []urlError `json:"errors,omitempty"`
+
+	// Replay is the body to POST to the replay endpoint to query the URLs
+	// that failed again, along with Errors. See ReplayHandler.
+	Replay *replayRequest `json:"replay,omitempty"`
+
+	// Digest is the SHA-256 digest of Numbers, when asked for with ?digest=1.
+	// It lets clients detect changes to the result without comparing it.
+	Digest string `json:"digest,omitempty"`
+
+	// Summary counts the URLs by outcome, when asked for with ?summary=1.
+	Summary *urlSummary `json:"summary,omitempty"`
+
+	// Median is the median of Numbers, when asked for with ?median=1 and
+	// Numbers is not empty.
+	Median *float64 `json:"median,omitempty"`
+
+	// Stats are the stats of the request, when asked for with ?stats=1.
+	Stats *RequestStats `json:"stats,omitempty"`
+
+	// Provenance counts the URLs returning each of the numbers, in
+	// ascending order, when asked for with ?provenance=1.
+	Provenance []numberSources `json:"provenance,omitempty"`
+
+	// Warning tells clients that cannot read headers that the result is
+	// partial, with Config.InlineTimeoutWarning or when the numbers were not
+	// merged in time.
+	Warning string `json:"warning,omitempty"`
+}
+
+// timeoutWarning is the warning of the responses missing the numbers of URLs
+// that timed out.
+const timeoutWarning = "partial result due to timeout"
+
+// urlSummary is the count of URLs by outcome as reported to clients.
+type urlSummary struct {
+	Succeeded int `json:"succeeded"`
+	TimedOut  int `json:"timed_out"`
+	Failed    int `json:"failed"`
+
+	// Skipped counts the URLs not queried for lack of time, and those of
+	// the hosts with an open circuit breaker.
+	Skipped int `json:"skipped"`
+	Blocked int `json:"blocked"`
+}
+
+// RequestStats are the stats of the numbers collected for a request. See
+// Config.StatsHook.
+type RequestStats struct {
+	// URLs is the number of URLs with a result.
+	URLs int `json:"urls"`
+
+	// Received is the number of numbers the URLs responded with, duplicates
+	// included.
+	Received int `json:"received"`
+
+	// Unique is the number of numbers kept once de-duplicated, or sampled
+	// when they are sampled as the URLs respond.
+	Unique int `json:"unique"`
+
+	// DedupRatio is Received divided by Unique: how many times every number
+	// kept was received on average. It is zero if no number was received.
+	DedupRatio float64 `json:"dedup_ratio"`
+}
+
+// replayRequest is a list of URLs to query again, in the form POST requests
+// take.
+type replayRequest struct {
+	URLs []string `json:"urls"`
+}
+
+// urlError is a failed URL as reported to clients.
+type urlError struct {
+	URL    string `json:"url"`
+	Reason string `json:"reason"`
+}
+
+// urlReport is the outcome of querying a URL as reported to clients.
+type urlReport struct {
+	URL string `json:"url"`
+
+	// Indices are the positions of the URL in the request, which may differ
+	// from the order of the reports.
+	Indices []int `json:"indices"`
+
+	// Status is the HTTP status code the URL responded with. It is zero if no
+	// response was received, in which case Error holds the network error.
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// The statuses of URLs as reported to clients.
+const (
+	statusOK      = "ok"
+	statusTimeout = "timeout"
+	statusError   = "error"
+)
+
+// urlStatus is how a URL contributed to the response as reported to clients.
+type urlStatus struct {
+	URL string `json:"url"`
+
+	// Status is ok, timeout for the URLs that ran out of time, including
+	// those skipped for lack of it, or error.
+	Status string `json:"status"`
+
+	// Count is the number of numbers the URL responded with.
+	Count int `json:"count"`
+
+	// LatencyMS is how long the URL took to query, in milliseconds.
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// collection holds the outcome of querying the URLs of a request. It may be
+// shared by coalesced requests and must not be modified once collected.
+type collection struct {
+	// numbers are the sorted, de-duplicated numbers the URLs responded with,
+	// or all of them with Config.KeepDuplicates.
+	numbers []int
+
+	// floats are the numbers of the URLs with FloatNumbers, in place of
+	// numbers.
+	floats []float64
+
+	// unique is the number of distinct numbers in numbers or floats, which
+	// hold duplicates with Config.KeepDuplicates.
+	unique int
+
+	// results holds the result of every URL, in the order they completed.
+	results []urlResult
+
+	// received is the number of numbers in results, duplicates included.
+	received int
+
+	// capped tells that numbers were dropped to stay within
+	// Config.PerRequestMemoryCeiling.
+	capped bool
+
+	// streamed tells that the results are those of the URLs of a POST body,
+	// one per position in the body.
+	streamed bool
+
+	// abandoned tells that merging the results did not end before the
+	// watchdog of NumbersGetter, which responded with those merged so far.
+	abandoned bool
+}
+
+// mergedResults is a copy of the results merged so far by mergeResults,
+// which may be read while it merges more.
+type mergedResults struct {
+	mu      sync.Mutex
+	results []urlResult
+}
+
+// add records r as merged.
+func (m *mergedResults) add(r urlResult) {
+	m.mu.Lock()
+	m.results = append(m.results, r)
+	m.mu.Unlock()
+}
+
+// collection merges the results recorded so far the way cfg does, into an
+// abandoned collection.
+func (m *mergedResults) collection(cfg *Config, streamed bool) *collection {
+	m.mu.Lock()
+	resultCh := make(chan urlResult, len(m.results))
+	for _, r := range m.results {
+		resultCh <- r
+	}
+	m.mu.Unlock()
+	close(resultCh)
+
+	partial := *cfg
+	partial.merged = nil
+	c := mergeResults(&partial, resultCh)
+	c.streamed, c.abandoned = streamed, true
+	return c
+}
+
+// numberSize is the estimated memory, in bytes, a collected number takes.
+const numberSize = 8
+
+// stats returns the stats of c.
+func (c *collection) stats() RequestStats {
+	s := RequestStats{URLs: len(c.results), Received: c.received, Unique: c.unique}
+	if s.Unique > 0 {
+		s.DedupRatio = float64(s.Received) / float64(s.Unique)
+	}
+	return s
+}
 
-	urls := r.Form["u"]
-	log.Print("Input URLs: ", urls)
+// reports returns the report of every URL result in c, for a request for
+// urls.
+func (c *collection) reports(urls []string) []urlReport {
+	indices := make(map[string][]int)
+	for i, url := range urls {
+		indices[url] = append(indices[url], i)
+	}
+
+	reports := make([]urlReport, 0, len(c.results))
+	for _, r := range c.results {
+		report := urlReport{URL: r.url, Indices: indices[r.url], Status: r.status}
+		if c.streamed {
+			report.Indices = []int{r.index}
+		}
+		if r.err != nil {
+			report.Error = r.err.Error()
+		}
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// statuses returns the status of every URL result in c, in the order the
+// results were received.
+func (c *collection) statuses() []urlStatus {
+	statuses := make([]urlStatus, 0, len(c.results))
+	for _, r := range c.results {
+		s := urlStatus{URL: r.url, Status: statusOK, Count: r.count(), LatencyMS: float64(r.latency) / float64(time.Millisecond)}
+		if r.err != nil {
+			s.Status = statusError
+			if reason := classify(r.err); reason == reasonTimeout || reason == reasonSkipped {
+				s.Status = statusTimeout
+			}
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses
+}
+
+// failure returns the error of c if every one of its URLs failed: ErrAllFailed,
+// wrapped with the error of the first URL to fail. It returns nil if c has a
+// successful result, or no result at all.
+func (c *collection) failure() error {
+	var firstErr error
+	for _, r := range c.results {
+		if r.err == nil {
+			return nil
+		}
+		if firstErr == nil {
+			firstErr = r.err
+		}
+	}
+	if firstErr != nil {
+		return fmt.Errorf("%w: %w", ErrAllFailed, firstErr)
+	}
+	return nil
+}
+
+// errors returns the failed URL results in c.
+func (c *collection) errors() []urlError {
+	var errs []urlError
+	for _, r := range c.results {
+		if r.err != nil {
+			errs = append(errs, urlError{URL: r.url, Reason: classify(r.err)})
+		}
+	}
+	return errs
+}
+
+// timedOut reports whether any URL result in c timed out or was skipped for
+// lack of time, or whether c was abandoned.
+func (c *collection) timedOut() bool {
+	if c.abandoned {
+		return true
+	}
+	for _, r := range c.results {
+		if r.err != nil {
+			if reason := classify(r.err); reason == reasonTimeout || reason == reasonSkipped {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// summary returns the count of the URL results in c by outcome.
+func (c *collection) summary() *urlSummary {
+	s := &urlSummary{}
+	for _, r := range c.results {
+		if r.err == nil {
+			s.Succeeded++
+			continue
+		}
+		switch classify(r.err) {
+		case reasonTimeout:
+			s.TimedOut++
+		case reasonSkipped, reasonOpen:
+			s.Skipped++
+		case reasonBlocked:
+			s.Blocked++
+		default:
+			s.Failed++
+		}
+	}
+	return s
+}
+
+// collect queries the URLs with cfg and returns the sorted, de-duplicated numbers
+// they responded with, along with the result of every URL. Both NumbersGetter
+// and MergeNumbers merge the numbers with it, so that they return the same
+// numbers for the same URLs.
+func collect(ctx context.Context, cfg *Config, urls []string) *collection {
+	return mergeResults(cfg, processResults(ctx, cfg, urls))
+}
 
-	ctx, cancel := context.WithTimeout(r.Context(), ng.ResponseTimeout)
-	defer cancel()
+// mergeResults returns the collection of the results received over resultCh.
+func mergeResults(cfg *Config, resultCh <-chan urlResult) *collection {
+	c := &collection{}
+	var sample *reservoir
+	if cfg.sample > 0 {
+		sample = newReservoir(cfg.sample, cfg.sampleSeed)
+	}
+	limit := int(cfg.PerRequestMemoryCeiling / numberSize)
+	for r := range resultCh {
+		if cfg.merged != nil {
+			cfg.merged.add(r)
+		}
+		if sample != nil {
+			for _, n := range r.numbers {
+				sample.add(n)
+			}
+			r.sampled, r.numbers = len(r.numbers), nil
+		} else if cfg.PerRequestMemoryCeiling > 0 && c.received+r.count() > limit {
+			r.truncate(limit - c.received)
+			c.capped = true
+		}
+		c.results = append(c.results, r)
+		c.received += r.count()
+	}
 
-	numbersCh := ProcessURLs(ctx, &ng.Config, urls)
+	switch {
+	case sample != nil:
+		c.numbers = sample.numbers()
+		c.unique = len(c.numbers)
+	case cfg.NumberKind == FloatNumbers && cfg.KeepDuplicates:
+		c.floats = mergeAllFloats(c.results, c.received)
+		c.unique = countDistinct(len(c.floats), func(i int) bool { return c.floats[i] == c.floats[i-1] })
+	case cfg.NumberKind == FloatNumbers:
+		c.floats = mergeUniqueFloats(c.results)
+		c.unique = len(c.floats)
+	case cfg.KeepDuplicates:
+		c.numbers = mergeAll(c.results, c.received)
+		c.unique = countDistinct(len(c.numbers), func(i int) bool { return c.numbers[i] == c.numbers[i-1] })
+	case cfg.AssumeDisjoint:
+		c.numbers = mergeDisjoint(c.results)
+		c.unique = len(c.numbers)
+	case cfg.AssumeSorted:
+		c.numbers = mergeSorted(c.results)
+		c.unique = len(c.numbers)
+	default:
+		c.numbers = mergeUnique(c.results)
+		c.unique = len(c.numbers)
+	}
+	return c
+}
+
+// collectStream works like collect, with the URLs received over urls as they
+// come.
+func collectStream(ctx context.Context, cfg *Config, urls <-chan streamedURL) *collection {
+	resultCh := make(chan urlResult)
+	go processURLStream(ctx, cfg, urls, resultCh)
+	c := mergeResults(cfg, resultCh)
+	c.streamed = true
+	return c
+}
+
+// streamedURLs returns the URLs of the results of a POST body, in the order
+// of the body.
+func (c *collection) streamedURLs() []string {
+	n := 0
+	for _, r := range c.results {
+		n = max(n, r.index+1)
+	}
+	urls := make([]string, n)
+	for _, r := range c.results {
+		urls[r.index] = r.url
+	}
+	return urls
+}
 
+// mergeAll returns the sorted numbers of the results, duplicates included.
+// total is their count, for the slice to be allocated once.
+func mergeAll(results []urlResult, total int) []int {
+	response := make([]int, 0, total)
+	for _, r := range results {
+		response = append(response, r.numbers...)
+	}
+	sort.Ints(response)
+	return response
+}
+
+// countDistinct returns the number of distinct values of a sorted slice of n
+// values, where same reports whether the value at i equals the one before.
+func countDistinct(n int, same func(i int) bool) int {
+	distinct := min(n, 1)
+	for i := 1; i < n; i++ {
+		if !same(i) {
+			distinct++
+		}
+	}
+	return distinct
+}
+
+// mergeUnique returns the sorted, de-duplicated numbers of the results.
+func mergeUnique(results []urlResult) []int {
 	numbersMap := make(map[int]bool)
-	for ns := range numbersCh {
-		for _, n := range ns {
+	for _, r := range results {
+		for _, n := range r.numbers {
 			numbersMap[n] = true
 		}
 	}
@@ -47,9 +936,30 @@ func (ng *NumbersGetter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	sort.Ints(response)
+	return response
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+// mergeSorted returns the sorted, de-duplicated numbers of the results,
+// expecting each of them to be sorted already. Those that are not are sorted
+// in place first, so that the merge stays correct.
+func mergeSorted(results []urlResult) []int {
+	slices := make([][]int, 0, len(results))
+	for _, r := range results {
+		if !sort.IntsAreSorted(r.numbers) {
+			sort.Ints(r.numbers)
+		}
+		slices = append(slices, r.numbers)
+	}
+	return kWayMergeUnique(slices)
+}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{"Numbers": response})
+// mergeDisjoint returns the sorted numbers of the results, assuming they do
+// not overlap. Each result is sorted in place and they are then merged.
+func mergeDisjoint(results []urlResult) []int {
+	slices := make([][]int, 0, len(results))
+	for _, r := range results {
+		sort.Ints(r.numbers)
+		slices = append(slices, r.numbers)
+	}
+	return kWayMerge(slices)
 }
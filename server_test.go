@@ -0,0 +1,827 @@
+// Tests for the NumbersGetter HTTP handler.
+package numbers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServeHTTPEmptyStatus(t *testing.T) {
+	expStatus := http.StatusNoContent
+
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 50 * time.Millisecond,
+		EmptyStatus:     expStatus,
+		URLGetter:       staticGetter{},
+	}}
+
+	w := serve(ng, "/numbers?u=http://fail&u=http://unavailable")
+	if w.Code != expStatus {
+		t.Fatalf("status mismatch for empty result: %s", comp(expStatus, w.Code))
+	}
+	if w.Body.Len() != 0 {
+		t.Fatalf("body written for empty result: %s", comp("", w.Body.String()))
+	}
+}
+
+func TestServeHTTPEmptyStatusDefault(t *testing.T) {
+	expStatus := http.StatusOK
+
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 50 * time.Millisecond,
+		URLGetter:       staticGetter{},
+	}}
+
+	w := serve(ng, "/numbers?u=http://fail")
+	if w.Code != expStatus {
+		t.Fatalf("status mismatch for empty result: %s", comp(expStatus, w.Code))
+	}
+}
+
+func TestServeHTTPCoalesceRequests(t *testing.T) {
+	expFetches := 1
+	numRequests := 5
+
+	cg := &countingGetter{URLGetter: &testGetter{500 * time.Millisecond}}
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout:  500 * time.Millisecond,
+		CoalesceRequests: true,
+		URLGetter:        cg,
+	}}
+
+	// The URLs take 100ms to respond, long enough for every request to find
+	// the first one in flight.
+	var wg sync.WaitGroup
+	wg.Add(numRequests)
+	for i := 0; i < numRequests; i++ {
+		go func() {
+			defer wg.Done()
+			serve(ng, "/numbers?u=http://rand10.100&u=http://rand100.100")
+		}()
+	}
+	wg.Wait()
+
+	for _, url := range []string{"http://rand10.100", "http://rand100.100"} {
+		if got := cg.count(url); got != expFetches {
+			t.Fatalf("fetch count mismatch for %s: %s", url, comp(expFetches, got))
+		}
+	}
+}
+
+func TestServeHTTPCoalesceKeepsDuplicateURLs(t *testing.T) {
+	exp := map[string][]int{
+		"u=http://a":            {1, 2},
+		"u=http://a&u=http://a": {1, 1, 2, 2},
+	}
+
+	getter := &delayGetter{
+		bodies: map[string]string{"http://a": `{"numbers":[2,1]}`},
+		delays: map[string]time.Duration{"http://a": 100 * time.Millisecond},
+	}
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout:  500 * time.Millisecond,
+		CoalesceRequests: true,
+		URLGetter:        getter,
+	}}
+
+	// Both requests are in flight at once, and must not share their work.
+	var mu sync.Mutex
+	got := make(map[string][]int)
+	var wg sync.WaitGroup
+	wg.Add(len(exp))
+	for q := range exp {
+		go func() {
+			defer wg.Done()
+			numbers := decodeResponse(t, serve(ng, "/numbers?dedupe=false&"+q).Body.Bytes())
+			mu.Lock()
+			got[q] = numbers
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("numbers mismatch: %s", comp(exp, got))
+	}
+}
+
+func TestServeHTTPCacheKeyFunc(t *testing.T) {
+	expFetches := 1
+	targets := []string{"http://a/numbers?x=1&y=2", "http://a/numbers?y=2&x=1"}
+
+	delays := make(map[string]time.Duration)
+	bodies := make(map[string]string)
+	for _, target := range targets {
+		delays[target] = 100 * time.Millisecond
+		bodies[target] = `{"numbers":[1,2,3]}`
+	}
+	cg := &countingGetter{URLGetter: &delayGetter{bodies: bodies, delays: delays}}
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout:  500 * time.Millisecond,
+		CoalesceRequests: true,
+		URLGetter:        cg,
+		CacheKeyFunc:     queryOrderKey,
+	}}
+
+	var wg sync.WaitGroup
+	wg.Add(len(targets))
+	for _, target := range targets {
+		go func() {
+			defer wg.Done()
+			serve(ng, "/numbers?u="+url.QueryEscape(target))
+		}()
+	}
+	wg.Wait()
+
+	if got := cg.count(targets[0]) + cg.count(targets[1]); got != expFetches {
+		t.Fatalf("fetch count mismatch: %s", comp(expFetches, got))
+	}
+}
+
+func TestServeHTTPCacheKeyFuncNamesURLs(t *testing.T) {
+	exp := []int{1, 3}
+	requests := [][]string{
+		{"http://a/numbers?x=1&y=2", "http://b/numbers"},
+		{"http://a/numbers?y=2&x=1", "http://b/numbers"},
+	}
+
+	delays := make(map[string]time.Duration)
+	bodies := map[string]string{"http://b/numbers": `{"numbers":[2,3]}`}
+	for _, urls := range requests {
+		delays[urls[0]] = 100 * time.Millisecond
+		bodies[urls[0]] = `{"numbers":[1,2]}`
+	}
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout:  500 * time.Millisecond,
+		CoalesceRequests: true,
+		URLGetter:        &delayGetter{bodies: bodies, delays: delays},
+		CacheKeyFunc:     queryOrderKey,
+	}}
+
+	// Each request looks up the results of its own URLs.
+	responses := make([][]byte, len(requests))
+	var wg sync.WaitGroup
+	wg.Add(len(requests))
+	for i, urls := range requests {
+		go func() {
+			defer wg.Done()
+			responses[i] = serve(ng, "/numbers?op=symdiff&u="+url.QueryEscape(urls[0])+"&u="+url.QueryEscape(urls[1])).Body.Bytes()
+		}()
+	}
+	wg.Wait()
+
+	for i, body := range responses {
+		if got := decodeResponse(t, body); !reflect.DeepEqual(exp, got) {
+			t.Fatalf("symdiff mismatch for request %d: %s", i, comp(exp, got))
+		}
+	}
+}
+
+// queryOrderKey is a Config.CacheKeyFunc ignoring the order of the query
+// parameters of each URL.
+func queryOrderKey(urls []string) string {
+	normalized := make([]string, len(urls))
+	for i, target := range urls {
+		u, err := url.Parse(target)
+		if err != nil {
+			return flightKey(urls)
+		}
+		u.RawQuery = u.Query().Encode()
+		normalized[i] = u.String()
+	}
+	return flightKey(normalized)
+}
+
+func TestServeHTTPPartialOnTimeout(t *testing.T) {
+	exp := []int{1, 2, 3, 4}
+
+	before := runtime.NumGoroutine()
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 100 * time.Millisecond,
+		URLGetter: &delayGetter{
+			bodies: map[string]string{
+				"http://a":    `{"numbers":[1]}`,
+				"http://b":    `{"numbers":[2,3]}`,
+				"http://c":    `{"numbers":[4]}`,
+				"http://slow": `{"numbers":[5]}`,
+			},
+			delays: map[string]time.Duration{"http://slow": time.Second},
+		},
+	}}
+
+	w := serve(ng, "/numbers?u=http://a&u=http://b&u=http://c&u=http://slow")
+	if got := decodeResponse(t, w.Body.Bytes()); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("numbers mismatch past the deadline: %s", comp(exp, got))
+	}
+	// Nothing is left blocked sending results once the response is written.
+	if !waitForGoroutines(before) {
+		t.Fatalf("goroutines leaked: %s", comp(before, runtime.NumGoroutine()))
+	}
+}
+
+func TestServeHTTPLogURLs(t *testing.T) {
+	for _, tc := range []struct {
+		redact bool
+		exp    string
+	}{
+		{false, "http://a?token=secret"},
+		{true, "http://a?REDACTED"},
+	} {
+		var buf strings.Builder
+		ng := &NumbersGetter{Config: Config{
+			ResponseTimeout: 50 * time.Millisecond,
+			URLGetter:       staticGetter{},
+			Logger:          slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+			RedactURLs:      tc.redact,
+		}}
+		serve(ng, "/numbers?u="+url.QueryEscape("http://a?token=secret"))
+		if got := buf.String(); !strings.Contains(got, "level=DEBUG") || !strings.Contains(got, tc.exp) ||
+			tc.redact && strings.Contains(got, "secret") {
+			t.Fatalf("logged URLs mismatch with redact=%v: %s", tc.redact, comp(tc.exp, got))
+		}
+	}
+
+	// URLs are not logged above the debug level.
+	var buf strings.Builder
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 50 * time.Millisecond,
+		URLGetter:       staticGetter{},
+		Logger:          slog.New(slog.NewTextHandler(&buf, nil)),
+	}}
+	serve(ng, "/numbers?u=http://a")
+	if got := buf.String(); got != "" {
+		t.Fatalf("URLs logged at the info level: %s", comp("", got))
+	}
+}
+
+func TestServeHTTPKeepDuplicates(t *testing.T) {
+	exp := []int{1, 2, 2, 3, 3, 3}
+	expStats := RequestStats{URLs: 2, Received: 6, Unique: 3, DedupRatio: 2}
+
+	var stats RequestStats
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		KeepDuplicates:  true,
+		StatsHook:       func(s RequestStats) { stats = s },
+		URLGetter:       staticGetter{"http://a": `{"numbers":[3,1,2,3]}`, "http://b": `{"numbers":[3,2]}`},
+	}}
+
+	if got := decodeResponse(t, serve(ng, "/numbers?u=http://a&u=http://b").Body.Bytes()); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("numbers mismatch: %s", comp(exp, got))
+	}
+	if stats != expStats {
+		t.Fatalf("stats mismatch: %s", comp(expStats, stats))
+	}
+}
+
+func TestServeHTTPAssumeSorted(t *testing.T) {
+	exp := []int{1, 2, 3, 5, 8}
+
+	// The last response is not sorted, which must not break the merge.
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		AssumeSorted:    true,
+		URLGetter: staticGetter{
+			"http://a": `{"numbers":[1,2,3]}`,
+			"http://b": `{"numbers":[2,3,5]}`,
+			"http://c": `{"numbers":[8,1,5]}`,
+		},
+	}}
+
+	if got := decodeResponse(t, serve(ng, "/numbers?u=http://a&u=http://b&u=http://c").Body.Bytes()); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("numbers mismatch: %s", comp(exp, got))
+	}
+}
+
+func TestServeHTTPWatchdog(t *testing.T) {
+	expNumbers := []int{1, 2}
+
+	// http://stuck lingers past the watchdog once its GET is cut short.
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 50 * time.Millisecond,
+		URLGetter: &delayGetter{
+			bodies: map[string]string{"http://a": `{"numbers":[1,2]}`, "http://stuck": `{"numbers":[3]}`},
+			delays: map[string]time.Duration{"http://stuck": time.Hour},
+			linger: time.Second,
+		},
+	}}
+
+	start := time.Now()
+	w := serve(ng, "/numbers?u=http://a&u=http://stuck")
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("handler held past its deadline: %s", comp("< 500ms", elapsed))
+	}
+	if w.Code != http.StatusOK {
+		t.Fatalf("status mismatch: %s", comp(http.StatusOK, w.Code))
+	}
+	var resp numbersResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+	if !reflect.DeepEqual(expNumbers, resp.Numbers) {
+		t.Fatalf("numbers mismatch: %s", comp(expNumbers, resp.Numbers))
+	}
+	if resp.Warning != timeoutWarning {
+		t.Fatalf("warning mismatch: %s", comp(timeoutWarning, resp.Warning))
+	}
+	if etag := w.Header().Get("ETag"); etag != "" {
+		t.Fatalf("partial result tagged: %s", comp("", etag))
+	}
+}
+
+func TestServeHTTPDebugStatuses(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"numbers":[1,2,3]}`))
+	}))
+	defer ts.Close()
+
+	// Nothing listens on port 1, so the last URL fails with a network error.
+	exp := map[string]int{
+		ts.URL + "/ok":          http.StatusOK,
+		ts.URL + "/down":        http.StatusServiceUnavailable,
+		"http://127.0.0.1:1/ok": 0,
+	}
+
+	ng := &NumbersGetter{Config: Config{ResponseTimeout: time.Second}}
+
+	w := serve(ng, "/numbers?debug=1&u="+ts.URL+"/ok&u="+ts.URL+"/down&u=http://127.0.0.1:1/ok")
+	var resp numbersResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+
+	got := make(map[string]int)
+	for _, r := range resp.Debug {
+		got[r.URL] = r.Status
+		if r.Status == 0 && r.Error == "" {
+			t.Fatalf("network error not reported for %s: %s", r.URL, comp("error", r.Error))
+		}
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("debug statuses mismatch: %s", comp(exp, got))
+	}
+}
+
+func TestServeHTTPDebugIndices(t *testing.T) {
+	exp := map[string][]int{
+		"http://c": {0},
+		"http://a": {1, 3},
+		"http://b": {2},
+	}
+
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		NormalizeURLs:   true,
+		URLGetter:       staticGetter{"http://a": `{"numbers":[1]}`, "http://b": `{"numbers":[2]}`, "http://c": `{"numbers":[3]}`},
+	}}
+
+	// The URLs are sorted and de-duplicated before they are queried.
+	w := serve(ng, "/numbers?debug=1&u=http://c&u=http://a&u=http://b&u=http://a")
+	var resp numbersResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+
+	got := make(map[string][]int)
+	for _, r := range resp.Debug {
+		got[r.URL] = r.Indices
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("debug indices mismatch: %s", comp(exp, got))
+	}
+}
+
+func TestServeHTTPVerbose(t *testing.T) {
+	exp := map[string]urlStatus{
+		"http://a":    {URL: "http://a", Status: statusOK, Count: 3},
+		"http://fail": {URL: "http://fail", Status: statusError},
+		"http://slow": {URL: "http://slow", Status: statusTimeout},
+	}
+
+	getter := &delayGetter{
+		bodies: map[string]string{"http://a": `{"numbers":[3,1,2]}`, "http://slow": `{"numbers":[4]}`},
+		delays: map[string]time.Duration{"http://a": 20 * time.Millisecond, "http://slow": time.Second},
+	}
+	ng := &NumbersGetter{Config: Config{ResponseTimeout: 100 * time.Millisecond, URLGetter: getter}}
+
+	var resp numbersResponse
+	body := serve(ng, "/numbers?verbose=1&u=http://a&u=http://fail&u=http://slow").Body.Bytes()
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("invalid response %q: %v", body, err)
+	}
+	if len(resp.URLs) != len(exp) {
+		t.Fatalf("URL count mismatch: %s", comp(len(exp), len(resp.URLs)))
+	}
+	for _, got := range resp.URLs {
+		if got.URL == "http://a" && got.LatencyMS < 20 {
+			t.Fatalf("latency mismatch: %s", comp(">= 20", got.LatencyMS))
+		}
+		got.LatencyMS = 0
+		if got != exp[got.URL] {
+			t.Fatalf("status mismatch for %s: %s", got.URL, comp(exp[got.URL], got))
+		}
+	}
+
+	// The default response has no breakdown.
+	if body := serve(ng, "/numbers?u=http://a").Body.String(); strings.Contains(body, "urls") {
+		t.Fatalf("unexpected breakdown: %s", comp(`{"Numbers":[1,2,3]}`, body))
+	}
+}
+
+func TestServeHTTPIncludeErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/down":
+			http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		case "/garbage":
+			w.Write([]byte("not json"))
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+			fallthrough
+		default:
+			w.Write([]byte(`{"numbers":[1,2,3]}`))
+		}
+	}))
+	defer ts.Close()
+
+	exp := map[string]string{
+		ts.URL + "/down":    reasonStatus,
+		ts.URL + "/garbage": reasonDecode,
+		ts.URL + "/slow":    reasonTimeout,
+		"://invalid":        reasonInvalidURL,
+	}
+
+	ng := &NumbersGetter{Config: Config{ResponseTimeout: time.Second, GetTimeout: 100 * time.Millisecond}}
+
+	w := serve(ng, "/numbers?includeErrors=1&u="+ts.URL+"/ok&u="+ts.URL+"/down&u="+ts.URL+"/garbage&u="+ts.URL+"/slow&u=://invalid")
+	var resp numbersResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+
+	got := make(map[string]string)
+	for _, e := range resp.Errors {
+		got[e.URL] = e.Reason
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("errors mismatch: %s", comp(exp, got))
+	}
+	if !reflect.DeepEqual([]int{1, 2, 3}, resp.Numbers) {
+		t.Fatalf("numbers mismatch: %s", comp([]int{1, 2, 3}, resp.Numbers))
+	}
+}
+
+func TestServeHTTPContextEnricher(t *testing.T) {
+	exp := []int{42}
+
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		ContextEnricher: func(r *http.Request, ctx context.Context) context.Context {
+			return context.WithValue(ctx, tenantKey{}, r.Header.Get("X-Tenant"))
+		},
+		URLGetter: tenantGetter{"acme": `{"numbers":[42]}`},
+	}}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/numbers?u=http://tenant", nil)
+	r.Header.Set("X-Tenant", "acme")
+	ng.ServeHTTP(w, r)
+
+	got := decodeResponse(t, w.Body.Bytes())
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("numbers mismatch: %s", comp(exp, got))
+	}
+}
+
+func TestServeHTTPContextEnricherNotShared(t *testing.T) {
+	exp := map[string][]int{"acme": {1}, "globex": {2}}
+
+	cg := &countingGetter{URLGetter: sleepGetter{tenantGetter{"acme": `{"numbers":[1]}`, "globex": `{"numbers":[2]}`}, 100 * time.Millisecond}}
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout:  500 * time.Millisecond,
+		CoalesceRequests: true,
+		CacheTTL:         time.Minute,
+		ContextEnricher: func(r *http.Request, ctx context.Context) context.Context {
+			return context.WithValue(ctx, tenantKey{}, r.Header.Get("X-Tenant"))
+		},
+		URLGetter: cg,
+	}}
+
+	// Without a RequestKey, no request shares the work or the cached
+	// responses of another.
+	for round := 1; round <= 2; round++ {
+		if got := serveTenants(t, ng, "acme", "globex"); !reflect.DeepEqual(exp, got) {
+			t.Fatalf("numbers mismatch: %s", comp(exp, got))
+		}
+		if n := cg.count("http://tenant"); n != 2*round {
+			t.Fatalf("fetch count mismatch: %s", comp(2*round, n))
+		}
+	}
+}
+
+func TestServeHTTPRequestKey(t *testing.T) {
+	exp := map[string][]int{"acme": {1}, "globex": {2}}
+	expFetches := 2
+
+	cg := &countingGetter{URLGetter: sleepGetter{tenantGetter{"acme": `{"numbers":[1]}`, "globex": `{"numbers":[2]}`}, 100 * time.Millisecond}}
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout:  500 * time.Millisecond,
+		CoalesceRequests: true,
+		CacheTTL:         time.Minute,
+		ContextEnricher: func(r *http.Request, ctx context.Context) context.Context {
+			return context.WithValue(ctx, tenantKey{}, r.Header.Get("X-Tenant"))
+		},
+		RequestKey: func(r *http.Request) string {
+			return r.Header.Get("X-Tenant")
+		},
+		URLGetter: cg,
+	}}
+
+	// The requests of a tenant share their work, then the cached response.
+	for round := 1; round <= 2; round++ {
+		if got := serveTenants(t, ng, "acme", "acme", "globex"); !reflect.DeepEqual(exp, got) {
+			t.Fatalf("numbers mismatch: %s", comp(exp, got))
+		}
+		if n := cg.count("http://tenant"); n != expFetches {
+			t.Fatalf("fetch count mismatch: %s", comp(expFetches, n))
+		}
+	}
+}
+
+func TestServeHTTPDrain(t *testing.T) {
+	exp := []int{1, 2, 3}
+
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 5 * time.Second,
+		URLGetter: &delayGetter{
+			bodies: map[string]string{"http://fast": `{"numbers":[1,2,3]}`, "http://slow": `{"numbers":[4,5,6]}`},
+			delays: map[string]time.Duration{"http://slow": 5 * time.Second},
+		},
+	}}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		ng.Drain()
+	}()
+
+	start := time.Now()
+	w := serve(ng, "/numbers?u=http://fast&u=http://slow")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("request not cut short by drain: %s", comp("< 1s", elapsed))
+	}
+	if got := w.Header().Get("X-Partial"); got != "true" {
+		t.Fatalf("X-Partial header mismatch: %s", comp("true", got))
+	}
+	if got := decodeResponse(t, w.Body.Bytes()); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("partial numbers mismatch: %s", comp(exp, got))
+	}
+}
+
+func TestServeHTTPFinalCountTrailer(t *testing.T) {
+	exp := "4"
+
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		URLGetter:       staticGetter{"http://a": `{"numbers":[1,2,3]}`, "http://b": `{"numbers":[3,4]}`},
+	}}
+	ts := httptest.NewUnstartedServer(ng)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL + "/numbers?u=http://a&u=http://b")
+	if err != nil {
+		t.Fatalf("unexpected get error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("protocol mismatch: %s", comp("HTTP/2", resp.Proto))
+	}
+
+	// Trailers are only known once the body is read.
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	if got := resp.Trailer.Get("X-Final-Count"); got != exp {
+		t.Fatalf("X-Final-Count trailer mismatch: %s", comp(exp, got))
+	}
+}
+
+func TestServeHTTPInlineTimeoutWarning(t *testing.T) {
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout:      100 * time.Millisecond,
+		InlineTimeoutWarning: true,
+		URLGetter: &delayGetter{
+			bodies: map[string]string{"http://fast": `{"numbers":[1]}`, "http://slow": `{"numbers":[2]}`},
+			delays: map[string]time.Duration{"http://slow": time.Second},
+		},
+	}}
+
+	for q, exp := range map[string]string{
+		"u=http://fast":               "",
+		"u=http://fast&u=http://slow": timeoutWarning,
+		// A URL failing for another reason is not a timeout.
+		"u=http://fast&u=http://down": "",
+	} {
+		w := serve(ng, "/numbers?"+q)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status mismatch for %s: %s", q, comp(http.StatusOK, w.Code))
+		}
+		var resp numbersResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+		}
+		if resp.Warning != exp {
+			t.Fatalf("warning mismatch for %s: %s", q, comp(exp, resp.Warning))
+		}
+	}
+}
+
+func TestServeHTTPMemoryCeiling(t *testing.T) {
+	exp := 500
+
+	bodies := make(staticGetter)
+	var q []string
+	for i := 0; i < 10; i++ {
+		url := "http://n/" + strconv.Itoa(i)
+		numbers := make([]string, 200)
+		for j := range numbers {
+			numbers[j] = strconv.Itoa(i*200 + j)
+		}
+		bodies[url] = `{"numbers":[` + strings.Join(numbers, ",") + `]}`
+		q = append(q, "u="+url)
+	}
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout:         time.Second,
+		PerRequestMemoryCeiling: int64(exp * numberSize),
+		URLGetter:               bodies,
+	}}
+
+	w := serve(ng, "/numbers?"+strings.Join(q, "&"))
+	if got := w.Header().Get("X-Memory-Capped"); got != "true" {
+		t.Fatalf("X-Memory-Capped mismatch: %s", comp("true", got))
+	}
+	if got := len(decodeResponse(t, w.Body.Bytes())); got != exp {
+		t.Fatalf("numbers count mismatch: %s", comp(exp, got))
+	}
+
+	ng = &NumbersGetter{Config: Config{ResponseTimeout: time.Second, URLGetter: bodies}}
+	w = serve(ng, "/numbers?"+strings.Join(q, "&"))
+	if got := w.Header().Get("X-Memory-Capped"); got != "" {
+		t.Fatalf("X-Memory-Capped set without a ceiling: %s", comp("", got))
+	}
+	if got := len(decodeResponse(t, w.Body.Bytes())); got != 2000 {
+		t.Fatalf("numbers count mismatch without a ceiling: %s", comp(2000, got))
+	}
+}
+
+// serve runs a GET request for target through h and returns the recorded response.
+func serve(h http.Handler, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+	return w
+}
+
+// countingGetter counts the GETs of every URL before delegating to the
+// embedded URLGetter.
+type countingGetter struct {
+	URLGetter
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (c *countingGetter) Get(ctx context.Context, url string) ([]byte, error) {
+	c.mu.Lock()
+	if c.counts == nil {
+		c.counts = make(map[string]int)
+	}
+	c.counts[url]++
+	c.mu.Unlock()
+	return c.URLGetter.Get(ctx, url)
+}
+
+func (c *countingGetter) count(url string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[url]
+}
+
+// stuckGetter ignores its context and takes d to respond.
+type stuckGetter struct {
+	d time.Duration
+}
+
+func (s stuckGetter) Get(ctx context.Context, url string) ([]byte, error) {
+	time.Sleep(s.d)
+	return []byte(`{"numbers":[1]}`), nil
+}
+
+func (s stuckGetter) Client() *http.Client {
+	return nil
+}
+
+// tenantKey is the context key of the tenant read by tenantGetter.
+type tenantKey struct{}
+
+// tenantGetter responds with the body of the tenant found in the context.
+type tenantGetter map[string]string
+
+func (tg tenantGetter) Get(ctx context.Context, url string) ([]byte, error) {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	body, ok := tg[tenant]
+	if !ok {
+		return nil, errors.New("unknown tenant " + tenant)
+	}
+	return []byte(body), nil
+}
+
+func (tg tenantGetter) Client() *http.Client {
+	return nil
+}
+
+// serveTenants serves concurrent requests for http://tenant on behalf of
+// tenants, and returns the numbers each tenant received.
+func serveTenants(t *testing.T, ng *NumbersGetter, tenants ...string) map[string][]int {
+	var mu sync.Mutex
+	got := make(map[string][]int)
+	var wg sync.WaitGroup
+	wg.Add(len(tenants))
+	for _, tenant := range tenants {
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/numbers?u=http://tenant", nil)
+			r.Header.Set("X-Tenant", tenant)
+			ng.ServeHTTP(w, r)
+			numbers := decodeResponse(t, w.Body.Bytes())
+			mu.Lock()
+			got[tenant] = numbers
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return got
+}
+
+// sleepGetter takes d to delegate to the embedded URLGetter.
+type sleepGetter struct {
+	URLGetter
+	d time.Duration
+}
+
+func (s sleepGetter) Get(ctx context.Context, url string) ([]byte, error) {
+	time.Sleep(s.d)
+	return s.URLGetter.Get(ctx, url)
+}
+
+// delayGetter serves canned response bodies keyed by URL, after the delay of
+// the URL or until its context is done. GETs cut short by their context
+// return its error after lingering for the given time.
+type delayGetter struct {
+	bodies map[string]string
+	delays map[string]time.Duration
+	linger time.Duration
+
+	// served, if set, is sent the URLs whose body was returned. It must be
+	// buffered enough for the GETs of a test not to block on it.
+	served chan string
+}
+
+func (d *delayGetter) Get(ctx context.Context, url string) ([]byte, error) {
+	select {
+	case <-time.After(d.delays[url]):
+	case <-ctx.Done():
+		time.Sleep(d.linger)
+		return nil, ctx.Err()
+	}
+	body, ok := d.bodies[url]
+	if !ok {
+		return nil, errors.New("service unavailable")
+	}
+	if d.served != nil {
+		d.served <- url
+	}
+	return []byte(body), nil
+}
+
+func (d *delayGetter) Client() *http.Client {
+	return nil
+}
@@ -0,0 +1,110 @@
+// This file contains a minimal request coalescing mechanism, in the spirit of
+// golang.org/x/sync/singleflight, used by NumbersGetter to share the work of
+// concurrent requests for the same set of URLs.
+package numbers
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+)
+
+// errFlightPanicked is returned to the callers waiting on a flight whose
+// execution panicked.
+var errFlightPanicked = errors.New("coalesced request failed")
+
+// flight is a computation that is in progress or has completed.
+type flight struct {
+	wg  sync.WaitGroup
+	val *collection
+	err error
+}
+
+// flightGroup coalesces calls with the same key into a single execution.
+// The zero value is ready to use.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// do executes fn for key, unless an execution for key is already in flight,
+// in which case it waits for that execution and returns its result. The
+// returned collection is shared by every caller and must not be modified.
+// If fn panics, the panic goes on in the caller executing it, and the callers
+// waiting for it get errFlightPanicked. A later call for key executes fn
+// again.
+func (g *flightGroup) do(key string, fn func() *collection) (*collection, error) {
+	g.mu.Lock()
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+	if f, ok := g.flights[key]; ok {
+		g.mu.Unlock()
+		f.wg.Wait()
+		return f.val, f.err
+	}
+	f := &flight{}
+	f.wg.Add(1)
+	g.flights[key] = f
+	g.mu.Unlock()
+
+	completed := false
+	defer func() {
+		if !completed {
+			f.err = errFlightPanicked
+		}
+		f.wg.Done()
+
+		g.mu.Lock()
+		delete(g.flights, key)
+		g.mu.Unlock()
+	}()
+	f.val = fn()
+	completed = true
+	return f.val, nil
+}
+
+// flightKey returns the key identifying a set of URLs, a SHA-256 hash of
+// them. The order of the URLs and duplicates do not change the key.
+func flightKey(urls []string) string {
+	return hashURLs(normalizeURLs(urls))
+}
+
+// multisetKey works like flightKey, but URLs given more times make a
+// different key.
+func multisetKey(urls []string) string {
+	sorted := make([]string, len(urls))
+	copy(sorted, urls)
+	sort.Strings(sorted)
+	return hashURLs(sorted)
+}
+
+// hashURLs returns the hex SHA-256 hash of urls. Every URL is prefixed with
+// its length, so that no two lists hash the same bytes whatever their URLs
+// contain.
+func hashURLs(urls []string) string {
+	h := sha256.New()
+	var n [binary.MaxVarintLen64]byte
+	for _, u := range urls {
+		h.Write(n[:binary.PutUvarint(n[:], uint64(len(u)))])
+		io.WriteString(h, u)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cacheKey returns the key identifying urls, with cfg.CacheKeyFunc if set.
+// With cfg.KeepDuplicates, the numbers of a URL given twice are returned
+// twice, so the default key keeps the duplicate URLs.
+func (cfg *Config) cacheKey(urls []string) string {
+	if cfg.CacheKeyFunc != nil {
+		return cfg.CacheKeyFunc(urls)
+	}
+	if cfg.KeepDuplicates {
+		return multisetKey(urls)
+	}
+	return flightKey(urls)
+}
@@ -0,0 +1,75 @@
+// Tests for the coalescing of requests.
+package numbers
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFlightGroupPanic(t *testing.T) {
+	var g flightGroup
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer func() {
+			if recover() == nil {
+				t.Error("panic not raised in the executing caller")
+			}
+		}()
+		g.do("k", func() *collection {
+			close(started)
+			<-release
+			panic("collect failed")
+		})
+	}()
+
+	<-started
+	waiter := make(chan error, 1)
+	go func() {
+		_, err := g.do("k", func() *collection {
+			t.Error("waiter executed the flight")
+			return nil
+		})
+		waiter <- err
+	}()
+	// The waiter has time to join the flight before it panics.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if err := <-waiter; err != errFlightPanicked {
+		t.Fatalf("waiter error mismatch: %s", comp(errFlightPanicked, err))
+	}
+
+	// The key is not left wedged.
+	exp := &collection{}
+	if got, err := g.do("k", func() *collection { return exp }); err != nil || got != exp {
+		t.Fatalf("later call mismatch: %s", comp(exp, got))
+	}
+}
+
+func TestHashURLsDistinct(t *testing.T) {
+	// Lists whose URLs only differ in how they are split must not share a
+	// hash.
+	lists := [][]string{
+		{"http://a\nhttp://b"},
+		{"http://a", "http://b"},
+		{"http://a", "", "http://b"},
+		{"http://a\n", "http://b"},
+		{},
+		{""},
+	}
+	seen := make(map[string]int)
+	for i, urls := range lists {
+		h := hashURLs(urls)
+		if j, ok := seen[h]; ok {
+			t.Fatalf("hash collision: %s", comp(lists[j], urls))
+		}
+		seen[h] = i
+	}
+}
@@ -0,0 +1,78 @@
+// This file benchmarks the scheduling strategies against upstreams with a mix
+// of latencies: most respond in a millisecond but one in ten takes 20ms, as
+// is typical of a fleet with a few overloaded hosts. Each benchmark reports
+// the throughput in URLs per second and the 99th percentile latency of a
+// request, side by side for both strategies at every URL count, to show
+// whether and where one overtakes the other.
+
+// Benchmarks can be run using: `go test numbers -bench=Strategy -run=Bench`
+package numbers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func BenchmarkStrategy(b *testing.B) {
+	strategies := []struct {
+		name     string
+		strategy Strategy
+	}{
+		{"FixedPool", FixedPool},
+		{"OnDemand", OnDemand},
+	}
+
+	for _, n := range []int{5, 50, 500} {
+		urls := make([]string, n)
+		for i := range urls {
+			urls[i] = "http://host" + strconv.Itoa(i)
+		}
+		for _, s := range strategies {
+			b.Run(fmt.Sprintf("urls=%d/%s", n, s.name), func(b *testing.B) {
+				cfg := &Config{NumGoRoutines: 50, Strategy: s.strategy, URLGetter: latencyMixGetter{}}
+				latencies := make([]time.Duration, 0, b.N)
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					start := time.Now()
+					for _ = range processResults(context.Background(), cfg, urls) {
+					}
+					latencies = append(latencies, time.Since(start))
+				}
+				b.StopTimer()
+
+				sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+				p99 := latencies[len(latencies)*99/100]
+				b.ReportMetric(float64(p99)/float64(time.Millisecond), "p99-ms")
+				b.ReportMetric(float64(n*b.N)/b.Elapsed().Seconds(), "urls/s")
+			})
+		}
+	}
+}
+
+// latencyMixGetter responds to every GET with a single number, after 20ms for
+// the URLs of hosts whose number is a multiple of ten and after 1ms otherwise.
+type latencyMixGetter struct{}
+
+func (latencyMixGetter) Get(ctx context.Context, url string) ([]byte, error) {
+	delay := time.Millisecond
+	if strings.HasSuffix(url, "0") {
+		delay = 20 * time.Millisecond
+	}
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return []byte(`{"numbers":[1]}`), nil
+}
+
+func (latencyMixGetter) Client() *http.Client {
+	return nil
+}
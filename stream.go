@@ -0,0 +1,139 @@
+// This file contains the writing of responses as NDJSON, a number per line,
+// flushed in chunks so that clients can process large results as they come,
+// and as a JSON array written as the URLs respond, with ?stream=1.
+package numbers
+
+import (
+	"bufio"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// ndjsonContentType is the media type of the responses written a number per
+// line, requested with the Accept header.
+const ndjsonContentType = "application/x-ndjson"
+
+// writeNDJSON writes numbers to w, one per line, flushing the response after
+// every chunk numbers. A chunk of zero or less flushes after every number.
+func writeNDJSON(w http.ResponseWriter, numbers []int, chunk int) error {
+	if chunk <= 0 {
+		chunk = 1
+	}
+	flusher, _ := w.(http.Flusher)
+	bw := bufio.NewWriter(w)
+	flush := func() error {
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	var buf []byte
+	for i, n := range numbers {
+		buf = strconv.AppendInt(buf[:0], int64(n), 10)
+		buf = append(buf, '\n')
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+		if (i+1)%chunk == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// serveStream responds to r with a JSON array of the numbers of urls, written
+// and flushed as each URL responds. The numbers of each URL are written
+// sorted, without those already written, so that the array holds every number
+// once but is only sorted within each response. When the ResponseTimeout is
+// reached the array is closed with the numbers received so far, and clients
+// always get a valid array. The count of numbers written follows in the
+// X-Final-Count trailer.
+func (ng *NumbersGetter) serveStream(w http.ResponseWriter, r *http.Request, cfg *Config, opts *requestOptions, urls []string) {
+	ctx, cancel := withTimeout(ng.requestContext(r, opts, r.Context()), cfg.clock(), ng.ResponseTimeout)
+	defer cancel()
+	ctx, cancelDrain := ng.drainable(ctx)
+	defer cancelDrain()
+
+	// The results left once the watchdog gave up, or once writing them
+	// panicked, are dropped as cfg.abandon is closed by the caller.
+	resultCh := processResults(ctx, cfg, urls)
+
+	w.Header().Set("Content-Type", "application/json")
+	// Streaming clients learn the count of numbers once the array is closed.
+	w.Header().Set("Trailer", "X-Final-Count")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	bw := bufio.NewWriter(w)
+	flush := func() error {
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	bw.WriteByte('[')
+	if err := flush(); err != nil {
+		log.Printf("error writing streamed response: %v", err)
+		return
+	}
+	seen := make(map[int]bool)
+	watchdog := cfg.clock().After(ng.ResponseTimeout + watchdogMargin)
+	var buf []byte
+	var written int
+collect:
+	for {
+		var res urlResult
+		var ok bool
+		select {
+		case res, ok = <-resultCh:
+		case <-watchdog:
+			log.Printf("warning: streamed response for %v not complete past its deadline, closing it", urls)
+			break collect
+		}
+		if !ok {
+			break collect
+		}
+
+		fresh := res.numbers[:0:0]
+		for _, n := range res.numbers {
+			if !seen[n] {
+				seen[n] = true
+				fresh = append(fresh, n)
+			}
+		}
+		if len(fresh) == 0 {
+			continue
+		}
+		sort.Ints(fresh)
+		for _, n := range fresh {
+			buf = buf[:0]
+			if written > 0 {
+				buf = append(buf, ',')
+			}
+			buf = strconv.AppendInt(buf, int64(n), 10)
+			written++
+			bw.Write(buf)
+		}
+		if err := flush(); err != nil {
+			log.Printf("error writing streamed response: %v", err)
+			return
+		}
+	}
+	bw.WriteString("]\n")
+	if err := flush(); err != nil {
+		log.Printf("error writing streamed response: %v", err)
+		return
+	}
+	w.Header().Set("X-Final-Count", strconv.Itoa(written))
+}
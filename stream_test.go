@@ -0,0 +1,151 @@
+// Tests for the NDJSON responses.
+package numbers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestServeHTTPNDJSONChunks(t *testing.T) {
+	// The last chunk holds the remaining number.
+	exp := []string{"1\n2\n3\n", "4\n5\n6\n", "7\n"}
+
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		StreamChunkSize: 3,
+		URLGetter:       staticGetter{"http://a": `{"numbers":[7,5,3,1]}`, "http://b": `{"numbers":[6,4,2]}`},
+	}}
+
+	w := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	r := httptest.NewRequest("GET", "/numbers?u=http://a&u=http://b", nil)
+	r.Header.Set("Accept", "application/x-ndjson")
+	ng.ServeHTTP(w, r)
+
+	if got := w.Header().Get("Content-Type"); got != ndjsonContentType {
+		t.Fatalf("content type mismatch: %s", comp(ndjsonContentType, got))
+	}
+	if !reflect.DeepEqual(exp, w.chunks) {
+		t.Fatalf("flushed chunks mismatch: %s", comp(exp, w.chunks))
+	}
+}
+
+func TestServeHTTPStream(t *testing.T) {
+	// The numbers of each URL are flushed as it responds, without those
+	// already written, and the slow URL is cut off by the deadline.
+	exp := []string{"[", "1,3", ",2", "]\n"}
+
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 300 * time.Millisecond,
+		URLGetter: &delayGetter{
+			bodies: map[string]string{
+				"http://fast": `{"numbers":[3,1]}`,
+				"http://mid":  `{"numbers":[2,3]}`,
+				"http://slow": `{"numbers":[4]}`,
+			},
+			delays: map[string]time.Duration{
+				"http://mid":  100 * time.Millisecond,
+				"http://slow": time.Second,
+			},
+		},
+	}}
+
+	w := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	r := httptest.NewRequest("GET", "/numbers?stream=1&u=http://fast&u=http://mid&u=http://slow", nil)
+	ng.ServeHTTP(w, r)
+
+	if !reflect.DeepEqual(exp, w.chunks) {
+		t.Fatalf("flushed chunks mismatch: %s", comp(exp, w.chunks))
+	}
+	var got []int
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid streamed response %q: %v", w.Body.String(), err)
+	}
+	if got := w.Result().Trailer.Get("X-Final-Count"); got != "3" {
+		t.Fatalf("X-Final-Count trailer mismatch: %s", comp("3", got))
+	}
+}
+
+func TestServeHTTPStreamPanicReleasesURLs(t *testing.T) {
+	query := "/numbers?stream=1"
+	bodies := staticGetter{}
+	for i := 0; i < 20; i++ {
+		url := "http://a/" + strconv.Itoa(i)
+		bodies[url] = `{"numbers":[` + strconv.Itoa(i) + `]}`
+		query += "&u=" + url
+	}
+	ng := &NumbersGetter{Config: Config{ResponseTimeout: 500 * time.Millisecond, NumGoRoutines: 4, URLGetter: bodies}}
+	ng.setup.Do(ng.setDefaults)
+
+	before := runtime.NumGoroutine()
+	func() {
+		// net/http recovers the panics of handlers, as done here.
+		defer func() {
+			if recover() == nil {
+				t.Fatal("writer panic not raised")
+			}
+		}()
+		w := &panicWriter{ResponseRecorder: httptest.NewRecorder(), flushes: 2}
+		ng.ServeHTTP(w, httptest.NewRequest("GET", query, nil))
+	}()
+
+	if !waitForGoroutines(before) {
+		t.Fatalf("goroutines leaked after the handler panicked: %s", comp(before, runtime.NumGoroutine()))
+	}
+}
+
+func TestServeHTTPStreamRejectsOptions(t *testing.T) {
+	ng := &NumbersGetter{Config: Config{ResponseTimeout: 50 * time.Millisecond, URLGetter: staticGetter{}}}
+	for _, query := range []string{"debug=1", "sort=freq", "sample=2"} {
+		w := serve(ng, "/numbers?stream=1&u=http://a&"+query)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("status mismatch for stream with %s: %s", query, comp(http.StatusBadRequest, w.Code))
+		}
+	}
+}
+
+func TestWriteNDJSONDefaultChunk(t *testing.T) {
+	exp := []string{"1\n", "2\n"}
+
+	w := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	if err := writeNDJSON(w, []int{1, 2}, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(exp, w.chunks) {
+		t.Fatalf("flushed chunks mismatch: %s", comp(exp, w.chunks))
+	}
+}
+
+// flushRecorder records the bytes written since the previous flush at every
+// flush, skipping flushes with nothing new.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushed int
+	chunks  []string
+}
+
+func (f *flushRecorder) Flush() {
+	body := f.Body.String()
+	if len(body) > f.flushed {
+		f.chunks = append(f.chunks, body[f.flushed:])
+	}
+	f.flushed = len(body)
+}
+
+// panicWriter panics once it has been flushed the given number of times, as a
+// writer failing mid-response might.
+type panicWriter struct {
+	*httptest.ResponseRecorder
+	flushes int
+}
+
+func (p *panicWriter) Flush() {
+	if p.flushes--; p.flushes < 0 {
+		panic("writer failed")
+	}
+}
@@ -0,0 +1,82 @@
+// This file contains the expansion of URL templates, which let a request query
+// sharded backends without listing every shard.
+package numbers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// templateParam is the placeholder that is expanded in URL templates.
+const templateParam = "{n}"
+
+// maxTemplateExpansion caps the number of URLs the templates of a request may
+// expand to, so that a single request cannot fan out without bound.
+const maxTemplateExpansion = 1000
+
+// expandTemplates returns urls with every URL template expanded over rng.
+// A template is a URL containing {n}, which is replaced with every integer of
+// rng in turn. rng has the form first-last, both non-negative and inclusive,
+// such as 0-9. URLs without {n} are kept as they are. An error is returned if
+// rng is malformed, if there are templates but no range, or if the expansion
+// would exceed maxTemplateExpansion URLs.
+func expandTemplates(urls []string, rng string) ([]string, error) {
+	templates := 0
+	for _, url := range urls {
+		if strings.Contains(url, templateParam) {
+			templates++
+		}
+	}
+	if templates == 0 {
+		if rng != "" {
+			return nil, errors.New("range given without a URL template")
+		}
+		return urls, nil
+	}
+	if rng == "" {
+		return nil, errors.New("URL template given without a range")
+	}
+
+	first, last, err := parseRange(rng)
+	if err != nil {
+		return nil, err
+	}
+	// last-first cannot overflow, unlike the number of integers in rng, which
+	// is one more.
+	if span := last - first; span >= (maxTemplateExpansion-(len(urls)-templates))/templates {
+		return nil, fmt.Errorf("URL templates expand to more than %d URLs", maxTemplateExpansion)
+	}
+
+	expanded := make([]string, 0, len(urls))
+	for _, url := range urls {
+		if !strings.Contains(url, templateParam) {
+			expanded = append(expanded, url)
+			continue
+		}
+		for n := first; n <= last; n++ {
+			expanded = append(expanded, strings.ReplaceAll(url, templateParam, strconv.Itoa(n)))
+		}
+	}
+	return expanded, nil
+}
+
+// parseRange parses a range of the form first-last.
+func parseRange(rng string) (int, int, error) {
+	errRange := errors.New("range must be of the form first-last, with 0 <= first <= last")
+
+	i := strings.IndexByte(rng, '-')
+	if i < 0 {
+		return 0, 0, errRange
+	}
+	first, err := strconv.Atoi(rng[:i])
+	if err != nil || first < 0 {
+		return 0, 0, errRange
+	}
+	last, err := strconv.Atoi(rng[i+1:])
+	if err != nil || last < first {
+		return 0, 0, errRange
+	}
+	return first, last, nil
+}
@@ -0,0 +1,57 @@
+// Tests for the expansion of URL templates.
+package numbers
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestServeHTTPURLTemplate(t *testing.T) {
+	exp := []string{"http://extra/numbers"}
+	bodies := staticGetter{"http://extra/numbers": `{"numbers":[100]}`}
+	for i := 0; i < 10; i++ {
+		url := "http://shard-" + strconv.Itoa(i) + "/numbers"
+		exp = append(exp, url)
+		bodies[url] = `{"numbers":[` + strconv.Itoa(i) + `]}`
+	}
+	sort.Strings(exp)
+
+	rg := &recordingGetter{URLGetter: bodies}
+	ng := &NumbersGetter{Config: Config{ResponseTimeout: 500 * time.Millisecond, URLGetter: rg}}
+
+	w := serve(ng, "/numbers?u=http://shard-{n}/numbers&u=http://extra/numbers&range=0-9")
+	if w.Code != 200 {
+		t.Fatalf("status mismatch: %s", comp(200, w.Code))
+	}
+	got := append([]string(nil), rg.urls...)
+	sort.Strings(got)
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("fetched URLs mismatch: %s", comp(exp, got))
+	}
+	if n := len(decodeResponse(t, w.Body.Bytes())); n != 11 {
+		t.Fatalf("numbers count mismatch: %s", comp(11, n))
+	}
+}
+
+func TestExpandTemplatesInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		urls []string
+		rng  string
+	}{
+		{[]string{"http://shard-{n}"}, ""},
+		{[]string{"http://shard-1"}, "0-9"},
+		{[]string{"http://shard-{n}"}, "9-0"},
+		{[]string{"http://shard-{n}"}, "-1-3"},
+		{[]string{"http://shard-{n}"}, "0"},
+		{[]string{"http://shard-{n}"}, "0-1000"},
+		{[]string{"http://a-{n}", "http://b-{n}"}, "0-500"},
+		{[]string{"http://shard-{n}"}, "0-9223372036854775807"},
+	} {
+		if got, err := expandTemplates(tc.urls, tc.rng); err == nil {
+			t.Fatalf("%v over %q not rejected: %s", tc.urls, tc.rng, comp("error", got))
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// This file contains the transport the default getter given WithUnixSockets
+// queries http+unix URLs with, such as
+// http+unix://%2Fvar%2Frun%2Fsvc.sock/numbers, whose host is the escaped path
+// of the Unix socket the server listens on.
+package numbers
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// unixScheme is the URL scheme of the servers listening on a Unix socket.
+const unixScheme = "http+unix"
+
+// errUnixSocketsDisabled is returned for the http+unix URLs of a default
+// getter not given WithUnixSockets.
+var errUnixSocketsDisabled = errors.New("unsupported protocol scheme " + unixScheme)
+
+// unixRequestURL returns the URL the default getter requests the http+unix
+// URL raw with. net/url does not accept escaped slashes in hosts, so the
+// socket path is hex encoded instead. This also keeps connections to
+// different sockets apart, as the transport pools them by host.
+func unixRequestURL(raw string) (string, error) {
+	rest := strings.TrimPrefix(raw, unixScheme+"://")
+	host, path := rest, "/"
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		host, path = rest[:i], rest[i:]
+	}
+	sock, err := url.PathUnescape(host)
+	if err != nil {
+		return "", &url.Error{Op: "parse", URL: raw, Err: err}
+	}
+	return unixScheme + "://" + hex.EncodeToString([]byte(sock)) + path, nil
+}
+
+// unixTransport is a RoundTripper sending http+unix requests over the Unix
+// socket named by their host.
+type unixTransport struct {
+	transport *http.Transport
+}
+
+// newUnixTransport returns a unixTransport with the settings of base, apart
+// from how connections are dialed.
+func newUnixTransport(base *http.Transport) *unixTransport {
+	t := base.Clone()
+	// Sockets are local, there is nothing to proxy.
+	t.Proxy = nil
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		// The transport appends the default port to the encoded path.
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		path, err := hex.DecodeString(host)
+		if err != nil {
+			return nil, err
+		}
+		return dialer.DialContext(ctx, "unix", string(path))
+	}
+	return &unixTransport{transport: t}
+}
+
+// RoundTrip sends req as a plain HTTP request over the socket of its host.
+func (t *unixTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = "http"
+	// The socket path is no host name for the server.
+	req.Host = "localhost"
+	return t.transport.RoundTrip(req)
+}
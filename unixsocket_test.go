@@ -0,0 +1,75 @@
+// Tests for the queries of URLs over Unix sockets.
+package numbers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDefaultGetUnixSocket(t *testing.T) {
+	exp := []int{3, 1, 2}
+
+	sock := filepath.Join(t.TempDir(), "svc.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("unexpected error listening on %s: %v", sock, err)
+	}
+	var gotPath string
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		w.Write([]byte(`{"numbers":[3,1,2]}`))
+	}))
+	ts.Listener = l
+	ts.Start()
+	defer ts.Close()
+
+	cfg := &Config{URLGetter: NewDefaultGet(time.Second, WithUnixSockets())}
+	res := fetchResponse(context.Background(), cfg, "http+unix://"+url.PathEscape(sock)+"/numbers")
+	if res.err != nil {
+		t.Fatalf("unexpected error: %v", res.err)
+	}
+	if !reflect.DeepEqual(exp, res.numbers) {
+		t.Fatalf("numbers mismatch: %s", comp(exp, res.numbers))
+	}
+	if gotPath != "/numbers" {
+		t.Fatalf("path mismatch: %s", comp("/numbers", gotPath))
+	}
+}
+
+func TestDefaultGetUnixSocketDisabled(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "svc.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("unexpected error listening on %s: %v", sock, err)
+	}
+	var served bool
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = true
+		w.Write([]byte(`{"numbers":[3,1,2]}`))
+	}))
+	ts.Listener = l
+	ts.Start()
+	defer ts.Close()
+
+	u := "http+unix://" + url.PathEscape(sock) + "/numbers"
+	for _, cfg := range []*Config{
+		{URLGetter: NewDefaultGet(time.Second)},
+		{ResponseTimeout: time.Second},
+	} {
+		cfg.setDefaults()
+		res := fetchResponse(context.Background(), cfg, u)
+		if res.err == nil {
+			t.Fatalf("expected an error, got numbers %v", res.numbers)
+		}
+	}
+	if served {
+		t.Fatalf("socket queried without WithUnixSockets")
+	}
+}
@@ -0,0 +1,119 @@
+// This file contains the streaming decoding of the URL lists POSTed to
+// NumbersGetter, which lets the URLs be queried while the list is still being
+// received.
+package numbers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// streamedURL is a URL of a POST body, along with its position in the body.
+type streamedURL struct {
+	url   string
+	index int
+}
+
+// urlStream decodes a JSON object of the form {"urls": [...]} from a request
+// body, and sends every URL over ch as soon as it is decoded. The URLs are
+// not kept once sent.
+type urlStream struct {
+	ch chan streamedURL
+
+	// err is only set once done is closed.
+	err  error
+	done chan struct{}
+}
+
+// streamURLs starts decoding the URLs in r. Decoding stops early if ctx is
+// done, if the body is malformed, if the URLs span more than maxHosts
+// distinct hosts, or if there are more than maxURLs of them. Zero means no
+// limit for either.
+func streamURLs(ctx context.Context, r io.Reader, maxHosts, maxURLs int) *urlStream {
+	s := &urlStream{ch: make(chan streamedURL), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		defer close(s.ch)
+		s.err = s.decode(ctx, r, maxHosts, maxURLs)
+	}()
+	return s
+}
+
+// wait waits for the stream to end and returns the error that ended it, if
+// any.
+func (s *urlStream) wait() error {
+	<-s.done
+	return s.err
+}
+
+// decode decodes the body in r, sending its URLs over s.ch.
+func (s *urlStream) decode(ctx context.Context, r io.Reader, maxHosts, maxURLs int) error {
+	fail := func(err error) error {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return fmt.Errorf("request body larger than %d bytes", tooLarge.Limit)
+		}
+		return errors.New(`request body must be a JSON object of the form {"urls": [...]}`)
+	}
+
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return fail(err)
+	}
+	hosts := make(map[string]bool)
+	ranged, n := 0, 0
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return fail(err)
+		}
+		if key != "urls" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return fail(err)
+			}
+			continue
+		}
+
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+			return fail(err)
+		}
+		for dec.More() {
+			var raw string
+			if err := dec.Decode(&raw); err != nil {
+				return fail(err)
+			}
+			if n++; maxURLs > 0 && n > maxURLs {
+				return fmt.Errorf("more URLs given than the maximum of %d", maxURLs)
+			}
+			if isRangeDirective(raw) {
+				if ranged, err = addRange(ranged, raw); err != nil {
+					return err
+				}
+			} else if u, err := url.Parse(raw); err == nil && maxHosts > 0 {
+				if hosts[strings.ToLower(u.Hostname())] = true; len(hosts) > maxHosts {
+					return fmt.Errorf("the URLs span more than the maximum of %d hosts", maxHosts)
+				}
+			}
+
+			select {
+			case s.ch <- streamedURL{url: raw, index: n - 1}:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return fail(err)
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return fail(err)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+// Tests for the streaming of POSTed URL lists.
+package numbers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServeHTTPPostStreaming(t *testing.T) {
+	expNumbers := 1000
+
+	sg := &startGetter{started: make(chan struct{})}
+	ng := &NumbersGetter{Config: Config{ResponseTimeout: 5 * time.Second, URLGetter: sg}}
+
+	pr, pw := io.Pipe()
+	r := httptest.NewRequest("POST", "/numbers", pr)
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	served := make(chan struct{})
+	go func() {
+		ng.ServeHTTP(w, r)
+		close(served)
+	}()
+
+	// The rest of the list is only sent once the first URL was fetched.
+	io.WriteString(pw, `{"urls": ["http://n/0"`)
+	select {
+	case <-sg.started:
+	case <-time.After(time.Second):
+		t.Fatalf("fetching not started before the end of the body: %s", comp("a fetch", nil))
+	}
+	for i := 1; i < expNumbers; i++ {
+		fmt.Fprintf(pw, `, "http://n/%d"`, i)
+	}
+	io.WriteString(pw, `], "comment": "done"}`)
+	pw.Close()
+	<-served
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status mismatch: %s", comp(http.StatusOK, w.Code))
+	}
+	if n := len(decodeResponse(t, w.Body.Bytes())); n != expNumbers {
+		t.Fatalf("numbers count mismatch: %s", comp(expNumbers, n))
+	}
+}
+
+func TestServeHTTPPostWatchdog(t *testing.T) {
+	expNumbers := []int{0}
+
+	// The body never ends, so only the watchdog can end the response. The
+	// reads of the body still running once the handler returned are counted.
+	ng := &NumbersGetter{Config: Config{ResponseTimeout: 50 * time.Millisecond, URLGetter: &startGetter{started: make(chan struct{})}}}
+	reading := make(chan int32, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := &readingBody{ReadCloser: r.Body}
+		r.Body = body
+		ng.ServeHTTP(w, r)
+		reading <- body.reads.Load()
+	}))
+	defer ts.Close()
+
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go io.WriteString(pw, `{"urls": ["http://n/0"`)
+	start := time.Now()
+	resp, err := http.Post(ts.URL+"/numbers", "application/json", pr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("handler held past its deadline: %s", comp("< 1s", elapsed))
+	}
+
+	var got numbersResponse
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if !reflect.DeepEqual(expNumbers, got.Numbers) || got.Warning != timeoutWarning {
+		t.Fatalf("response mismatch: %s", comp(numbersResponse{Numbers: expNumbers, Warning: timeoutWarning}, got))
+	}
+	if n := <-reading; n != 0 {
+		t.Fatalf("body still read after the handler returned: %s", comp(0, n))
+	}
+}
+
+func TestServeHTTPPostInvalid(t *testing.T) {
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		MaxHosts:        2,
+		MaxURLs:         3,
+		MaxRequestBytes: 100,
+		URLGetter:       &startGetter{started: make(chan struct{})},
+	}}
+
+	for _, body := range []string{
+		`["http://a"]`,
+		`{"urls": ["http://a", 1]}`,
+		`{"urls": ["http://a", "http://b", "http://c"]}`,
+		`{"urls": ["http://a/1", "http://a/2", "http://a/3", "http://a/4"]}`,
+		`{"urls": ["range:0-49999", "range:0-50000"]}`,
+		`{"urls": ["range:9-0"]}`,
+		`{"urls": ["http://a/` + strings.Repeat("x", 100) + `"]}`,
+	} {
+		w := httptest.NewRecorder()
+		ng.ServeHTTP(w, httptest.NewRequest("POST", "/numbers", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("status mismatch for %s: %s", body, comp(http.StatusBadRequest, w.Code))
+		}
+	}
+
+	// Malformed forms are refused rather than taking the server down.
+	w := httptest.NewRecorder()
+	ng.ServeHTTP(w, httptest.NewRequest("POST", "/numbers?u=%zz", strings.NewReader(`{"urls": []}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status mismatch for a malformed query: %s", comp(http.StatusBadRequest, w.Code))
+	}
+	r := httptest.NewRequest("POST", "/numbers", strings.NewReader("u=%zz"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	ng.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status mismatch for a malformed form body: %s", comp(http.StatusBadRequest, w.Code))
+	}
+}
+
+func TestServeHTTPPostDebug(t *testing.T) {
+	exp := map[string][]int{"http://n/1": {0, 2}, "http://n/2": {1}}
+	expLogged := "[http://n/1 http://n/2 http://n/1]"
+
+	var buf strings.Builder
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		URLGetter:       &startGetter{started: make(chan struct{})},
+		Logger:          slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}}
+
+	w := httptest.NewRecorder()
+	ng.ServeHTTP(w, httptest.NewRequest("POST", "/numbers?debug=1", strings.NewReader(`{"urls": ["http://n/1", "http://n/2", "http://n/1"]}`)))
+	var resp numbersResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+
+	// Every position of the body has its own result.
+	got := make(map[string][]int)
+	for _, r := range resp.Debug {
+		got[r.URL] = append(got[r.URL], r.Indices...)
+		sort.Ints(got[r.URL])
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("debug indices mismatch: %s", comp(exp, got))
+	}
+	if logged := buf.String(); !strings.Contains(logged, expLogged) {
+		t.Fatalf("logged URLs mismatch: %s", comp(expLogged, logged))
+	}
+}
+
+// startGetter responds to GETs for http://n/<i> with the number i, and
+// closes started on the first GET.
+type startGetter struct {
+	once    sync.Once
+	started chan struct{}
+}
+
+func (s *startGetter) Get(ctx context.Context, url string) ([]byte, error) {
+	s.once.Do(func() { close(s.started) })
+	return []byte(`{"numbers":[` + strings.TrimPrefix(url, "http://n/") + `]}`), nil
+}
+
+func (s *startGetter) Client() *http.Client {
+	return nil
+}
+
+// readingBody counts the reads of the embedded body in progress.
+type readingBody struct {
+	io.ReadCloser
+	reads atomic.Int32
+}
+
+func (b *readingBody) Read(p []byte) (int, error) {
+	b.reads.Add(1)
+	defer b.reads.Add(-1)
+	return b.ReadCloser.Read(p)
+}
@@ -0,0 +1,33 @@
+// This file contains the XML encoder of the responses, for consumers that
+// cannot read JSON. It is part of the package since encoding/xml is in the
+// standard library.
+package numbers
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+// xmlContentType is the media type the XML encoder is registered for.
+const xmlContentType = "application/xml"
+
+func init() {
+	RegisterEncoder(xmlContentType, xmlEncoder{})
+}
+
+// xmlNumbers is the XML document of a response, <numbers><n>1</n>...</numbers>.
+type xmlNumbers struct {
+	XMLName xml.Name `xml:"numbers"`
+	Numbers []int    `xml:"n"`
+}
+
+// xmlEncoder implements Encoder for XML.
+type xmlEncoder struct{}
+
+// Encode writes numbers to w as an XML document.
+func (xmlEncoder) Encode(w io.Writer, numbers []int) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(xmlNumbers{Numbers: numbers})
+}
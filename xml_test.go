@@ -0,0 +1,35 @@
+// Tests for the XML responses.
+package numbers
+
+import (
+	"encoding/xml"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestServeHTTPXML(t *testing.T) {
+	exp := []int{1, 2, 3, 4}
+
+	ng := &NumbersGetter{Config: Config{
+		ResponseTimeout: 500 * time.Millisecond,
+		URLGetter:       staticGetter{"http://a": `{"numbers":[3,1]}`, "http://b": `{"numbers":[4,2]}`},
+	}}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/numbers?u=http://a&u=http://b", nil)
+	r.Header.Set("Accept", "application/xml")
+	ng.ServeHTTP(w, r)
+
+	if got := w.Header().Get("Content-Type"); got != xmlContentType {
+		t.Fatalf("content type mismatch: %s", comp(xmlContentType, got))
+	}
+	var doc xmlNumbers
+	if err := xml.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid XML response %q: %v", w.Body.String(), err)
+	}
+	if !reflect.DeepEqual(exp, doc.Numbers) {
+		t.Fatalf("numbers mismatch: %s", comp(exp, doc.Numbers))
+	}
+}